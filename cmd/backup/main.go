@@ -0,0 +1,93 @@
+package main
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/database"
+	"atomic-go-template/internal/server"
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+const usage = `Usage: backup <command>
+
+Commands:
+  create [file]    back up the SQLite database to the file, otherwise to Database.Backup.Dir
+  list             list the backups in Database.Backup.Dir
+  restore <file>   replace the SQLite database with the backup, the server has to be stopped
+
+Backups are made while the server is running. The database replaced by a restore is kept next to it`
+
+func main() {
+	if len(os.Args) < 2 {
+		fail(usage)
+	}
+	config := server.NewConfig()
+	if !config.Database.Enabled || config.Database.Type != "sqlite" {
+		fail("backups need the SQLite database, use the tools of Postgres or MySQL")
+	}
+	ctx := context.Background()
+	backups := database.NewBackups(nil, database.BackupOptionsFromConfig(config.Database.Backup))
+
+	switch os.Args[1] {
+	case "create":
+		if len(os.Args) > 3 {
+			fail(usage)
+		}
+		create(ctx, config, os.Args[2:])
+	case "list":
+		list, err := backups.List()
+		if err != nil {
+			fail(err.Error())
+		}
+		for _, backup := range list {
+			fmt.Printf("%s\t%d bytes\n", backup.Name, backup.Size)
+		}
+		if len(list) == 0 {
+			fmt.Println("No backups in", config.Database.Backup.Dir)
+		}
+	case "restore":
+		if len(os.Args) != 3 {
+			fail(usage)
+		}
+		file := database.SQLiteFile(config.Database)
+		replaced, err := database.RestoreSQLite(ctx, os.Args[2], file)
+		if err != nil {
+			fail(err.Error())
+		}
+		fmt.Printf("Restored %s from %s\n", file, os.Args[2])
+		if replaced != "" {
+			fmt.Println("The replaced database was moved to", replaced)
+		}
+	default:
+		fail(usage)
+	}
+}
+
+func create(ctx context.Context, config *config.Config, args []string) {
+	db, err := database.New(ctx, config.Database)
+	if err != nil {
+		fail(err.Error())
+	}
+	defer db.Close()
+
+	var path string
+	if len(args) == 1 {
+		path = args[0]
+		err = database.BackupSQLite(ctx, db.GetDB(), path)
+	} else {
+		// Named like the scheduled backups, so they count for the retention
+		backups := database.NewBackups(db.GetDB(), database.BackupOptionsFromConfig(config.Database.Backup))
+		path, err = backups.Create(ctx, time.Now())
+	}
+	if err != nil {
+		fail(err.Error())
+	}
+	fmt.Println("Backed up to", path)
+}
+
+func fail(message string) {
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(1)
+}
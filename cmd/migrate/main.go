@@ -0,0 +1,105 @@
+package main
+
+import (
+	"atomic-go-template/internal/database"
+	"atomic-go-template/internal/server"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const usage = `Usage: migrate <command>
+
+Commands:
+  up             apply the pending migrations
+  down [steps]   revert the last migration or the last steps migrations
+  status         list the migrations and when they were applied
+  create <name>  create empty up and down files for every dialect in ` + database.MigrationsDir
+
+func main() {
+	if len(os.Args) < 2 {
+		fail(usage)
+	}
+	// Creating files needs no database
+	if os.Args[1] == "create" {
+		if len(os.Args) != 3 {
+			fail(usage)
+		}
+		paths, err := database.CreateMigration(database.MigrationsDir, os.Args[2], time.Now())
+		if err != nil {
+			fail(fmt.Sprintf("cannot create migration: %s", err))
+		}
+		for _, path := range paths {
+			fmt.Println("Created", path)
+		}
+		return
+	}
+
+	config := server.NewConfig()
+	if !config.Database.Enabled {
+		fail("the database is disabled")
+	}
+	ctx := context.Background()
+	db, err := database.New(ctx, config.Database)
+	if err != nil {
+		fail(err.Error())
+	}
+	defer db.Close()
+	migrator, err := database.NewMigrator(db.GetDB(), database.MigratorOptions{})
+	if err != nil {
+		fail(err.Error())
+	}
+
+	switch os.Args[1] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("Applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fail(err.Error())
+		}
+		if len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			steps, err = strconv.Atoi(os.Args[2])
+			if err != nil || steps < 1 {
+				fail(usage)
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("Reverted %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fail(err.Error())
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			fail(err.Error())
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			if status.Missing {
+				state += ", file missing"
+			}
+			fmt.Printf("%d_%s\t%s\n", status.Version, status.Name, state)
+		}
+	default:
+		fail(usage)
+	}
+}
+
+func fail(message string) {
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(1)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"atomic-go-template/internal/database"
+	"atomic-go-template/internal/seed"
+	"atomic-go-template/internal/server"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const usage = `Usage: seed [command]
+
+Commands:
+  (none)            run all seeders of APP_ENV, it has to be set
+  run <seeder>...   run the named seeders
+  fixture <name>    run the seeders of a fixture set
+  list              list the seeders and fixture sets
+
+Seeders are idempotent, running them again skips existing rows. Seeded users have the password "` + seed.Password + `"`
+
+func main() {
+	command := "run"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+	if command == "list" {
+		list()
+		return
+	}
+	if command != "run" && command != "fixture" {
+		fail(usage)
+	}
+
+	// An empty APP_ENV defaults to "local", whose seeders add an admin with a known password. A server that forgot to
+	// set it must not get one
+	if os.Getenv("APP_ENV") == "" {
+		fail("APP_ENV is not set, set it to the environment to seed, f.e. APP_ENV=local")
+	}
+
+	config := server.NewConfig()
+	if !config.Database.Enabled {
+		fail("the database is disabled")
+	}
+	ctx := context.Background()
+	db, err := database.New(ctx, config.Database)
+	if err != nil {
+		fail(err.Error())
+	}
+	defer db.Close()
+	// A new database file needs the tables first
+	if config.Database.AutoMigrate {
+		if err := database.Migrate(ctx, db.GetDB()); err != nil {
+			fail(err.Error())
+		}
+	}
+
+	var ran []string
+	switch command {
+	case "run":
+		var names []string
+		if len(os.Args) > 2 {
+			names = os.Args[2:]
+		}
+		ran, err = seed.Run(ctx, db.GetDB(), config, names...)
+	case "fixture":
+		if len(os.Args) != 3 {
+			fail(usage)
+		}
+		ran, err = seed.LoadFixture(ctx, db.GetDB(), config, os.Args[2])
+	}
+	for _, name := range ran {
+		fmt.Println("Seeded", name)
+	}
+	if err != nil {
+		fail(err.Error())
+	}
+	if len(ran) == 0 {
+		fmt.Printf("No seeders for the %s environment\n", config.App.Env)
+	}
+}
+
+func list() {
+	fmt.Println("Seeders:")
+	for _, seeder := range seed.Seeders {
+		fmt.Printf("  %-12s %s (%s)\n", seeder.Name, seeder.Description, strings.Join(seeder.Environments, ", "))
+	}
+	fmt.Println("Fixtures:")
+	names := make([]string, 0, len(seed.Fixtures))
+	for name := range seed.Fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-12s %s\n", name, strings.Join(seed.Fixtures[name], ", "))
+	}
+}
+
+func fail(message string) {
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(1)
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/store"
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Authenticator checks the credentials entered on the login page and returns the matching user
+type Authenticator interface {
+	Authenticate(ctx context.Context, email, password string) (model.User, error)
+}
+
+// ErrInvalidCredentials is returned when the email or password is wrong.
+// Authenticators should not reveal which one it was
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+// New returns the authenticator for the config.
+// With LDAP enabled the directory is asked first, local accounts are used as fallback if AllowLocalLogin is set
+func New(db *gorm.DB, c *config.Config) (Authenticator, error) {
+	if !c.LDAP.EnableLDAP {
+		return NewLocalAuthenticator(store.NewGormUserStore(db)), nil
+	}
+	ldapAuthenticator, err := NewLDAPAuthenticator(db, LDAPOptionsFromConfig(c.LDAP))
+	if err != nil {
+		return nil, err
+	}
+	if !c.LDAP.AllowLocalLogin {
+		return ldapAuthenticator, nil
+	}
+	return NewChain(ldapAuthenticator, NewLocalAuthenticator(store.NewGormUserStore(db))), nil
+}
+
+// Chain tries the authenticators in order and returns the first user that could be authenticated
+type Chain struct {
+	authenticators []Authenticator
+}
+
+func NewChain(authenticators ...Authenticator) *Chain {
+	return &Chain{authenticators: authenticators}
+}
+
+func (c *Chain) Authenticate(ctx context.Context, email, password string) (model.User, error) {
+	for _, authenticator := range c.authenticators {
+		user, err := authenticator.Authenticate(ctx, email, password)
+		if err == nil {
+			return user, nil
+		}
+		// A failing backend like an unreachable directory should not lock out the others
+		if !errors.Is(err, ErrInvalidCredentials) {
+			fmt.Printf("Error authenticating with %T: %v\n", authenticator, err)
+		}
+	}
+	return model.User{}, ErrInvalidCredentials
+}
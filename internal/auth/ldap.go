@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/model"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+// LDAPOptions configure the connection to the directory and how entries map to users
+type LDAPOptions struct {
+	// URL of the directory, f.e. ldaps://ldap.example.org or ldap://ldap.example.org:389
+	URL string
+	// Service account used to search for users. Anonymous search if empty
+	BindDN       string
+	BindPassword string
+	// Where to search for users
+	BaseDN string
+	// Upgrade ldap:// connections with StartTLS
+	StartTLS bool
+	// Only for testing against directories with self signed certificates
+	InsecureSkipVerify bool
+	// Filter to find the user, %s is replaced with the escaped email
+	UserFilter        string
+	UsernameAttribute string
+	EmailAttribute    string
+	GroupAttribute    string
+	// Maps lower case group DNs to roles
+	GroupRoles map[string]model.Role
+	// Timeout for connecting and each request
+	Timeout time.Duration
+}
+
+// LDAPOptionsFromConfig reads the connection from the environment and the mapping from the config
+func LDAPOptionsFromConfig(c config.LDAP) LDAPOptions {
+	groupRoles := make(map[string]model.Role, len(c.GroupRoles))
+	for group, role := range c.GroupRoles {
+		groupRoles[strings.ToLower(group)] = model.Role(role)
+	}
+	return LDAPOptions{
+		URL:               os.Getenv("LDAP_URL"),
+		BindDN:            os.Getenv("LDAP_BIND_DN"),
+		BindPassword:      os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:            os.Getenv("LDAP_BASE_DN"),
+		StartTLS:          c.StartTLS,
+		UserFilter:        c.UserFilter,
+		UsernameAttribute: c.UsernameAttribute,
+		EmailAttribute:    c.EmailAttribute,
+		GroupAttribute:    c.GroupAttribute,
+		GroupRoles:        groupRoles,
+		Timeout:           10 * time.Second,
+	}
+}
+
+// LDAPAuthenticator binds as the user against a directory and creates the local user on the first login
+type LDAPAuthenticator struct {
+	db      *gorm.DB
+	options LDAPOptions
+}
+
+func NewLDAPAuthenticator(db *gorm.DB, options LDAPOptions) (*LDAPAuthenticator, error) {
+	if options.URL == "" || options.BaseDN == "" {
+		return nil, errors.New("ldap: URL and BaseDN are required")
+	}
+	if !strings.Contains(options.UserFilter, "%s") {
+		return nil, errors.New("ldap: UserFilter must contain %s")
+	}
+	if options.Timeout == 0 {
+		options.Timeout = 10 * time.Second
+	}
+	return &LDAPAuthenticator{db: db, options: options}, nil
+}
+
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, email, password string) (model.User, error) {
+	// An empty password would be an unauthenticated bind, which succeeds on most servers
+	if password == "" {
+		return model.User{}, ErrInvalidCredentials
+	}
+
+	conn, err := a.connect()
+	if err != nil {
+		return model.User{}, err
+	}
+	defer conn.Close()
+
+	entry, err := a.findUser(conn, email)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	// Check the password by binding as the user
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return model.User{}, ErrInvalidCredentials
+		}
+		return model.User{}, err
+	}
+
+	return a.provision(ctx, entry)
+}
+
+// connect dials the directory and binds with the service account
+func (a *LDAPAuthenticator) connect() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.options.InsecureSkipVerify}
+	conn, err := ldap.DialURL(a.options.URL, ldap.DialWithTLSConfig(tlsConfig), ldap.DialWithDialer(&net.Dialer{Timeout: a.options.Timeout}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.options.Timeout)
+
+	if a.options.StartTLS && strings.HasPrefix(a.options.URL, "ldap://") {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if a.options.BindDN != "" {
+		err = conn.Bind(a.options.BindDN, a.options.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ldap: service bind failed: %w", err)
+	}
+	return conn, nil
+}
+
+// findUser searches the user entry, the email has to match exactly one entry
+func (a *LDAPAuthenticator) findUser(conn *ldap.Conn, email string) (*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(
+		a.options.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(a.options.Timeout.Seconds()), false,
+		fmt.Sprintf(a.options.UserFilter, ldap.EscapeFilter(email)),
+		[]string{a.options.UsernameAttribute, a.options.EmailAttribute, a.options.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return result.Entries[0], nil
+}
+
+// provision creates the local user on the first login and updates the role from the groups
+func (a *LDAPAuthenticator) provision(ctx context.Context, entry *ldap.Entry) (model.User, error) {
+	email := entry.GetAttributeValue(a.options.EmailAttribute)
+	if email == "" {
+		return model.User{}, fmt.Errorf("ldap: entry %s has no %s attribute", entry.DN, a.options.EmailAttribute)
+	}
+
+	external := ExternalUser{
+		Provider: "ldap",
+		ID:       entry.DN,
+		Email:    email,
+		Username: entry.GetAttributeValue(a.options.UsernameAttribute),
+		// The directory is the source of truth for the accounts of the organization
+		LinkExisting: true,
+	}
+	if len(a.options.GroupRoles) > 0 {
+		role := RoleForGroups(entry.GetAttributeValues(a.options.GroupAttribute), a.options.GroupRoles)
+		external.Role = &role
+	}
+	return Provision(ctx, a.db, external)
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/store"
+	"atomic-go-template/internal/utils"
+	"context"
+)
+
+// LocalAuthenticator checks the password stored with the user
+type LocalAuthenticator struct {
+	users store.UserStore
+}
+
+func NewLocalAuthenticator(users store.UserStore) *LocalAuthenticator {
+	return &LocalAuthenticator{users: users}
+}
+
+func (a *LocalAuthenticator) Authenticate(ctx context.Context, email, password string) (model.User, error) {
+	user, err := a.users.ByEmail(ctx, email)
+	if err != nil {
+		return model.User{}, ErrInvalidCredentials
+	}
+	// Users from OAuth or LDAP have no local password
+	if user.Password == nil {
+		return model.User{}, ErrInvalidCredentials
+	}
+	if err := utils.CheckPasswordHash(*user.Password, password); err != nil {
+		return model.User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
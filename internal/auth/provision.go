@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"atomic-go-template/internal/model"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrAccountExists is returned if an account of another source has the email and the source may not link it
+	ErrAccountExists = errors.New("auth: an account with the email exists")
+	// ErrUnverifiedAccount is returned if the account with the email is not verified, it is not linked.
+	// Whoever registered it may not own the email and would keep their password
+	ErrUnverifiedAccount = errors.New("auth: the account with the email is not verified")
+)
+
+// ExternalUser is a user as described by a directory or identity provider
+type ExternalUser struct {
+	// Name of the source, stored as OAuthProvider, f.e. "ldap" or "saml:okta"
+	Provider string
+	// Stable ID of the user at the source, stored as OAuthID
+	ID    string
+	Email string
+	// Username for new users. The local part of the email is used if empty
+	Username string
+	// Role from the group mapping. Nil keeps the current role
+	Role *model.Role
+	// Link an existing account with the email that was not created by this source.
+	// Only for sources that verify the emails of their users
+	LinkExisting bool
+}
+
+// Provision creates the local user on the first login and updates the role and email.
+// The source is trusted, so the email of new users counts as verified. Existing verified accounts are linked by email
+func Provision(ctx context.Context, db *gorm.DB, external ExternalUser) (model.User, error) {
+	db = db.WithContext(ctx)
+	if external.Email == "" {
+		return model.User{}, errors.New("auth: external user has no email")
+	}
+
+	// Users of the source are found by their ID, the email may have changed since
+	user := model.User{}
+	err := db.First(&user, "o_auth_provider = ? AND o_auth_id = ?", external.Provider, external.ID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = db.First(&user, "email = ?", external.Email).Error
+		if err == nil && !canLink(user, external) {
+			return model.User{}, ErrAccountExists
+		}
+		if err == nil && user.VerifiedAt == nil {
+			return model.User{}, ErrUnverifiedAccount
+		}
+		if err == nil {
+			// Later logins find the linked account by the ID
+			user.OAuthProvider = &external.Provider
+			user.OAuthID = &external.ID
+		}
+	} else if err == nil && user.Email != external.Email {
+		// The email changed at the source. It is kept if another account has the new one
+		var taken int64
+		if err := db.Model(&model.User{}).Where("email = ? AND id <> ?", external.Email, user.ID).Count(&taken).Error; err != nil {
+			return model.User{}, err
+		}
+		if taken == 0 {
+			user.Email = external.Email
+		}
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.User{}, err
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		now := time.Now()
+		user = model.User{
+			Username:      uniqueUsername(db, external.Username, external.Email),
+			Email:         external.Email,
+			VerifiedAt:    &now,
+			OAuthProvider: &external.Provider,
+			OAuthID:       &external.ID,
+			Role:          model.RoleUser,
+		}
+	}
+
+	if external.Role != nil {
+		user.Role = *external.Role
+	}
+
+	if err := db.Save(&user).Error; err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// canLink reports if the source may log in as the existing account with the same email
+func canLink(user model.User, external ExternalUser) bool {
+	// Accounts created by the source, f.e. with a NameID that changes on every login
+	if user.OAuthProvider != nil && *user.OAuthProvider == external.Provider {
+		return true
+	}
+	return external.LinkExisting
+}
+
+// RoleForGroups returns the highest role of the groups, admin wins over user.
+// The keys of groupRoles have to be lower case
+func RoleForGroups(groups []string, groupRoles map[string]model.Role) model.Role {
+	role := model.RoleUser
+	for _, group := range groups {
+		if mapped, ok := groupRoles[strings.ToLower(group)]; ok && mapped == model.RoleAdmin {
+			role = model.RoleAdmin
+		}
+	}
+	return role
+}
+
+// uniqueUsername returns the username, with a suffix if it is already taken
+func uniqueUsername(db *gorm.DB, username, email string) string {
+	if username == "" {
+		username = strings.Split(email, "@")[0]
+	}
+	var count int64
+	db.Model(&model.User{}).Where("username = ?", username).Count(&count)
+	if count == 0 {
+		return username
+	}
+	return username + "-" + uuid.New().String()[:8]
+}
@@ -0,0 +1,323 @@
+// Package broadcast sends mails of admins to all users of a segment. A background worker sends them in batches
+// through mail.Service and skips users who unsubscribed from the category or whose address is suppressed.
+// With the outbox the mails are only queued, mail.Outbox counts them on the broadcast once it delivered them
+package broadcast
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/mail"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/notification"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Users who did not log in for this long are in the inactive segment
+const InactiveAfter = 30 * 24 * time.Hour
+
+// Segments are shown on the compose screen in this order
+var Segments = []struct {
+	Segment model.BroadcastSegment
+	Name    string
+}{
+	{model.BroadcastSegmentAll, "All users"},
+	{model.BroadcastSegmentVerified, "Verified users"},
+	{model.BroadcastSegmentInactive, "Inactive for 30 days"},
+	{model.BroadcastSegmentDomain, "Members of an organization (email domain)"},
+}
+
+var (
+	// ErrInvalidSegment is returned for unknown segments and invalid domains
+	ErrInvalidSegment = errors.New("invalid broadcast segment")
+	// ErrBroadcastNotFound is returned by Cancel for unknown or finished broadcasts
+	ErrBroadcastNotFound = errors.New("broadcast not found")
+)
+
+type Options struct {
+	// How often the worker looks for queued broadcasts. New broadcasts wake it up right away
+	PollInterval time.Duration
+	// Users per batch, the progress is saved after every batch
+	BatchSize int
+	// Pause between batches, so the provider is not flooded
+	BatchInterval time.Duration
+	// How long a batch is locked. Another worker takes the broadcast over afterwards
+	LockTimeout time.Duration
+}
+
+func OptionsFromConfig(c config.Broadcast) Options {
+	return Options{
+		PollInterval:  5 * time.Second,
+		BatchSize:     c.BatchSize,
+		BatchInterval: c.BatchInterval,
+		LockTimeout:   5 * time.Minute,
+	}
+}
+
+// Renderer renders the mail of the broadcast for the user, see emails.Mailer.RenderBroadcast
+type Renderer func(user model.User, broadcast model.Broadcast) (mail.Message, error)
+
+type Worker struct {
+	db      *gorm.DB
+	mail    mail.Service
+	render  Renderer
+	options Options
+	wake    chan struct{}
+}
+
+func NewWorker(db *gorm.DB, mailService mail.Service, render Renderer, options Options) *Worker {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 50
+	}
+	if options.LockTimeout <= 0 {
+		options.LockTimeout = 5 * time.Minute
+	}
+	return &Worker{
+		db:      db,
+		mail:    mailService,
+		render:  render,
+		options: options,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Normalize checks the category and segment of the broadcast and cleans up the domain of the segment
+func Normalize(broadcast *model.Broadcast) error {
+	if _, err := notification.CategoryByKey(broadcast.Category); err != nil {
+		return err
+	}
+	switch broadcast.Segment {
+	case model.BroadcastSegmentAll, model.BroadcastSegmentVerified, model.BroadcastSegmentInactive:
+		broadcast.SegmentDomain = ""
+	case model.BroadcastSegmentDomain:
+		domain := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(broadcast.SegmentDomain), "@"))
+		if !strings.Contains(domain, ".") || strings.ContainsAny(domain, "@%_ \t") {
+			return fmt.Errorf("%w: invalid domain %q", ErrInvalidSegment, broadcast.SegmentDomain)
+		}
+		broadcast.SegmentDomain = domain
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidSegment, broadcast.Segment)
+	}
+	return nil
+}
+
+// Recipients selects the users in the segment of the broadcast
+func Recipients(db *gorm.DB, broadcast model.Broadcast) *gorm.DB {
+	query := db.Model(&model.User{})
+	switch broadcast.Segment {
+	case model.BroadcastSegmentVerified:
+		query = query.Where("verified_at IS NOT NULL")
+	case model.BroadcastSegmentInactive:
+		// Users who never logged in count from their signup
+		since := time.Now().Add(-InactiveAfter)
+		query = query.Where("last_login_at < ? OR (last_login_at IS NULL AND created_at < ?)", since, since)
+	case model.BroadcastSegmentDomain:
+		query = query.Where("LOWER(email) LIKE ?", "%@"+broadcast.SegmentDomain)
+	}
+	return query
+}
+
+// Queue stores the broadcast for the worker
+func (w *Worker) Queue(broadcast *model.Broadcast) error {
+	if err := Normalize(broadcast); err != nil {
+		return err
+	}
+	var total int64
+	if err := Recipients(w.db, *broadcast).Count(&total).Error; err != nil {
+		return err
+	}
+	broadcast.Status = model.BroadcastStatusQueued
+	broadcast.Total = int(total)
+	if err := w.db.Create(broadcast).Error; err != nil {
+		return fmt.Errorf("broadcast: queueing: %w", err)
+	}
+	w.notify()
+	return nil
+}
+
+// Cancel stops a queued or sending broadcast, the remaining users don't get it
+func (w *Worker) Cancel(id uuid.UUID) error {
+	result := w.db.Model(&model.Broadcast{}).
+		Where("id = ? AND status IN ?", id, []model.BroadcastStatus{model.BroadcastStatusQueued, model.BroadcastStatusSending}).
+		Updates(map[string]interface{}{
+			"status":       model.BroadcastStatusCancelled,
+			"finished_at":  time.Now(),
+			"locked_until": nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrBroadcastNotFound
+	}
+	return nil
+}
+
+// Run sends the queued broadcasts batch by batch until the context is canceled
+func (w *Worker) Run(ctx context.Context) {
+	interval := w.options.PollInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			processed, err := w.ProcessBatch(ctx)
+			if err != nil {
+				log.Printf("Error sending broadcast: %v", err)
+			}
+			if !processed || err != nil {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(w.options.BatchInterval):
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// ProcessBatch sends the next batch of the oldest queued or sending broadcast.
+// It returns false if there was nothing to send
+func (w *Worker) ProcessBatch(ctx context.Context) (bool, error) {
+	db := w.db.WithContext(ctx)
+	now := time.Now()
+	var broadcast model.Broadcast
+	if err := w.active(db, now).Order("created_at").Limit(1).Find(&broadcast).Error; err != nil {
+		return false, err
+	}
+	if broadcast.ID == uuid.Nil {
+		return false, nil
+	}
+	if claimed, err := w.claim(db, broadcast, now); err != nil || !claimed {
+		return false, err
+	}
+	update := db.Model(&model.Broadcast{}).Where("id = ?", broadcast.ID)
+
+	query := Recipients(db, broadcast).Order("id").Limit(w.options.BatchSize)
+	if broadcast.Cursor != nil {
+		query = query.Where("id > ?", *broadcast.Cursor)
+	}
+	var users []model.User
+	if err := query.Find(&users).Error; err != nil {
+		return false, err
+	}
+	if len(users) == 0 {
+		// A cancel in the meantime is kept
+		return true, update.Where("status = ?", model.BroadcastStatusSending).Updates(map[string]interface{}{
+			"status":       model.BroadcastStatusSent,
+			"finished_at":  time.Now(),
+			"locked_until": nil,
+		}).Error
+	}
+
+	skip, err := w.skipped(db, broadcast, users)
+	if err != nil {
+		return false, err
+	}
+	// The outbox counts its mails on the broadcast once they are delivered or dead-lettered,
+	// only errors while queueing are counted here
+	_, queued := w.mail.(*mail.Outbox)
+	sent, skipped, failed := 0, 0, 0
+	var lastError error
+	for _, user := range users {
+		if skip[user.ID] {
+			skipped++
+			continue
+		}
+		message, err := w.render(user, broadcast)
+		if err == nil {
+			err = w.mail.SendMessage(message)
+		}
+		if err != nil {
+			log.Printf("Error sending broadcast %s to %s: %v", broadcast.ID, user.Email, err)
+			failed++
+			lastError = err
+			continue
+		}
+		if !queued {
+			sent++
+		}
+	}
+
+	updates := map[string]interface{}{
+		"cursor":       users[len(users)-1].ID,
+		"sent":         gorm.Expr("sent + ?", sent),
+		"skipped":      gorm.Expr("skipped + ?", skipped),
+		"failed":       gorm.Expr("failed + ?", failed),
+		"locked_until": nil,
+	}
+	if lastError != nil {
+		updates["last_error"] = lastError.Error()
+	}
+	return true, update.Updates(updates).Error
+}
+
+// active selects queued broadcasts and sending ones that are not locked by another worker
+func (w *Worker) active(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.Where(
+		"status = ? OR (status = ? AND (locked_until IS NULL OR locked_until < ?))",
+		model.BroadcastStatusQueued, model.BroadcastStatusSending, now,
+	)
+}
+
+// claim locks the broadcast for one batch. The conditional update makes sure only one worker gets it
+func (w *Worker) claim(db *gorm.DB, broadcast model.Broadcast, now time.Time) (bool, error) {
+	updates := map[string]interface{}{
+		"status":       model.BroadcastStatusSending,
+		"locked_until": now.Add(w.options.LockTimeout),
+	}
+	if broadcast.StartedAt == nil {
+		updates["started_at"] = now
+	}
+	result := w.active(db.Model(&model.Broadcast{}).Where("id = ?", broadcast.ID), now).Updates(updates)
+	return result.RowsAffected == 1, result.Error
+}
+
+// skipped returns the users who unsubscribed from the category or whose address is suppressed
+func (w *Worker) skipped(db *gorm.DB, broadcast model.Broadcast, users []model.User) (map[uuid.UUID]bool, error) {
+	ids := make([]uuid.UUID, 0, len(users))
+	emails := make([]string, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+		emails = append(emails, strings.ToLower(user.Email))
+	}
+	subscribed, err := notification.SubscribedUsers(db, ids, broadcast.Category)
+	if err != nil {
+		return nil, err
+	}
+	var suppressed []string
+	if err := db.Model(&model.MailSuppression{}).Where("email IN ?", emails).Pluck("email", &suppressed).Error; err != nil {
+		return nil, err
+	}
+	isSuppressed := map[string]bool{}
+	for _, email := range suppressed {
+		isSuppressed[email] = true
+	}
+	skip := map[uuid.UUID]bool{}
+	for _, user := range users {
+		skip[user.ID] = !subscribed[user.ID] || isSuppressed[strings.ToLower(user.Email)]
+	}
+	return skip, nil
+}
+
+func (w *Worker) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
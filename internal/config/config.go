@@ -2,8 +2,12 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"reflect"
+	"slices"
+	"strings"
+	"time"
 )
 
 // For example Email-Provider and Forget Password or Verify Mail
@@ -22,6 +26,14 @@ type Config struct {
 	Auth Auth
 	// Mail Settings
 	Mail Mail
+	// Legal Settings
+	Legal Legal
+	// OpenID Connect Provider Settings
+	OIDC OIDC
+	// LDAP / Active Directory Settings
+	LDAP LDAP
+	// SAML Single Sign-On Settings
+	SAML SAML
 }
 
 type App struct {
@@ -29,6 +41,13 @@ type App struct {
 	Name string
 	// App URL. Default "http://localhost:8080"
 	Url string
+	// Environment from APP_ENV, f.e. "local", "staging" or "production". Default "local"
+	Env string
+}
+
+// IsProduction returns true if APP_ENV is "production". Development tools like the mail inbox are disabled then
+func (c *Config) IsProduction() bool {
+	return c.App.Env == "production"
 }
 
 type Server struct {
@@ -41,12 +60,95 @@ type DatabaseType string
 const (
 	DatabaseTypeSQLite   DatabaseType = "sqlite"
 	DatabaseTypePostgres DatabaseType = "postgres"
+	// MySQL and MariaDB
+	DatabaseTypeMySQL DatabaseType = "mysql"
+)
+
+type DatabaseSSLMode string
+
+// The sslmode values of libpq, see https://www.postgresql.org/docs/current/libpq-ssl.html
+const (
+	DatabaseSSLModeDisable    DatabaseSSLMode = "disable"
+	DatabaseSSLModeAllow      DatabaseSSLMode = "allow"
+	DatabaseSSLModePrefer     DatabaseSSLMode = "prefer"
+	DatabaseSSLModeRequire    DatabaseSSLMode = "require"
+	DatabaseSSLModeVerifyCA   DatabaseSSLMode = "verify-ca"
+	DatabaseSSLModeVerifyFull DatabaseSSLMode = "verify-full"
 )
 
 type Database struct {
 	Enabled bool
 	// Database Type. Default "sqlite"
 	Type DatabaseType
+	// Apply pending migrations on startup. Default true, otherwise run `go run ./cmd/migrate up` before deploying
+	AutoMigrate bool
+	// Connection string, f.e. "postgres://user:password@db:5432/app?sslmode=verify-full", "mysql://user:password@db:3306/app"
+	// or "file:db/app.sqlite".
+	// Default DATABASE_URL, otherwise it is built from the fields below. Settings in the connection string take precedence
+	DSN string
+	// Server of Postgres and MySQL, used without DSN. Default DB_HOST, DB_PORT, DB_DATABASE, DB_USERNAME and DB_PASSWORD
+	Host     string
+	Port     string
+	Name     string
+	Username string
+	Password string
+	// Postgres search_path. Default DB_SCHEMA
+	Schema string
+	// SQLite file, used without DSN. Default DB_FILE, otherwise "db/dev.sqlite"
+	File string
+	// TLS of Postgres and MySQL connections. Default DB_SSLMODE, otherwise DatabaseSSLModeDisable
+	SSLMode DatabaseSSLMode
+	// CA certificate file to verify the server with DatabaseSSLModeVerifyCA and DatabaseSSLModeVerifyFull. Default DB_SSLROOTCERT
+	SSLRootCert string
+	// Client certificate and key files, if the server requires them. Default DB_SSLCERT and DB_SSLKEY
+	SSLCert string
+	SSLKey  string
+	// Maximum of open connections. Default 25
+	MaxOpenConns int
+	// Maximum of idle connections kept in the pool. Default 10
+	MaxIdleConns int
+	// Connections are closed after this time, f.e. to follow a failover of the database. Default 1 hour
+	ConnMaxLifetime time.Duration
+	// Idle connections are closed after this time. Default 10 minutes
+	ConnMaxIdleTime time.Duration
+	// Postgres cancels statements running longer, MySQL only queries. Default 0, no timeout
+	StatementTimeout time.Duration
+	// Postgres read replicas, reads are routed to them and writes and transactions to the primary.
+	// The settings above apply to them as well. Default DB_REPLICA_URLS, comma separated
+	Replicas []string
+	// Reads of a session go to the primary for this long after a mutation, so users see their changes. Default 5 seconds
+	ReadYourWritesWindow time.Duration
+	// Replicas lagging further behind the primary are skipped until they catch up. Default 30 seconds
+	ReplicaMaxLag time.Duration
+	// How often the replicas are checked. Default 10 seconds
+	ReplicaCheckInterval time.Duration
+	// SQLite Settings
+	SQLite SQLite
+	// Scheduled backups of SQLite
+	Backup DatabaseBackup
+}
+
+type DatabaseBackup struct {
+	// Back up the SQLite database on a schedule while the app is running. Default false
+	// Only SQLite, use the tools of Postgres and MySQL for them
+	EnableBackup bool
+	// Directory of the backups. Default DB_BACKUP_DIR, otherwise "db/backups"
+	Dir string
+	// Time between backups. Default 24 hours
+	Interval time.Duration
+	// Backups kept in Dir, older ones are deleted. Default 7
+	Keep int
+}
+
+type SQLite struct {
+	// Journal mode, with "WAL" readers don't block the writer. Default "WAL"
+	JournalMode string
+	// How long a connection waits for the lock of another one instead of failing with SQLITE_BUSY. Default 5 seconds
+	BusyTimeout time.Duration
+	// Enforce foreign key constraints. Default true
+	ForeignKeys bool
+	// Synchronous mode, "NORMAL" is safe with WAL. Default "NORMAL"
+	Synchronous string
 }
 
 type Mail struct {
@@ -54,8 +156,139 @@ type Mail struct {
 	EnableMail bool
 	// Mail Provider. Default MailProviderResend
 	MailProvider MailProvider
+	// Ordered providers, the next one is used if a provider fails. F.e. []MailProvider{MailProviderResend, MailProviderSMTP}
+	// Providers without their environment variables are left out. Default empty, only MailProvider is used
+	Providers []MailProvider
+	// Circuit breaker of each provider in Providers
+	Failover Failover
+	// Settings for MailProviderSMTP
+	SMTP SMTP
+	// Sign outgoing mails with DKIM
+	DKIM DKIM
+	// Deliver mails in the background with retries
+	Outbox Outbox
+	// Settings for MailProviderDev
+	Dev DevMail
+	// Stop mailing addresses that bounced or complained
+	Suppression Suppression
+	// Receive replies to the mails of the app
+	Inbound Inbound
+	// Mails of admins to all users or a segment
+	Broadcast Broadcast
+}
+
+type Broadcast struct {
+	// Admins compose broadcasts at /admin/broadcasts, a background worker sends them. Default true
+	// Users who unsubscribed from the category of a broadcast are skipped
+	EnableBroadcast bool
+	// Users per batch. Default 50
+	BatchSize int
+	// Pause between batches. Default 1 second
+	BatchInterval time.Duration
+}
+
+type Inbound struct {
+	// Accept mail for Domain with an embedded SMTP server and dispatch signed replies, see inbound.ReplyAddress. Default false
+	// The MX record of Domain has to point to the server. STARTTLS is offered with INBOUND_SMTP_CERT_FILE and INBOUND_SMTP_KEY_FILE
+	EnableInbound bool
+	// Default ":2525", forward port 25 to it
+	Addr string
+	// Domain of the reply addresses. Default the host of APP_URL
+	Domain string
+	// Larger mails are rejected. Default 10 MB
+	MaxMessageBytes int64
+	// Default 10
+	MaxRecipients int
+}
+
+type Suppression struct {
+	// Skip suppressed addresses when sending and receive bounces and complaints by webhook. Default true
+	EnableSuppression bool
+	// Signing secret of the Resend webhook at /webhooks/resend, the route is only registered with it.
+	// Default RESEND_WEBHOOK_SECRET
+	ResendWebhookSecret string
+	// Signing secret of the JSON format of mail.ParseGenericWebhook at /webhooks/mail, the route is only registered with it.
+	// Default MAIL_WEBHOOK_SECRET
+	WebhookSecret string
+}
+
+type Failover struct {
+	// Consecutive failures after which a provider is skipped. Default 3
+	FailureThreshold int
+	// How long a failing provider is skipped before it is tried again. Default 1 minute
+	Cooldown time.Duration
+}
+
+type DevMail struct {
+	// Also write every mail as .eml file into the directory, f.e. "tmp/mail". Default "", only in memory
+	Directory string
+	// Mails kept in memory, older ones are dropped. Default 100
+	MaxMessages int
+}
+
+type Outbox struct {
+	// Queue mails in the database and deliver them with a background worker. Default true
+	// Needs the database, otherwise mails are sent right away in the request
+	EnableOutbox bool
+	// How often the worker looks for due mails. Default 5 seconds
+	PollInterval time.Duration
+	// Attempts before a mail is dead-lettered. Mails the provider rejects permanently are dead-lettered right away. Default 8
+	MaxAttempts int
+	// Delay before the first retry, doubled after every attempt. Default 30 seconds
+	RetryDelay time.Duration
+	// Default 6 hours
+	MaxRetryDelay time.Duration
+	// Mails per recipient within RecipientWindow, more are delayed. A negative value disables the throttle. Default 10
+	RecipientLimit int
+	// Default 1 hour
+	RecipientWindow time.Duration
+}
+
+type DKIM struct {
+	// Sign mails of providers that build the message themselves, like SMTP. Default false
+	// Resend signs mails itself. The PEM encoded RSA or Ed25519 key is read from DKIM_PRIVATE_KEY or the file in DKIM_PRIVATE_KEY_FILE
+	EnableDKIM bool
+	// Domain of the signature. The public key is published at <selector>._domainkey.<domain>. Default the domain of the sender
+	Domain string
+	// Default "mail"
+	Selector string
+	// Headers covered by the signature. Default From, To, Cc, Subject, Date, Message-ID, Reply-To, MIME-Version, Content-Type
+	Headers []string
 }
 
+type SMTP struct {
+	// How the connection is encrypted. Default SMTPTLSModeStartTLS
+	// The server is read from SMTP_HOST and SMTP_PORT, the sender from SMTP_FROM_EMAIL and SMTP_FROM_NAME
+	TLSMode SMTPTLSMode
+	// How to log in with SMTP_USERNAME and SMTP_PASSWORD. Default SMTPAuthPlain
+	Auth SMTPAuth
+	// Timeout for connecting and sending a mail. Default 10 seconds
+	Timeout time.Duration
+	// How long an idle connection is kept open for the next mail. A negative value closes it after every mail. Default 30 seconds
+	IdleTimeout time.Duration
+}
+
+type SMTPTLSMode string
+
+const (
+	// Upgrade the connection with STARTTLS, usually on port 587. Fails if the server does not support it
+	SMTPTLSModeStartTLS SMTPTLSMode = "starttls"
+	// Connect with TLS, usually on port 465
+	SMTPTLSModeImplicit SMTPTLSMode = "tls"
+	// Unencrypted, only for local relays
+	SMTPTLSModeNone SMTPTLSMode = "none"
+)
+
+type SMTPAuth string
+
+const (
+	SMTPAuthPlain   SMTPAuth = "plain"
+	SMTPAuthLogin   SMTPAuth = "login"
+	SMTPAuthCRAMMD5 SMTPAuth = "cram-md5"
+	// No authentication, f.e. for relays that allow the IP of the server
+	SMTPAuthNone SMTPAuth = "none"
+)
+
 type MailProvider string
 
 const (
@@ -63,10 +296,90 @@ const (
 	MailProviderResend MailProvider = "resend"
 	// Print Mails to Console for Debug / Dev
 	MailProviderConsole MailProvider = "console"
-	// Sent Mails via Imap NOT IMPLEMENTED YET
-	//MailProviderIMAP   MailProvider = "imap"
+	// Send Mails via SMTP, f.e. a self hosted mail server
+	MailProviderSMTP MailProvider = "smtp"
+	// Keep Mails in a development inbox at /dev/mail. Not available if APP_ENV is production
+	MailProviderDev MailProvider = "dev"
 )
 
+type Legal struct {
+	// Enable Terms of Service and Privacy Policy acceptance. Default true
+	// Users have to accept the current versions on signup and again whenever a new version is published
+	EnableLegal bool
+	// Current Terms of Service version. Default "2024-08-01"
+	// The document is read from web/embed/legal/terms/<version>.md
+	TermsVersion string
+	// Current Privacy Policy version. Default "2024-08-01"
+	// The document is read from web/embed/legal/privacy/<version>.md
+	PrivacyVersion string
+}
+
+type OIDC struct {
+	// Enable the OpenID Connect provider so other services can log in with the accounts of this app. Default false
+	// The issuer is App.Url. Clients are managed by admins at /admin/oidc-clients
+	// The signing key is read from OIDC_SIGNING_KEY_FILE. Without it a temporary key is generated on every start
+	EnableOIDC bool
+	// How long an authorization code can be exchanged. Default 5 minutes
+	AuthorizationCodeLifetime time.Duration
+	// How long access and id tokens are valid. Default 1 hour
+	TokenLifetime time.Duration
+}
+
+type LDAP struct {
+	// Authenticate users against an LDAP directory or Active Directory. Default false
+	// The connection is read from LDAP_URL, LDAP_BASE_DN, LDAP_BIND_DN and LDAP_BIND_PASSWORD
+	// Users are created on their first login
+	EnableLDAP bool
+	// Local accounts can still log in with their password. Default true
+	AllowLocalLogin bool
+	// Upgrade ldap:// connections with StartTLS. Default true
+	StartTLS bool
+	// Filter to find the user, %s is replaced with the escaped email. Default "(mail=%s)"
+	// For Active Directory "(userPrincipalName=%s)" is common
+	UserFilter string
+	// Attribute used as username for new users. Default "uid", for Active Directory use "sAMAccountName"
+	UsernameAttribute string
+	// Attribute holding the email. Default "mail"
+	EmailAttribute string
+	// Attribute listing the group DNs of the user. Default "memberOf"
+	GroupAttribute string
+	// Maps group DNs to roles, f.e. {"cn=admins,ou=groups,dc=example,dc=org": "admin"}
+	// If set the role of the user is updated on every login. Default empty
+	GroupRoles map[string]string
+}
+
+type SAML struct {
+	// Let users log in with a SAML 2.0 identity provider like Okta, Entra ID or Keycloak. Default false
+	// The service provider key and certificate are read from SAML_SP_KEY_FILE and SAML_SP_CERT_FILE
+	// Users are created on their first login
+	EnableSAML bool
+	// The identity providers. The metadata of this app for each of them is served at /saml/<name>/metadata
+	IdentityProviders []SAMLIdentityProvider
+}
+
+type SAMLIdentityProvider struct {
+	// Used in the URLs, f.e. "okta" for /saml/okta/acs
+	Name string
+	// Where to load the IdP metadata from. Set one of them
+	MetadataURL  string
+	MetadataFile string
+	// Users with an email of these domains are sent to this IdP from the login page, f.e. ["example.org"]
+	// Assertions with emails of other domains are rejected. Default empty, any domain
+	Domains []string
+	// Attribute holding the email. The NameID is used if empty. Default empty
+	EmailAttribute string
+	// Attribute used as username for new users. The local part of the email is used if empty. Default empty
+	UsernameAttribute string
+	// Attribute listing the groups of the user. Default empty
+	GroupAttribute string
+	// Maps groups to roles, f.e. {"admins": "admin"}
+	// If set the role of the user is updated on every login. Default empty
+	GroupRoles map[string]string
+	// Link existing accounts with the same email on the first login. Only enable it for identity providers
+	// that verify the emails of their users. Default false, these users can't log in with the IdP
+	LinkAccounts bool
+}
+
 type Theme struct {
 	// Set Standard Theme. Default ""
 	// We use DaisyUI. If you want to add more themes you can do this in tailwind.config.js
@@ -94,6 +407,9 @@ type Auth struct {
 	EnableResetPassword bool
 	// Enable Verify Email. Default true
 	EnableVerifyEmail bool
+	// How long a login counts as recent for sensitive actions like changing the password or email.
+	// Older sessions have to enter their password again. Default 15 minutes
+	RecentAuthMaxAge time.Duration
 }
 
 // validateDependencies checks and adjusts dependent settings
@@ -101,11 +417,25 @@ func (c *Config) validateDependencies() {
 	if !c.Database.Enabled {
 		c.Auth.EnableAuth = false
 	}
+	// Only Postgres routes reads to replicas
+	if c.Database.Type != DatabaseTypePostgres {
+		c.Database.Replicas = nil
+	}
+	// Backups copy the SQLite file
+	if c.Database.Type != DatabaseTypeSQLite || !c.Database.Enabled {
+		c.Database.Backup.EnableBackup = false
+	}
 	// If mail is disabled
 	if !c.Mail.EnableMail {
 		c.Auth.EnableResetPassword = false
 		c.Auth.EnableVerifyEmail = false
 	}
+	// The outbox and the suppression list are stored in the database
+	if !c.Mail.EnableMail || !c.Database.Enabled {
+		c.Mail.Outbox.EnableOutbox = false
+		c.Mail.Suppression.EnableSuppression = false
+		c.Mail.Broadcast.EnableBroadcast = false
+	}
 
 	// If registration is disabled
 	if !c.Auth.EnableAuth {
@@ -113,6 +443,13 @@ func (c *Config) validateDependencies() {
 		c.Auth.EnableRegistration = false
 		c.Auth.EnableResetPassword = false
 		c.Auth.EnableVerifyEmail = false
+		// These features need user accounts
+		c.Legal.EnableLegal = false
+		c.OIDC.EnableOIDC = false
+		c.LDAP.EnableLDAP = false
+		c.SAML.EnableSAML = false
+		// Only admins send broadcasts
+		c.Mail.Broadcast.EnableBroadcast = false
 	}
 }
 
@@ -125,10 +462,43 @@ func New(overrides *Config) *Config {
 		App: App{
 			Name: os.Getenv("APP_NAME"),
 			Url:  os.Getenv("APP_URL"),
+			Env:  os.Getenv("APP_ENV"),
 		},
 		Database: Database{
-			Enabled: true,
-			Type:    DatabaseTypeSQLite,
+			Enabled:              true,
+			Type:                 DatabaseTypeSQLite,
+			AutoMigrate:          true, // Default to true
+			DSN:                  os.Getenv("DATABASE_URL"),
+			Host:                 os.Getenv("DB_HOST"),
+			Port:                 os.Getenv("DB_PORT"),
+			Name:                 os.Getenv("DB_DATABASE"),
+			Username:             os.Getenv("DB_USERNAME"),
+			Password:             os.Getenv("DB_PASSWORD"),
+			Schema:               os.Getenv("DB_SCHEMA"),
+			File:                 os.Getenv("DB_FILE"),
+			SSLMode:              DatabaseSSLMode(os.Getenv("DB_SSLMODE")),
+			SSLRootCert:          os.Getenv("DB_SSLROOTCERT"),
+			SSLCert:              os.Getenv("DB_SSLCERT"),
+			SSLKey:               os.Getenv("DB_SSLKEY"),
+			MaxOpenConns:         25,
+			MaxIdleConns:         10,
+			ConnMaxLifetime:      time.Hour,
+			ConnMaxIdleTime:      10 * time.Minute,
+			Replicas:             envList("DB_REPLICA_URLS"),
+			ReadYourWritesWindow: 5 * time.Second,
+			ReplicaMaxLag:        30 * time.Second,
+			ReplicaCheckInterval: 10 * time.Second,
+			SQLite: SQLite{
+				JournalMode: "WAL",
+				BusyTimeout: 5 * time.Second,
+				ForeignKeys: true, // Default to true
+				Synchronous: "NORMAL",
+			},
+			Backup: DatabaseBackup{
+				Dir:      os.Getenv("DB_BACKUP_DIR"),
+				Interval: 24 * time.Hour,
+				Keep:     7,
+			},
 		},
 		Theme: Theme{
 			StandardTheme:       "",
@@ -142,10 +512,76 @@ func New(overrides *Config) *Config {
 			EnableAvatar:        true, // Default to true
 			EnableResetPassword: true, // Default to true
 			EnableVerifyEmail:   true, // Default to true
+			RecentAuthMaxAge:    15 * time.Minute,
 		},
 		Mail: Mail{
 			EnableMail:   true,               // Default to true
 			MailProvider: MailProviderResend, // Default to MailProviderResend
+			SMTP: SMTP{
+				TLSMode:     SMTPTLSModeStartTLS,
+				Auth:        SMTPAuthPlain,
+				Timeout:     10 * time.Second,
+				IdleTimeout: 30 * time.Second,
+			},
+			DKIM: DKIM{
+				EnableDKIM: false, // Default to false
+				Selector:   "mail",
+				Headers:    []string{"From", "To", "Cc", "Subject", "Date", "Message-ID", "Reply-To", "MIME-Version", "Content-Type"},
+			},
+			Outbox: Outbox{
+				EnableOutbox:    true, // Default to true
+				PollInterval:    5 * time.Second,
+				MaxAttempts:     8,
+				RetryDelay:      30 * time.Second,
+				MaxRetryDelay:   6 * time.Hour,
+				RecipientLimit:  10,
+				RecipientWindow: time.Hour,
+			},
+			Dev: DevMail{
+				MaxMessages: 100,
+			},
+			Failover: Failover{
+				FailureThreshold: 3,
+				Cooldown:         time.Minute,
+			},
+			Suppression: Suppression{
+				EnableSuppression:   true, // Default to true
+				ResendWebhookSecret: os.Getenv("RESEND_WEBHOOK_SECRET"),
+				WebhookSecret:       os.Getenv("MAIL_WEBHOOK_SECRET"),
+			},
+			Broadcast: Broadcast{
+				EnableBroadcast: true, // Default to true
+				BatchSize:       50,
+				BatchInterval:   time.Second,
+			},
+			Inbound: Inbound{
+				EnableInbound:   false, // Default to false
+				Addr:            ":2525",
+				MaxMessageBytes: 10 << 20,
+				MaxRecipients:   10,
+			},
+		},
+		Legal: Legal{
+			EnableLegal:    true, // Default to true
+			TermsVersion:   "2024-08-01",
+			PrivacyVersion: "2024-08-01",
+		},
+		OIDC: OIDC{
+			EnableOIDC:                false, // Default to false
+			AuthorizationCodeLifetime: 5 * time.Minute,
+			TokenLifetime:             time.Hour,
+		},
+		LDAP: LDAP{
+			EnableLDAP:        false, // Default to false
+			AllowLocalLogin:   true,  // Default to true
+			StartTLS:          true,  // Default to true
+			UserFilter:        "(mail=%s)",
+			UsernameAttribute: "uid",
+			EmailAttribute:    "mail",
+			GroupAttribute:    "memberOf",
+		},
+		SAML: SAML{
+			EnableSAML: false, // Default to false
 		},
 	}
 
@@ -159,17 +595,89 @@ func New(overrides *Config) *Config {
 	return config
 }
 
+// envList splits the comma separated environment variable, nil if it is not set
+func envList(name string) []string {
+	var list []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
 // Checks if specific environment variables are set
 func (c *Config) CheckEnvironmentVariables() error {
-	if c.Database.Type == DatabaseTypePostgres {
-		if os.Getenv("DB_HOST") == "" || os.Getenv("DB_DATABASE") == "" || os.Getenv("DB_PORT") == "" || os.Getenv("DB_USER") == "" || os.Getenv("DB_PASSWORD") == "" {
-			fmt.Println("Warning: DB_HOST, DB_DATABASE, DB_PORT, DB_USER or DB_PASSWORD environment variable is not set")
+	if c.App.Env == "" {
+		c.App.Env = "local"
+	}
+	if c.Database.Backup.Dir == "" {
+		c.Database.Backup.Dir = "db/backups"
+	}
+	if c.Database.SSLMode == "" {
+		c.Database.SSLMode = DatabaseSSLModeDisable
+	}
+	switch c.Database.SSLMode {
+	case DatabaseSSLModeDisable, DatabaseSSLModeAllow, DatabaseSSLModePrefer, DatabaseSSLModeRequire, DatabaseSSLModeVerifyCA, DatabaseSSLModeVerifyFull:
+	default:
+		// Falling back to an unencrypted connection could leak the credentials
+		fmt.Printf("Warning: unknown database SSL mode %q\n", c.Database.SSLMode)
+		c.Database.Enabled = false
+		fmt.Println("Database functionality has been disabled")
+	}
+	if c.Mail.Inbound.EnableInbound && c.Mail.Inbound.Domain == "" {
+		if appUrl, err := url.Parse(c.App.Url); err == nil {
+			c.Mail.Inbound.Domain = appUrl.Hostname()
+		}
+		if c.Mail.Inbound.Domain == "" {
+			fmt.Println("Warning: APP_URL environment variable is not set and no inbound mail domain is configured")
+			c.Mail.Inbound.EnableInbound = false
+			fmt.Println("Inbound mail has been disabled")
+		}
+	}
+	if c.Mail.EnableMail {
+		c.checkMailProviders()
+	}
+	if c.Mail.EnableMail && c.Mail.Suppression.EnableSuppression {
+		// Without the webhooks bounced addresses keep getting mails
+		if (c.Mail.MailProvider == MailProviderResend || slices.Contains(c.Mail.Providers, MailProviderResend)) && c.Mail.Suppression.ResendWebhookSecret == "" {
+			fmt.Println("Warning: RESEND_WEBHOOK_SECRET environment variable is not set")
+			fmt.Println("The Resend webhook has been disabled, bounces and complaints of Resend are not received")
+		} else if c.Mail.Suppression.ResendWebhookSecret == "" && c.Mail.Suppression.WebhookSecret == "" {
+			fmt.Println("Warning: MAIL_WEBHOOK_SECRET environment variable is not set")
+			fmt.Println("The mail webhook has been disabled, bounces and complaints are not received")
+		}
+	}
+	if c.Mail.DKIM.EnableDKIM {
+		if os.Getenv("DKIM_PRIVATE_KEY") == "" && os.Getenv("DKIM_PRIVATE_KEY_FILE") == "" {
+			fmt.Println("Warning: DKIM_PRIVATE_KEY or DKIM_PRIVATE_KEY_FILE environment variable is not set")
+			c.Mail.DKIM.EnableDKIM = false
+			fmt.Println("DKIM signing has been disabled")
+		}
+	}
+	if c.SAML.EnableSAML {
+		if os.Getenv("SAML_SP_KEY_FILE") == "" || os.Getenv("SAML_SP_CERT_FILE") == "" {
+			fmt.Println("Warning: SAML_SP_KEY_FILE or SAML_SP_CERT_FILE environment variable is not set")
+			c.SAML.EnableSAML = false
+			fmt.Println("SAML single sign-on has been disabled")
+		}
+	}
+	if c.LDAP.EnableLDAP {
+		if os.Getenv("LDAP_URL") == "" || os.Getenv("LDAP_BASE_DN") == "" {
+			fmt.Println("Warning: LDAP_URL or LDAP_BASE_DN environment variable is not set")
+			c.LDAP.EnableLDAP = false
+			fmt.Println("LDAP authentication has been disabled")
+		}
+	}
+	if (c.Database.Type == DatabaseTypePostgres || c.Database.Type == DatabaseTypeMySQL) && c.Database.DSN == "" {
+		if os.Getenv("DB_HOST") == "" || os.Getenv("DB_DATABASE") == "" || os.Getenv("DB_PORT") == "" || os.Getenv("DB_USERNAME") == "" || os.Getenv("DB_PASSWORD") == "" {
+			fmt.Println("Warning: DATABASE_URL or DB_HOST, DB_DATABASE, DB_PORT, DB_USERNAME and DB_PASSWORD environment variables are not set")
 			c.Database.Enabled = false
 			fmt.Println("Database functionality has been disabled")
 			return nil
 		}
 	}
-	if c.Database.Type == DatabaseTypeSQLite {
+	if c.Database.Type == DatabaseTypeSQLite && c.Database.DSN == "" {
 		if os.Getenv("DB_FILE") == "" {
 			fmt.Println("Warning: DB_FILE environment variable is not set")
 			fmt.Println("Defaulting to 'db/dev.sqlite'")
@@ -177,14 +685,6 @@ func (c *Config) CheckEnvironmentVariables() error {
 			return nil
 		}
 	}
-	if c.Mail.MailProvider == MailProviderResend {
-		if os.Getenv("RESEND_API_KEY") == "" || os.Getenv("RESEND_FROM_EMAIL") == "" || os.Getenv("RESEND_FROM_NAME") == "" {
-			fmt.Println("Warning: RESEND_API_KEY, RESEND_FROM_EMAIL or RESEND_FROM_NAME environment variable is not set")
-			c.Mail.EnableMail = false
-			fmt.Println("Mail functionality has been disabled")
-			return nil
-		}
-	}
 	// Check for essential environment variables
 	essentialEnvVars := []string{"APP_NAME", "APP_URL", "SECRET_KEY"}
 	for _, envVar := range essentialEnvVars {
@@ -196,6 +696,57 @@ func (c *Config) CheckEnvironmentVariables() error {
 	return nil
 }
 
+// checkMailProviders leaves out the providers of the failover chain that are not configured.
+// A single provider disables mail if it is not configured
+func (c *Config) checkMailProviders() {
+	if len(c.Mail.Providers) == 0 {
+		if warning := c.mailProviderWarning(c.Mail.MailProvider); warning != "" {
+			fmt.Println("Warning: " + warning)
+			c.Mail.EnableMail = false
+			fmt.Println("Mail functionality has been disabled")
+		}
+		return
+	}
+	var available []MailProvider
+	for _, provider := range c.Mail.Providers {
+		if warning := c.mailProviderWarning(provider); warning != "" {
+			fmt.Println("Warning: " + warning)
+			fmt.Printf("Mail provider %s has been removed from the failover chain\n", provider)
+			continue
+		}
+		available = append(available, provider)
+	}
+	c.Mail.Providers = available
+	if len(available) == 0 {
+		c.Mail.EnableMail = false
+		fmt.Println("Mail functionality has been disabled")
+		return
+	}
+	c.Mail.MailProvider = available[0]
+}
+
+// mailProviderWarning returns why the provider can't be used, or "" if its environment variables are set
+func (c *Config) mailProviderWarning(provider MailProvider) string {
+	switch provider {
+	case MailProviderResend:
+		if os.Getenv("RESEND_API_KEY") == "" || os.Getenv("RESEND_FROM_EMAIL") == "" || os.Getenv("RESEND_FROM_NAME") == "" {
+			return "RESEND_API_KEY, RESEND_FROM_EMAIL or RESEND_FROM_NAME environment variable is not set"
+		}
+	case MailProviderSMTP:
+		if os.Getenv("SMTP_HOST") == "" || os.Getenv("SMTP_PORT") == "" || os.Getenv("SMTP_FROM_EMAIL") == "" {
+			return "SMTP_HOST, SMTP_PORT or SMTP_FROM_EMAIL environment variable is not set"
+		}
+		if c.Mail.SMTP.Auth != SMTPAuthNone && (os.Getenv("SMTP_USERNAME") == "" || os.Getenv("SMTP_PASSWORD") == "") {
+			return "SMTP_USERNAME or SMTP_PASSWORD environment variable is not set"
+		}
+	case MailProviderDev:
+		if c.IsProduction() {
+			return "the dev mail provider is not available in production"
+		}
+	}
+	return ""
+}
+
 // This function merges the base config with the overrides config set in the server.go
 func mergeConfig(base, overrides interface{}) {
 	baseVal := reflect.ValueOf(base).Elem()
@@ -0,0 +1,291 @@
+package database
+
+import (
+	"atomic-go-template/internal/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	// ErrNotSQLite is returned for backups of other databases, use the tools of Postgres or MySQL for them
+	ErrNotSQLite = errors.New("database: backups need SQLite")
+	// ErrRestoreSameFile is returned for restoring the database from itself
+	ErrRestoreSameFile = errors.New("database: the backup is the database file")
+)
+
+// The names of scheduled backups sort by time
+const backupLayout = "backup-20060102-150405.sqlite"
+
+// BackupSQLite writes a consistent copy of the database to path while the app keeps running. VACUUM INTO reads a
+// snapshot of a single transaction and writes a compacted file, so the copy is written next to path and renamed
+func BackupSQLite(ctx context.Context, db *gorm.DB, path string) error {
+	if db.Dialector.Name() != "sqlite" {
+		return ErrNotSQLite
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// VACUUM INTO fails if the file exists
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := db.WithContext(WithPrimary(ctx)).Exec("VACUUM INTO ?", tmp).Error; err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("database: backing up to %s: %w", path, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// RestoreSQLite replaces the database file with the backup after checking it. The app must not be running.
+// The replaced file and its WAL are kept with a ".before-restore-<time>" suffix, their path is returned
+func RestoreSQLite(ctx context.Context, backup string, file string) (string, error) {
+	// The database is renamed before the backup is copied, the backup would be gone
+	if same, err := sameFile(backup, file); err != nil {
+		return "", err
+	} else if same {
+		return "", ErrRestoreSameFile
+	}
+	if err := checkSQLiteBackup(ctx, backup); err != nil {
+		return "", err
+	}
+
+	// The backup is copied first, a failed copy leaves the database alone
+	tmp := file + ".tmp"
+	if err := copyFile(backup, tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	// The WAL and shared memory files belong to the replaced database
+	suffix := ".before-restore-" + time.Now().Format("20060102-150405")
+	var moved []string
+	// rollback puts the moved files back, so the app never starts with an empty database
+	rollback := func(err error) (string, error) {
+		for _, path := range moved {
+			if renameErr := os.Rename(path+suffix, path); renameErr != nil {
+				err = errors.Join(err, renameErr)
+			}
+		}
+		os.Remove(tmp)
+		return "", err
+	}
+	for _, ext := range []string{"", "-wal", "-shm"} {
+		if err := os.Rename(file+ext, file+ext+suffix); err == nil {
+			moved = append(moved, file+ext)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return rollback(err)
+		}
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return rollback(err)
+	}
+	if len(moved) > 0 && moved[0] == file {
+		return file + suffix, nil
+	}
+	return "", nil
+}
+
+// checkSQLiteBackup opens the backup read only and runs the integrity check of SQLite
+func checkSQLiteBackup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	gormDB, err := gorm.Open(sqliteDialector("file:"+path+"?mode=ro"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return fmt.Errorf("database: opening %s: %w", path, err)
+	}
+	db, err := gormDB.DB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var result string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("database: %s is no SQLite database: %w", path, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("database: %s is damaged: %s", path, result)
+	}
+	var tables int
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&tables); err != nil || tables == 0 {
+		return fmt.Errorf("database: %s is no backup of the app, it has no schema_migrations", path)
+	}
+	return nil
+}
+
+// sameFile reports whether the paths name the same file, also through links or different spellings
+func sameFile(a string, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	if absA == absB {
+		return true, nil
+	}
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB), nil
+}
+
+func copyFile(from string, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Close()
+		return err
+	}
+	if err := target.Sync(); err != nil {
+		target.Close()
+		return err
+	}
+	return target.Close()
+}
+
+type BackupOptions struct {
+	// Directory of the backups
+	Dir string
+	// Time between backups
+	Interval time.Duration
+	// Backups kept in Dir, older ones are deleted. 0 keeps all
+	Keep int
+}
+
+func BackupOptionsFromConfig(c config.DatabaseBackup) BackupOptions {
+	return BackupOptions{
+		Dir:      c.Dir,
+		Interval: c.Interval,
+		Keep:     c.Keep,
+	}
+}
+
+// BackupFile is a backup in the directory of the scheduler
+type BackupFile struct {
+	Name      string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// Backups backs up the SQLite database to a directory on a schedule and deletes old backups
+type Backups struct {
+	db      *gorm.DB
+	options BackupOptions
+}
+
+func NewBackups(db *gorm.DB, options BackupOptions) *Backups {
+	if options.Interval <= 0 {
+		options.Interval = 24 * time.Hour
+	}
+	return &Backups{db: db, options: options}
+}
+
+// Create writes a backup named after the time to the directory and deletes the backups exceeding Keep
+func (b *Backups) Create(ctx context.Context, now time.Time) (string, error) {
+	path := filepath.Join(b.options.Dir, now.UTC().Format(backupLayout))
+	if err := BackupSQLite(ctx, b.db, path); err != nil {
+		return "", err
+	}
+	return path, b.prune()
+}
+
+// List returns the backups of the directory, the newest first
+func (b *Backups) List() ([]BackupFile, error) {
+	entries, err := os.ReadDir(b.options.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []BackupFile
+	for _, entry := range entries {
+		createdAt, err := time.Parse(backupLayout, entry.Name())
+		if err != nil || entry.IsDir() {
+			// Not created by the scheduler, f.e. a manual backup
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupFile{Name: entry.Name(), Size: info.Size(), CreatedAt: createdAt})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// Path returns the path of the backup with the name, false for names that are not in List
+func (b *Backups) Path(name string) (string, bool) {
+	if _, err := time.Parse(backupLayout, name); err != nil || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	path := filepath.Join(b.options.Dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+func (b *Backups) prune() error {
+	if b.options.Keep <= 0 {
+		return nil
+	}
+	backups, err := b.List()
+	if err != nil || len(backups) <= b.options.Keep {
+		return err
+	}
+	for _, backup := range backups[b.options.Keep:] {
+		if err := os.Remove(filepath.Join(b.options.Dir, backup.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run creates a backup every Interval until the context is canceled. The first backup is made one interval after
+// the last one, so restarts don't add backups
+func (b *Backups) Run(ctx context.Context) {
+	for {
+		wait := b.options.Interval
+		if backups, err := b.List(); err == nil && len(backups) > 0 {
+			wait = max(time.Until(backups[0].CreatedAt.Add(b.options.Interval)), 0)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		path, err := b.Create(ctx, time.Now())
+		if err != nil {
+			log.Printf("Database backup failed: %v", err)
+			// Retry after the interval instead of right away
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(b.options.Interval):
+			}
+			continue
+		}
+		log.Printf("Database backed up to %s", path)
+	}
+}
@@ -3,21 +3,21 @@ package database
 import (
 	"atomic-go-template/internal/config"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"strconv"
 	"time"
 
-	_ "github.com/joho/godotenv/autoload"
 	"gorm.io/gorm"
 )
 
 // Service represents a service that interacts with a database.
 type Service interface {
 	// Health returns a map of health status information.
-	// The keys and values in the map are service-specific.
-	Health() map[string]string
+	// The keys and values in the map are service-specific, "status" is "down" if the database can't be reached.
+	Health(ctx context.Context) map[string]string
 
 	// Close terminates the database connection.
 	// It returns an error if the connection cannot be closed.
@@ -25,43 +25,76 @@ type Service interface {
 
 	// GetDB returns the database connection.
 	GetDB() *gorm.DB
+
+	// Replicas returns the state of the read replicas, empty without replicas.
+	Replicas() []ReplicaStatus
 }
 
 type service struct {
-	db *gorm.DB
+	db       *gorm.DB
+	name     string
+	replicas *ReplicaRouter
+	// Stops the replica checks
+	stop context.CancelFunc
 }
 
-var (
-	// Type
-	dbType     = os.Getenv("DB_TYPE")
-	dbInstance *service
-)
+// ErrUnsupportedType is returned by New for unknown database types
+var ErrUnsupportedType = errors.New("unsupported database type")
 
-func New(c config.Database) Service {
-	// Reuse Connection
-	if dbInstance != nil {
-		return dbInstance
-	}
-	var newService Service
+// New connects to the database of the config. Every call opens a new pool, share the service instead of calling it again.
+// The context limits connecting, the service lives until Close
+func New(ctx context.Context, c config.Database) (Service, error) {
 	switch c.Type {
 	case config.DatabaseTypePostgres:
-		newService = NewPostgresService()
+		return NewPostgresService(ctx, c)
 	case config.DatabaseTypeSQLite:
-		newService = NewSQLiteService()
+		return NewSQLiteService(ctx, c)
+	case config.DatabaseTypeMySQL:
+		return NewMySQLService(ctx, c)
 	default:
-		log.Fatalf("Unsupported database type: %s", dbType)
+		return nil, fmt.Errorf("database: %w: %q", ErrUnsupportedType, c.Type)
+	}
+}
+
+// open opens the pool of the dialector with the settings of the config and checks the connection
+func open(ctx context.Context, dialector gorm.Dialector, c config.Database) (*service, error) {
+	db, err := gorm.Open(dialector, &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		return nil, fmt.Errorf("database: opening %s: %w", c.Type, err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	configureSQLPool(sqlDB, c)
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("database: connecting to %s: %w", c.Type, err)
+	}
+	return &service{db: db, name: string(c.Type)}, nil
+}
+
+// configureSQLPool applies the pool settings of the config to the connections.
+// Zero values keep the defaults of database/sql
+func configureSQLPool(sqlDB *sql.DB, c config.Database) {
+	if c.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(c.MaxIdleConns)
 	}
-	if newService == nil {
-		log.Fatalf("Failed to initialize database service for type: %s", dbType)
+	if c.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	if c.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(c.ConnMaxIdleTime)
 	}
-	dbInstance = newService.(*service)
-	return dbInstance
 }
 
 // Health checks the health of the database connection by pinging the database.
 // It returns a map with keys indicating various health statistics.
-func (s *service) Health() map[string]string {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+func (s *service) Health(ctx context.Context) map[string]string {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
 	stats := make(map[string]string)
@@ -71,7 +104,7 @@ func (s *service) Health() map[string]string {
 	if err != nil {
 		stats["status"] = "down"
 		stats["error"] = fmt.Sprintf("db down: %v", err)
-		log.Fatalf(fmt.Sprintf("db down: %v", err)) // Log the error and terminate the program
+		log.Printf("Database health check failed: %v", err)
 		return stats
 	}
 
@@ -79,7 +112,7 @@ func (s *service) Health() map[string]string {
 	if err != nil {
 		stats["status"] = "down"
 		stats["error"] = fmt.Sprintf("db down: %v", err)
-		log.Fatalf(fmt.Sprintf("db down: %v", err)) // Log the error and terminate the program
+		log.Printf("Database health check failed: %v", err)
 		return stats
 	}
 
@@ -114,6 +147,19 @@ func (s *service) Health() map[string]string {
 		stats["message"] = "Many connections are being closed due to max lifetime, consider increasing max lifetime or revising the connection usage pattern."
 	}
 
+	if replicas := s.Replicas(); len(replicas) > 0 {
+		healthy := 0
+		for _, replica := range replicas {
+			if replica.Healthy {
+				healthy++
+			}
+		}
+		stats["replicas_healthy"] = fmt.Sprintf("%d/%d", healthy, len(replicas))
+		if healthy == 0 {
+			stats["message"] = "No replica is healthy, the primary serves all reads."
+		}
+	}
+
 	return stats
 }
 
@@ -122,14 +168,27 @@ func (s *service) Health() map[string]string {
 // If the connection is successfully closed, it returns nil.
 // If an error occurs while closing the connection, it returns the error.
 func (s *service) Close() error {
+	if s.stop != nil {
+		s.stop()
+	}
+	if s.replicas != nil {
+		s.replicas.Close()
+	}
 	sqlDB, err := s.db.DB()
 	if err != nil {
 		return err
 	}
-	log.Printf("Disconnected from database: %s", database)
+	log.Printf("Disconnected from database: %s", s.name)
 	return sqlDB.Close()
 }
 
 func (s *service) GetDB() *gorm.DB {
 	return s.db
 }
+
+func (s *service) Replicas() []ReplicaStatus {
+	if s.replicas == nil {
+		return nil
+	}
+	return s.replicas.Status()
+}
@@ -0,0 +1,464 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations are stored per dialect in migrations/<dialect>/<version>_<name>.up.sql and .down.sql.
+// The version is the UTC time of the creation, see CreateMigration
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// MigrationsDir is the directory of the migrations in the repository, used by CreateMigration
+const MigrationsDir = "internal/database/migrations"
+
+// A migration with this line runs outside of a transaction, f.e. for CREATE INDEX CONCURRENTLY
+const noTransaction = "-- migrate:no-transaction"
+
+var migrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+var (
+	// ErrLocked is returned if another instance holds the migration lock until the context is done
+	ErrLocked = errors.New("migrations are locked by another instance")
+	// ErrUnsupportedDialect is returned for databases without migrations
+	ErrUnsupportedDialect = errors.New("no migrations for the database dialect")
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	// Nil if the migration is pending
+	AppliedAt *time.Time
+	// The migration was applied but its files are gone
+	Missing bool
+}
+
+type MigratorOptions struct {
+	// How long the lock of an instance is valid, a crashed instance blocks the others at most this long.
+	// Only used for SQLite, Postgres and MySQL release their lock with the connection
+	LockTimeout time.Duration
+	// How often a waiting instance tries to get the lock
+	LockRetryInterval time.Duration
+}
+
+// Migrator applies the embedded migrations of the dialect of the database.
+// Only one instance migrates at a time, the others wait for the lock
+type Migrator struct {
+	db         *gorm.DB
+	dialect    string
+	migrations []Migration
+	options    MigratorOptions
+}
+
+func NewMigrator(db *gorm.DB, options MigratorOptions) (*Migrator, error) {
+	if options.LockTimeout <= 0 {
+		options.LockTimeout = 10 * time.Minute
+	}
+	if options.LockRetryInterval <= 0 {
+		options.LockRetryInterval = 500 * time.Millisecond
+	}
+	dialect := db.Dialector.Name()
+	migrations, err := LoadMigrations(migrationFiles, "migrations/"+dialect)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDialect, dialect)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations, options: options}, nil
+}
+
+// Migrate applies the pending migrations
+func Migrate(ctx context.Context, db *gorm.DB) error {
+	migrator, err := NewMigrator(db, MigratorOptions{})
+	if err != nil {
+		return err
+	}
+	_, err = migrator.Up(ctx)
+	return err
+}
+
+// LoadMigrations reads the migrations in dir ordered by version. Every migration needs an up and a down file
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies the pending migrations in order and returns them. Migrations of merged branches with an older
+// version than the last applied one are applied as well
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.locked(ctx, func(db *gorm.DB) error {
+		versions, err := m.appliedVersions(db)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(db, migration, migration.Up, func(tx *gorm.DB) error {
+				return tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", migration.Version, migration.Name, time.Now().UTC()).Error
+			}); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns them
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.locked(ctx, func(db *gorm.DB) error {
+		versions, err := m.appliedVersions(db)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			if err := m.apply(db, migration, migration.Down, func(tx *gorm.DB) error {
+				return tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version).Error
+			}); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists the migrations with the time they were applied, followed by applied migrations without files
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	db := m.db.WithContext(WithPrimary(ctx))
+	if err := m.ensureTables(db); err != nil {
+		return nil, err
+	}
+	versions, err := m.appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []MigrationStatus
+	known := map[int64]bool{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+		status := MigrationStatus{Migration: migration}
+		if row, ok := versions[migration.Version]; ok {
+			status.AppliedAt = &row.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	for version, row := range versions {
+		if !known[version] {
+			statuses = append(statuses, MigrationStatus{Migration: Migration{Version: version, Name: row.Name}, AppliedAt: &row.AppliedAt, Missing: true})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Missing != statuses[j].Missing {
+			return statuses[j].Missing
+		}
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// Pending counts the migrations that are not applied yet
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// CreateMigration writes empty up and down files for every dialect in dir and returns their paths
+func CreateMigration(dir string, name string, now time.Time) ([]string, error) {
+	name = strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return nil, errors.New("the migration needs a name")
+	}
+	dialects, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	version := now.UTC().Format("20060102150405")
+	var paths []string
+	for _, dialect := range dialects {
+		if !dialect.IsDir() {
+			continue
+		}
+		for _, direction := range []string{"up", "down"} {
+			path := filepath.Join(dir, dialect.Name(), fmt.Sprintf("%s_%s.%s.sql", version, name, direction))
+			content := fmt.Sprintf("-- %s %s for %s\n", name, direction, dialect.Name())
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				return paths, err
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+type schemaMigration struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+}
+
+func (m *Migrator) appliedVersions(db *gorm.DB) (map[int64]schemaMigration, error) {
+	var rows []schemaMigration
+	if err := db.Raw("SELECT version, name, applied_at FROM schema_migrations").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	versions := make(map[int64]schemaMigration, len(rows))
+	for _, row := range rows {
+		versions[row.Version] = row
+	}
+	return versions, nil
+}
+
+// apply runs the SQL and records it in the same transaction, so a failed migration leaves no trace.
+// MySQL commits schema changes right away, a failed migration there has to be cleaned up by hand
+func (m *Migrator) apply(db *gorm.DB, migration Migration, sql string, record func(tx *gorm.DB) error) error {
+	// The MySQL driver runs one statement at a time
+	statements := []string{sql}
+	if m.dialect == "mysql" {
+		statements = splitStatements(sql)
+	}
+	run := func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return record(tx)
+	}
+	if strings.Contains(sql, noTransaction) {
+		return run(db)
+	}
+	return db.Transaction(run)
+}
+
+func (m *Migrator) ensureTables(db *gorm.DB) error {
+	appliedAt := "datetime"
+	switch m.dialect {
+	case "postgres":
+		appliedAt = "timestamptz"
+	case "mysql":
+		appliedAt = "datetime(3)"
+	}
+	if err := db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version bigint PRIMARY KEY, name text NOT NULL, applied_at " + appliedAt + " NOT NULL)").Error; err != nil {
+		return err
+	}
+	if m.dialect != "sqlite" {
+		return nil
+	}
+	return db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations_lock (id integer PRIMARY KEY, owner text NOT NULL, expires_at bigint NOT NULL)").Error
+}
+
+// locked runs fn while holding the migration lock. Postgres and MySQL use a lock of a single connection, which
+// the server releases if the instance crashes. SQLite uses a row in schema_migrations_lock that expires after LockTimeout
+func (m *Migrator) locked(ctx context.Context, fn func(db *gorm.DB) error) error {
+	db := m.db.WithContext(WithPrimary(ctx))
+	if m.dialect == "postgres" || m.dialect == "mysql" {
+		return db.Connection(func(conn *gorm.DB) error {
+			if err := m.lockConnection(ctx, conn); err != nil {
+				return err
+			}
+			defer m.unlockConnection(conn.WithContext(context.Background()))
+			if err := m.ensureTables(conn); err != nil {
+				return err
+			}
+			return fn(conn)
+		})
+	}
+
+	if err := m.ensureTables(db); err != nil {
+		return err
+	}
+	owner, err := m.lockRow(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer m.db.Exec("DELETE FROM schema_migrations_lock WHERE id = 1 AND owner = ?", owner)
+	return fn(db)
+}
+
+func (m *Migrator) lockConnection(ctx context.Context, conn *gorm.DB) error {
+	for {
+		var acquired bool
+		var err error
+		if m.dialect == "mysql" {
+			err = conn.Raw("SELECT GET_LOCK(?, 0) = 1", lockName).Scan(&acquired).Error
+		} else {
+			err = conn.Raw("SELECT pg_try_advisory_lock(?)", lockKey()).Scan(&acquired).Error
+		}
+		if err != nil {
+			return m.lockError(ctx, err)
+		}
+		if acquired {
+			return nil
+		}
+		if err := m.wait(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *Migrator) unlockConnection(conn *gorm.DB) {
+	if m.dialect == "mysql" {
+		conn.Exec("SELECT RELEASE_LOCK(?)", lockName)
+		return
+	}
+	conn.Exec("SELECT pg_advisory_unlock(?)", lockKey())
+}
+
+func (m *Migrator) lockRow(ctx context.Context, db *gorm.DB) (string, error) {
+	owner := randomOwner()
+	for {
+		now := time.Now()
+		// The lock of a crashed instance expires
+		if err := db.Exec("DELETE FROM schema_migrations_lock WHERE expires_at < ?", now.Unix()).Error; err != nil {
+			return "", m.lockError(ctx, err)
+		}
+		result := db.Exec("INSERT INTO schema_migrations_lock (id, owner, expires_at) VALUES (1, ?, ?) ON CONFLICT DO NOTHING", owner, now.Add(m.options.LockTimeout).Unix())
+		if result.Error != nil {
+			return "", m.lockError(ctx, result.Error)
+		}
+		if result.RowsAffected == 1 {
+			return owner, nil
+		}
+		if err := m.wait(ctx); err != nil {
+			return "", err
+		}
+	}
+}
+
+// lockError reports a query canceled while waiting for the lock as ErrLocked
+func (m *Migrator) lockError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %v", ErrLocked, ctx.Err())
+	}
+	return err
+}
+
+func (m *Migrator) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", ErrLocked, ctx.Err())
+	case <-time.After(m.options.LockRetryInterval):
+		return nil
+	}
+}
+
+// The name of the MySQL lock, the key of the Postgres advisory lock is derived from it so it does not clash with
+// locks of the application
+const lockName = "schema_migrations"
+
+func lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(lockName))
+	return int64(h.Sum64())
+}
+
+// splitStatements splits SQL at the semicolons ending a line. Comment lines are left out
+func splitStatements(sql string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+func randomOwner() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
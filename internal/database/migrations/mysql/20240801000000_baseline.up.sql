@@ -0,0 +1,24 @@
+-- The users table as created by gorm's AutoMigrate before the migrations were introduced.
+-- Later columns and tables are added by the following migrations.
+-- MySQL has no CREATE INDEX IF NOT EXISTS, so the indexes are part of the tables. Indexed text columns need a length
+
+CREATE TABLE IF NOT EXISTS users (
+    id char(36) PRIMARY KEY,
+    created_at datetime(3),
+    updated_at datetime(3),
+    deleted_at datetime(3),
+    username varchar(255) NOT NULL,
+    email varchar(255) NOT NULL,
+    password text,
+    password_reset_token text,
+    password_reset_requested_at datetime(3),
+    verified_at datetime(3),
+    verify_mail_address text,
+    verify_mail_token text,
+    avatar_url text,
+    o_auth_provider text,
+    o_auth_id text,
+    CONSTRAINT uni_users_username UNIQUE (username),
+    CONSTRAINT uni_users_email UNIQUE (email),
+    INDEX idx_users_deleted_at (deleted_at)
+) DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,5 @@
+-- Roles of admins, bounces of the mail suppression list and the last login of broadcast segments
+ALTER TABLE users
+    ADD COLUMN role varchar(32) NOT NULL DEFAULT 'user',
+    ADD COLUMN email_bounced_at datetime(3),
+    ADD COLUMN last_login_at datetime(3);
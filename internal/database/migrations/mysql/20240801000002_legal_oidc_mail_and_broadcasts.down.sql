@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS broadcasts;
+DROP TABLE IF EXISTS notification_preferences;
+DROP TABLE IF EXISTS mail_suppressions;
+DROP TABLE IF EXISTS outbox_messages;
+DROP TABLE IF EXISTS oidc_consents;
+DROP TABLE IF EXISTS oidc_authorization_codes;
+DROP TABLE IF EXISTS oidc_clients;
+DROP TABLE IF EXISTS legal_acceptances;
@@ -0,0 +1,133 @@
+-- Tables of the legal documents, the OpenID Connect provider, the mail outbox, suppressions, notification preferences and broadcasts
+
+CREATE TABLE legal_acceptances (
+    id char(36) PRIMARY KEY,
+    created_at datetime(3),
+    updated_at datetime(3),
+    deleted_at datetime(3),
+    user_id char(36) NOT NULL,
+    document varchar(32) NOT NULL,
+    version varchar(255) NOT NULL,
+    accepted_at datetime(3) NOT NULL,
+    ip_address varchar(64),
+    INDEX idx_legal_acceptances_user_id (user_id),
+    INDEX idx_legal_acceptances_deleted_at (deleted_at)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE oidc_clients (
+    id char(36) PRIMARY KEY,
+    created_at datetime(3),
+    updated_at datetime(3),
+    deleted_at datetime(3),
+    name varchar(255) NOT NULL,
+    client_id varchar(255) NOT NULL,
+    secret_hash text,
+    redirect_uris text NOT NULL,
+    skip_consent boolean NOT NULL DEFAULT false,
+    CONSTRAINT uni_oidc_clients_client_id UNIQUE (client_id),
+    INDEX idx_oidc_clients_deleted_at (deleted_at)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE oidc_authorization_codes (
+    id char(36) PRIMARY KEY,
+    created_at datetime(3),
+    updated_at datetime(3),
+    deleted_at datetime(3),
+    code_hash varchar(255) NOT NULL,
+    client_id char(36) NOT NULL,
+    user_id char(36) NOT NULL,
+    redirect_uri text NOT NULL,
+    scope text NOT NULL,
+    nonce text,
+    code_challenge text,
+    code_challenge_method varchar(16),
+    auth_time datetime(3),
+    expires_at datetime(3) NOT NULL,
+    used_at datetime(3),
+    CONSTRAINT uni_oidc_authorization_codes_code_hash UNIQUE (code_hash),
+    INDEX idx_oidc_authorization_codes_deleted_at (deleted_at)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE oidc_consents (
+    id char(36) PRIMARY KEY,
+    created_at datetime(3),
+    updated_at datetime(3),
+    deleted_at datetime(3),
+    user_id char(36) NOT NULL,
+    client_id char(36) NOT NULL,
+    scope text NOT NULL,
+    UNIQUE INDEX idx_oidc_consent_user_client (user_id, client_id),
+    INDEX idx_oidc_consents_deleted_at (deleted_at)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE outbox_messages (
+    id char(36) PRIMARY KEY,
+    created_at datetime(3),
+    updated_at datetime(3),
+    deleted_at datetime(3),
+    status varchar(32) NOT NULL,
+    recipient varchar(255) NOT NULL,
+    subject text NOT NULL,
+    payload longtext NOT NULL,
+    attempts bigint NOT NULL DEFAULT 0,
+    next_attempt_at datetime(3) NOT NULL,
+    locked_until datetime(3),
+    last_error text,
+    sent_at datetime(3),
+    provider varchar(64),
+    INDEX idx_outbox_messages_status (status),
+    INDEX idx_outbox_messages_recipient (recipient),
+    INDEX idx_outbox_messages_next_attempt_at (next_attempt_at),
+    INDEX idx_outbox_messages_sent_at (sent_at),
+    INDEX idx_outbox_messages_deleted_at (deleted_at)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE mail_suppressions (
+    id char(36) PRIMARY KEY,
+    created_at datetime(3),
+    updated_at datetime(3),
+    deleted_at datetime(3),
+    email varchar(255) NOT NULL,
+    reason varchar(32) NOT NULL,
+    detail text,
+    source varchar(64) NOT NULL,
+    CONSTRAINT uni_mail_suppressions_email UNIQUE (email),
+    INDEX idx_mail_suppressions_deleted_at (deleted_at)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE notification_preferences (
+    id char(36) PRIMARY KEY,
+    created_at datetime(3),
+    updated_at datetime(3),
+    deleted_at datetime(3),
+    user_id char(36) NOT NULL,
+    category varchar(64) NOT NULL,
+    subscribed boolean NOT NULL,
+    UNIQUE INDEX idx_notification_preferences_user_category (user_id, category),
+    INDEX idx_notification_preferences_deleted_at (deleted_at)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE broadcasts (
+    id char(36) PRIMARY KEY,
+    created_at datetime(3),
+    updated_at datetime(3),
+    deleted_at datetime(3),
+    subject text NOT NULL,
+    body longtext NOT NULL,
+    category varchar(64) NOT NULL,
+    segment varchar(32) NOT NULL,
+    segment_domain varchar(255),
+    status varchar(32) NOT NULL,
+    created_by_id char(36) NOT NULL,
+    total bigint NOT NULL DEFAULT 0,
+    sent bigint NOT NULL DEFAULT 0,
+    skipped bigint NOT NULL DEFAULT 0,
+    failed bigint NOT NULL DEFAULT 0,
+    `cursor` char(36),
+    last_error text,
+    locked_until datetime(3),
+    started_at datetime(3),
+    finished_at datetime(3),
+    INDEX idx_broadcasts_status (status),
+    INDEX idx_broadcasts_deleted_at (deleted_at)
+) DEFAULT CHARSET = utf8mb4;
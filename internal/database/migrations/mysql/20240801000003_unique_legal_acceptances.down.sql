@@ -0,0 +1 @@
+DROP INDEX idx_legal_acceptances_user_document_version ON legal_acceptances;
@@ -0,0 +1,4 @@
+-- Double submits of the acceptance form recorded the same version twice, one of them is kept
+DELETE a FROM legal_acceptances a JOIN legal_acceptances b
+    ON a.user_id = b.user_id AND a.document = b.document AND a.version = b.version AND a.id > b.id;
+CREATE UNIQUE INDEX idx_legal_acceptances_user_document_version ON legal_acceptances (user_id, document, version);
@@ -0,0 +1,2 @@
+DROP INDEX idx_outbox_messages_broadcast_id ON outbox_messages;
+ALTER TABLE outbox_messages DROP COLUMN broadcast_id;
@@ -0,0 +1,3 @@
+-- Broadcast mails in the outbox count on their broadcast once they are sent or dead-lettered
+ALTER TABLE outbox_messages ADD COLUMN broadcast_id char(36);
+CREATE INDEX idx_outbox_messages_broadcast_id ON outbox_messages (broadcast_id);
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    DROP COLUMN role,
+    DROP COLUMN email_bounced_at,
+    DROP COLUMN last_login_at;
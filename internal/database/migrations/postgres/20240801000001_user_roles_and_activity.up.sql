@@ -0,0 +1,5 @@
+-- Roles of admins, bounces of the mail suppression list and the last login of broadcast segments
+ALTER TABLE users
+    ADD COLUMN role text NOT NULL DEFAULT 'user',
+    ADD COLUMN email_bounced_at timestamptz,
+    ADD COLUMN last_login_at timestamptz;
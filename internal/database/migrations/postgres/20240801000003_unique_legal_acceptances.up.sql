@@ -0,0 +1,4 @@
+-- Double submits of the acceptance form recorded the same version twice, one of them is kept
+DELETE FROM legal_acceptances a USING legal_acceptances b
+WHERE a.user_id = b.user_id AND a.document = b.document AND a.version = b.version AND a.ctid > b.ctid;
+CREATE UNIQUE INDEX idx_legal_acceptances_user_document_version ON legal_acceptances (user_id, document, version);
@@ -0,0 +1,22 @@
+-- The users table as created by gorm's AutoMigrate before the migrations were introduced.
+-- Later columns and tables are added by the following migrations.
+-- IF NOT EXISTS keeps databases created by AutoMigrate working.
+
+CREATE TABLE IF NOT EXISTS users (
+    id uuid PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    username text NOT NULL CONSTRAINT uni_users_username UNIQUE,
+    email text NOT NULL CONSTRAINT uni_users_email UNIQUE,
+    password text,
+    password_reset_token text,
+    password_reset_requested_at datetime,
+    verified_at datetime,
+    verify_mail_address text,
+    verify_mail_token text,
+    avatar_url text,
+    o_auth_provider text,
+    o_auth_id text
+);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
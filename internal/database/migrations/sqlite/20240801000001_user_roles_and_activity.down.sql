@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN role;
+ALTER TABLE users DROP COLUMN email_bounced_at;
+ALTER TABLE users DROP COLUMN last_login_at;
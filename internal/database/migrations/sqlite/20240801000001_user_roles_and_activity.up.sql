@@ -0,0 +1,4 @@
+-- Roles of admins, bounces of the mail suppression list and the last login of broadcast segments
+ALTER TABLE users ADD COLUMN role text NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN email_bounced_at datetime;
+ALTER TABLE users ADD COLUMN last_login_at datetime;
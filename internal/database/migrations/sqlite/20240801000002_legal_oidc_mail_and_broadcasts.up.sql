@@ -0,0 +1,130 @@
+-- Tables of the legal documents, the OpenID Connect provider, the mail outbox, suppressions, notification preferences and broadcasts
+
+CREATE TABLE legal_acceptances (
+    id uuid PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    user_id uuid NOT NULL,
+    document text NOT NULL,
+    version text NOT NULL,
+    accepted_at datetime NOT NULL,
+    ip_address text
+);
+CREATE INDEX idx_legal_acceptances_user_id ON legal_acceptances (user_id);
+CREATE INDEX idx_legal_acceptances_deleted_at ON legal_acceptances (deleted_at);
+
+CREATE TABLE oidc_clients (
+    id uuid PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    name text NOT NULL,
+    client_id text NOT NULL CONSTRAINT uni_oidc_clients_client_id UNIQUE,
+    secret_hash text,
+    redirect_uris text NOT NULL,
+    skip_consent numeric NOT NULL DEFAULT false
+);
+CREATE INDEX idx_oidc_clients_deleted_at ON oidc_clients (deleted_at);
+
+CREATE TABLE oidc_authorization_codes (
+    id uuid PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    code_hash text NOT NULL CONSTRAINT uni_oidc_authorization_codes_code_hash UNIQUE,
+    client_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    redirect_uri text NOT NULL,
+    scope text NOT NULL,
+    nonce text,
+    code_challenge text,
+    code_challenge_method text,
+    auth_time datetime,
+    expires_at datetime NOT NULL,
+    used_at datetime
+);
+CREATE INDEX idx_oidc_authorization_codes_deleted_at ON oidc_authorization_codes (deleted_at);
+
+CREATE TABLE oidc_consents (
+    id uuid PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    user_id uuid NOT NULL,
+    client_id uuid NOT NULL,
+    scope text NOT NULL
+);
+CREATE UNIQUE INDEX idx_oidc_consent_user_client ON oidc_consents (user_id, client_id);
+CREATE INDEX idx_oidc_consents_deleted_at ON oidc_consents (deleted_at);
+
+CREATE TABLE outbox_messages (
+    id uuid PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    status text NOT NULL,
+    recipient text NOT NULL,
+    subject text NOT NULL,
+    payload text NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at datetime NOT NULL,
+    locked_until datetime,
+    last_error text,
+    sent_at datetime,
+    provider text
+);
+CREATE INDEX idx_outbox_messages_status ON outbox_messages (status);
+CREATE INDEX idx_outbox_messages_recipient ON outbox_messages (recipient);
+CREATE INDEX idx_outbox_messages_next_attempt_at ON outbox_messages (next_attempt_at);
+CREATE INDEX idx_outbox_messages_sent_at ON outbox_messages (sent_at);
+CREATE INDEX idx_outbox_messages_deleted_at ON outbox_messages (deleted_at);
+
+CREATE TABLE mail_suppressions (
+    id uuid PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    email text NOT NULL CONSTRAINT uni_mail_suppressions_email UNIQUE,
+    reason text NOT NULL,
+    detail text,
+    source text NOT NULL
+);
+CREATE INDEX idx_mail_suppressions_deleted_at ON mail_suppressions (deleted_at);
+
+CREATE TABLE notification_preferences (
+    id uuid PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    user_id uuid NOT NULL,
+    category text NOT NULL,
+    subscribed numeric NOT NULL
+);
+CREATE UNIQUE INDEX idx_notification_preferences_user_category ON notification_preferences (user_id, category);
+CREATE INDEX idx_notification_preferences_deleted_at ON notification_preferences (deleted_at);
+
+CREATE TABLE broadcasts (
+    id uuid PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    subject text NOT NULL,
+    body text NOT NULL,
+    category text NOT NULL,
+    segment text NOT NULL,
+    segment_domain text,
+    status text NOT NULL,
+    created_by_id uuid NOT NULL,
+    total integer NOT NULL DEFAULT 0,
+    sent integer NOT NULL DEFAULT 0,
+    skipped integer NOT NULL DEFAULT 0,
+    failed integer NOT NULL DEFAULT 0,
+    cursor uuid,
+    last_error text,
+    locked_until datetime,
+    started_at datetime,
+    finished_at datetime
+);
+CREATE INDEX idx_broadcasts_status ON broadcasts (status);
+CREATE INDEX idx_broadcasts_deleted_at ON broadcasts (deleted_at);
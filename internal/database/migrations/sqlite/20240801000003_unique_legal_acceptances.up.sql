@@ -0,0 +1,5 @@
+-- Double submits of the acceptance form recorded the same version twice, one of them is kept
+DELETE FROM legal_acceptances WHERE rowid NOT IN (
+    SELECT MIN(rowid) FROM legal_acceptances GROUP BY user_id, document, version
+);
+CREATE UNIQUE INDEX idx_legal_acceptances_user_document_version ON legal_acceptances (user_id, document, version);
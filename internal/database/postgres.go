@@ -1,36 +1,156 @@
 package database
 
 import (
+	"atomic-go-template/internal/config"
+	"context"
+	"database/sql"
 	"fmt"
-	"log"
-	"os"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 )
 
-var (
-	// Postgres
-	database = os.Getenv("DB_DATABASE")
-	password = os.Getenv("DB_PASSWORD")
-	username = os.Getenv("DB_USERNAME")
-	port     = os.Getenv("DB_PORT")
-	host     = os.Getenv("DB_HOST")
-	schema   = os.Getenv("DB_SCHEMA")
-)
+func NewPostgresService(ctx context.Context, c config.Database) (Service, error) {
+	s, err := open(ctx, postgres.Open(PostgresDSN(c)), c)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Replicas) > 0 {
+		if s.replicas, err = newPostgresReplicas(c); err != nil {
+			s.Close()
+			return nil, err
+		}
+		if err := s.db.Use(s.replicas); err != nil {
+			s.Close()
+			return nil, err
+		}
+		// Unreachable replicas are skipped, the primary serves the reads until they are back
+		s.replicas.Check(ctx)
+		var checks context.Context
+		checks, s.stop = context.WithCancel(context.Background())
+		go s.replicas.Run(checks)
+	}
+	return s, nil
+}
 
-func NewPostgresService() Service {
-	// Reuse Connection
-	if dbInstance != nil {
-		return dbInstance
+// newPostgresReplicas opens the replicas of the config with the settings of the primary
+func newPostgresReplicas(c config.Database) (*ReplicaRouter, error) {
+	var replicas []Replica
+	for i, dsn := range c.Replicas {
+		replicaConfig := c
+		replicaConfig.DSN = dsn
+		sqlDB, err := sql.Open("pgx", PostgresDSN(replicaConfig))
+		if err != nil {
+			for _, replica := range replicas {
+				replica.DB.Close()
+			}
+			return nil, fmt.Errorf("database: opening replica %s: %w", replicaName(dsn, i), err)
+		}
+		configureSQLPool(sqlDB, c)
+		replicas = append(replicas, Replica{Name: replicaName(dsn, i), DB: sqlDB})
 	}
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable search_path=%s", host, username, password, database, port, schema)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		log.Fatal(err)
+	return NewReplicaRouter(replicas, ReplicaOptions{
+		MaxLag:        c.ReplicaMaxLag,
+		CheckInterval: c.ReplicaCheckInterval,
+		Lag:           postgresReplicaLag,
+	}), nil
+}
+
+// replicaName returns the host of the connection string, so the health report doesn't show passwords
+func replicaName(dsn string, i int) string {
+	if u, err := url.Parse(dsn); err == nil && u.Host != "" {
+		return u.Host
+	}
+	for _, pair := range strings.Fields(dsn) {
+		if host, ok := strings.CutPrefix(pair, "host="); ok {
+			return host
+		}
+	}
+	return fmt.Sprintf("replica-%d", i+1)
+}
+
+// postgresReplicaLag returns the time since the last replayed transaction. A replica that replayed everything it
+// received has no lag, even if the primary had no writes for a while
+func postgresReplicaLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	var seconds float64
+	err := db.QueryRowContext(ctx, `SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END`).Scan(&seconds)
+	return time.Duration(seconds * float64(time.Second)), err
+}
+
+// PostgresDSN returns the connection string of the config, or builds it from the host and credentials of the config.
+// The TLS settings and the statement timeout are added unless the connection string sets them
+func PostgresDSN(c config.Database) string {
+	dsn := c.DSN
+	if dsn == "" {
+		dsn = keywordDSN([][2]string{
+			{"host", c.Host},
+			{"port", c.Port},
+			{"user", c.Username},
+			{"password", c.Password},
+			{"dbname", c.Name},
+			{"search_path", c.Schema},
+		})
+	}
+	settings := [][2]string{
+		{"sslmode", string(c.SSLMode)},
+		{"sslrootcert", c.SSLRootCert},
+		{"sslcert", c.SSLCert},
+		{"sslkey", c.SSLKey},
+	}
+	if c.StatementTimeout > 0 {
+		// Unknown settings are sent to the server as run-time parameters
+		settings = append(settings, [2]string{"statement_timeout", strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)})
+	}
+	return withSettings(dsn, settings)
+}
+
+// withSettings adds the non-empty settings missing in a URL or keyword/value connection string
+func withSettings(dsn string, settings [][2]string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			// pgx reports the invalid connection string
+			return dsn
+		}
+		query := u.Query()
+		for _, setting := range settings {
+			if setting[1] != "" && !query.Has(setting[0]) {
+				query.Set(setting[0], setting[1])
+			}
+		}
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	var missing [][2]string
+	for _, setting := range settings {
+		if !hasKeyword(dsn, setting[0]) {
+			missing = append(missing, setting)
+		}
 	}
-	dbInstance = &service{
-		db: db,
+	return strings.TrimSpace(dsn + " " + keywordDSN(missing))
+}
+
+// keywordDSN joins the non-empty settings to "key=value" pairs, quoting values with spaces or quotes
+func keywordDSN(settings [][2]string) string {
+	var pairs []string
+	for _, setting := range settings {
+		if setting[1] == "" {
+			continue
+		}
+		value := setting[1]
+		if strings.ContainsAny(value, ` '\`) {
+			value = "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%s", setting[0], value))
 	}
-	return dbInstance
+	return strings.Join(pairs, " ")
+}
+
+func hasKeyword(dsn string, key string) bool {
+	return strings.HasPrefix(dsn, key+"=") || strings.Contains(dsn, " "+key+"=")
 }
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type primaryKey struct{}
+
+// WithPrimary returns a context whose queries go to the primary, f.e. for reads right after a write.
+// Queries need the context: db.WithContext(ctx)
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func usesPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+// ReplicaStatus is reported by the health endpoint for every replica
+type ReplicaStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	// How far the replica is behind the primary
+	Lag       string    `json:"lag"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	Reads     uint64    `json:"reads"`
+}
+
+type Replica struct {
+	// Shown in the health report, it must not contain the password
+	Name string
+	DB   *sql.DB
+}
+
+type ReplicaOptions struct {
+	// Replicas lagging further behind are skipped until they catch up, 0 disables the check
+	MaxLag time.Duration
+	// How often Run checks the replicas
+	CheckInterval time.Duration
+	// Measures how far a replica is behind, nil if the database can't tell
+	Lag func(ctx context.Context, db *sql.DB) (time.Duration, error)
+}
+
+type replica struct {
+	name  string
+	db    *sql.DB
+	reads atomic.Uint64
+
+	mu     sync.Mutex
+	status ReplicaStatus
+}
+
+// ReplicaRouter is a gorm plugin sending reads to healthy replicas. Writes, transactions, locking reads and
+// queries with a WithPrimary context go to the primary, as do all reads if no replica is healthy
+type ReplicaRouter struct {
+	primary  gorm.ConnPool
+	replicas []*replica
+	options  ReplicaOptions
+	next     atomic.Uint64
+}
+
+func NewReplicaRouter(replicas []Replica, options ReplicaOptions) *ReplicaRouter {
+	if options.CheckInterval <= 0 {
+		options.CheckInterval = 10 * time.Second
+	}
+	router := &ReplicaRouter{options: options}
+	for _, r := range replicas {
+		// Replicas are used until the first check says otherwise
+		router.replicas = append(router.replicas, &replica{name: r.Name, db: r.DB, status: ReplicaStatus{Name: r.Name, Healthy: true}})
+	}
+	return router
+}
+
+func (r *ReplicaRouter) Name() string {
+	return "replicas"
+}
+
+// Initialize registers the routing before queries, gorm calls it in db.Use
+func (r *ReplicaRouter) Initialize(db *gorm.DB) error {
+	r.primary = db.ConnPool
+	if err := db.Callback().Query().Before("gorm:query").Register("replicas:query", r.route); err != nil {
+		return err
+	}
+	return db.Callback().Row().Before("gorm:row").Register("replicas:row", r.route)
+}
+
+func (r *ReplicaRouter) route(db *gorm.DB) {
+	stmt := db.Statement
+	// Transactions and single connections use *sql.Tx and *sql.Conn instead of the pool of the primary
+	if stmt.ConnPool != r.primary || (stmt.Context != nil && usesPrimary(stmt.Context)) {
+		return
+	}
+	// SELECT ... FOR UPDATE
+	if _, locking := stmt.Clauses["FOR"]; locking {
+		return
+	}
+	// Raw SQL is only routed if it reads
+	if stmt.SQL.Len() > 0 && !isRead(stmt.SQL.String()) {
+		return
+	}
+	if replica := r.pick(); replica != nil {
+		stmt.ConnPool = replica.db
+		replica.reads.Add(1)
+	}
+}
+
+func isRead(sql string) bool {
+	sql = strings.ToLower(strings.TrimSpace(sql))
+	return strings.HasPrefix(sql, "select") && !strings.Contains(sql, " for update") && !strings.Contains(sql, " for share")
+}
+
+// pick returns the next healthy replica, nil if there is none
+func (r *ReplicaRouter) pick() *replica {
+	start := r.next.Add(1)
+	for i := range r.replicas {
+		replica := r.replicas[(start+uint64(i))%uint64(len(r.replicas))]
+		replica.mu.Lock()
+		healthy := replica.status.Healthy
+		replica.mu.Unlock()
+		if healthy {
+			return replica
+		}
+	}
+	return nil
+}
+
+// Check pings the replicas and measures their lag. Failing or lagging replicas are skipped until the next check
+func (r *ReplicaRouter) Check(ctx context.Context) {
+	for _, replica := range r.replicas {
+		checkCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		err := replica.db.PingContext(checkCtx)
+		var lag time.Duration
+		if err == nil && r.options.Lag != nil {
+			lag, err = r.options.Lag(checkCtx, replica.db)
+			if err == nil && r.options.MaxLag > 0 && lag > r.options.MaxLag {
+				err = fmt.Errorf("lagging %s behind the primary", lag.Round(time.Millisecond))
+			}
+		}
+		cancel()
+
+		replica.mu.Lock()
+		if err != nil && replica.status.Healthy {
+			log.Printf("Database replica %s is skipped: %v", replica.name, err)
+		} else if err == nil && !replica.status.Healthy {
+			log.Printf("Database replica %s is healthy again", replica.name)
+		}
+		replica.status.Healthy = err == nil
+		replica.status.Lag = lag.String()
+		replica.status.Error = ""
+		if err != nil {
+			replica.status.Error = err.Error()
+		}
+		replica.status.CheckedAt = time.Now()
+		replica.mu.Unlock()
+	}
+}
+
+// Run checks the replicas every CheckInterval until the context is canceled
+func (r *ReplicaRouter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.options.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Check(ctx)
+		}
+	}
+}
+
+// Status returns the state of the replicas of the last check
+func (r *ReplicaRouter) Status() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(r.replicas))
+	for _, replica := range r.replicas {
+		replica.mu.Lock()
+		status := replica.status
+		replica.mu.Unlock()
+		status.Reads = replica.reads.Load()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Close closes the connections to the replicas
+func (r *ReplicaRouter) Close() error {
+	var firstErr error
+	for _, replica := range r.replicas {
+		if err := replica.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
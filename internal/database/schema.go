@@ -2,13 +2,20 @@ package database
 
 import (
 	"atomic-go-template/internal/model"
-
-	"gorm.io/gorm"
 )
 
-// MigrateUserSchema migrates the user schema to the database.
-func MigrateUserSchema(db *gorm.DB) error {
-	return db.AutoMigrate(&model.User{})
+// Models are stored in the tables created by the migrations in the migrations folder.
+// A new field needs a migration, the tests check that every field has a column
+var Models = []interface{}{
+	&model.User{},
+	&model.LegalAcceptance{},
+	&model.OIDCClient{},
+	&model.OIDCAuthorizationCode{},
+	&model.OIDCConsent{},
+	&model.OutboxMessage{},
+	&model.MailSuppression{},
+	&model.NotificationPreference{},
+	&model.Broadcast{},
 }
 
 // Models are in the models folder
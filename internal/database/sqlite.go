@@ -1,35 +1,78 @@
 package database
 
 import (
-	"log"
+	"atomic-go-template/internal/config"
+	"context"
+	"fmt"
+	"net/url"
 	"os"
-
-	"gorm.io/driver/sqlite" // Sqlite driver based on CGO
-	"gorm.io/gorm"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-var (
-	//SQLite
-	file = os.Getenv("DB_FILE")
-)
+// The driver is chosen at build time: sqlite_cgo.go uses mattn/go-sqlite3, sqlite_purego.go a pure Go port of SQLite
+// for CGO_ENABLED=0 or the purego build tag. Both write the same file format and times
 
-func NewSQLiteService() Service {
-	// Reuse Connection
-	if dbInstance != nil {
-		return dbInstance
+func NewSQLiteService(ctx context.Context, c config.Database) (Service, error) {
+	dsn := SQLiteDSN(c)
+	// Make sure the directory of the file exists
+	if !strings.Contains(dsn, ":memory:") && !strings.Contains(dsn, "mode=memory") {
+		if dir := filepath.Dir(sqliteFile(dsn)); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, fmt.Errorf("database: creating directory of the SQLite file: %w", err)
+			}
+		}
 	}
-	// Make sure the directory db exists
-	if _, err := os.Stat("db"); os.IsNotExist(err) {
-		os.Mkdir("db", 0755)
+	return open(ctx, sqliteDialector(dsn), c)
+}
+
+// SQLiteDSN returns the connection string or the file of the config. The pragmas are added as parameters of the
+// driver unless the connection string sets them, so every connection of the pool gets them
+func SQLiteDSN(c config.Database) string {
+	dsn := c.DSN
+	if dsn == "" {
+		dsn = c.File
 	}
-	if file == "" {
-		file = "db/dev.sqlite"
+	if dsn == "" {
+		dsn = "db/dev.sqlite"
 	}
-	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	base, rawQuery, _ := strings.Cut(dsn, "?")
+	query, err := url.ParseQuery(rawQuery)
 	if err != nil {
-		log.Fatal(err)
+		// The driver reports the invalid connection string
+		return dsn
+	}
+	pragmas := [][2]string{
+		{"journal_mode", c.SQLite.JournalMode},
+		{"synchronous", c.SQLite.Synchronous},
+		{"foreign_keys", boolPragma(c.SQLite.ForeignKeys)},
+	}
+	if c.SQLite.BusyTimeout > 0 {
+		pragmas = append(pragmas, [2]string{"busy_timeout", strconv.FormatInt(c.SQLite.BusyTimeout.Milliseconds(), 10)})
+	}
+	for _, pragma := range pragmas {
+		if pragma[1] != "" {
+			addSQLitePragma(query, pragma[0], pragma[1])
+		}
 	}
-	return &service{
-		db: db,
+	return base + "?" + query.Encode()
+}
+
+// SQLiteFile returns the path of the database file of the config, f.e. to restore a backup
+func SQLiteFile(c config.Database) string {
+	return sqliteFile(SQLiteDSN(c))
+}
+
+// sqliteFile returns the path of the database file in the connection string
+func sqliteFile(dsn string) string {
+	file, _, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	return file
+}
+
+func boolPragma(enabled bool) string {
+	if enabled {
+		return "1"
 	}
+	return "0"
 }
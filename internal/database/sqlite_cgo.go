@@ -0,0 +1,34 @@
+//go:build cgo && !purego
+
+package database
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite" // Sqlite driver based on CGO
+	"gorm.io/gorm"
+)
+
+// SQLiteDriver is the SQLite driver of the build
+const SQLiteDriver = "mattn/go-sqlite3"
+
+func sqliteDialector(dsn string) gorm.Dialector {
+	return sqlite.Open(dsn)
+}
+
+// addSQLitePragma adds the pragma as "_name=value", the parameters of mattn/go-sqlite3
+func addSQLitePragma(query url.Values, name string, value string) {
+	if !query.Has("_" + name) {
+		query.Set("_"+name, value)
+	}
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
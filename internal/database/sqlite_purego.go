@@ -0,0 +1,44 @@
+//go:build !cgo || purego
+
+package database
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	sqlite3 "github.com/glebarez/go-sqlite"
+	"github.com/glebarez/sqlite" // Sqlite driver without CGO
+	"gorm.io/gorm"
+)
+
+// SQLiteDriver is the SQLite driver of the build
+const SQLiteDriver = "glebarez/go-sqlite"
+
+// Extended result codes of SQLite, see https://www.sqlite.org/rescode.html
+const (
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+func sqliteDialector(dsn string) gorm.Dialector {
+	return sqlite.Open(dsn)
+}
+
+// addSQLitePragma adds the pragma as "_pragma=name(value)", the parameter of glebarez/go-sqlite
+func addSQLitePragma(query url.Values, name string, value string) {
+	for _, pragma := range query["_pragma"] {
+		if strings.HasPrefix(strings.ToLower(pragma), name+"(") || strings.HasPrefix(strings.ToLower(pragma), name+"=") {
+			return
+		}
+	}
+	query.Add("_pragma", name+"("+value+")")
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code() == sqliteConstraintUnique || sqliteErr.Code() == sqliteConstraintPrimaryKey
+}
@@ -0,0 +1,72 @@
+package inbound
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidAddress is returned for reply addresses that were not signed by the app
+var ErrInvalidAddress = errors.New("invalid reply address")
+
+const (
+	replyPrefix = "reply+"
+	// Truncated HMAC-SHA256, long enough that it can't be guessed and short enough for the 64 characters of a local part
+	signatureLength = 10
+)
+
+// Lowercase, some servers lowercase the local part
+var encoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+var secretKey = []byte(os.Getenv("SECRET_KEY"))
+
+// ReplyAddress returns the Reply-To address for a mail to the user. Replies to it are dispatched to the handler
+// of the context kind, the part before the first colon. Keep the context short, f.e. "ticket:42"
+func ReplyAddress(domain string, userID uuid.UUID, context string) string {
+	payload := encoding.EncodeToString(append(userID[:], context...))
+	return replyPrefix + payload + "-" + encoding.EncodeToString(sign(payload)) + "@" + domain
+}
+
+// ParseReplyAddress returns the user and context of an address created by ReplyAddress for the domain
+func ParseReplyAddress(address, domain string) (uuid.UUID, string, error) {
+	local, addressDomain, found := strings.Cut(strings.Trim(strings.ToLower(address), "<> "), "@")
+	if !found || addressDomain != strings.ToLower(domain) {
+		return uuid.Nil, "", ErrInvalidAddress
+	}
+	token, found := strings.CutPrefix(local, replyPrefix)
+	if !found {
+		return uuid.Nil, "", ErrInvalidAddress
+	}
+	payload, encodedSignature, found := strings.Cut(token, "-")
+	if !found {
+		return uuid.Nil, "", ErrInvalidAddress
+	}
+	signature, err := encoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, sign(payload)) {
+		return uuid.Nil, "", ErrInvalidAddress
+	}
+	decoded, err := encoding.DecodeString(payload)
+	if err != nil || len(decoded) < len(uuid.UUID{}) {
+		return uuid.Nil, "", ErrInvalidAddress
+	}
+	userID, _ := uuid.FromBytes(decoded[:len(uuid.UUID{})])
+	return userID, string(decoded[len(uuid.UUID{}):]), nil
+}
+
+// contextKind returns the part of the context before the first colon
+func contextKind(context string) string {
+	kind, _, _ := strings.Cut(context, ":")
+	return kind
+}
+
+func sign(payload string) []byte {
+	// The prefix keeps the signature from being valid for other uses of the secret
+	mac := hmac.New(sha256.New, secretKey)
+	mac.Write([]byte("reply:" + payload))
+	return mac.Sum(nil)[:signatureLength]
+}
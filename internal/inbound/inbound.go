@@ -0,0 +1,259 @@
+// Package inbound receives replies to the mails of the app with an embedded SMTP server.
+// Mails sent with a ReplyAddress as Reply-To come back signed with the user and the context they belong to,
+// the receiver verifies the address, strips the quoted mail and calls the handler registered for the context
+package inbound
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/mail"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/google/uuid"
+)
+
+type Options struct {
+	Addr            string
+	Domain          string
+	MaxMessageBytes int64
+	MaxRecipients   int
+	// Offered with STARTTLS if set
+	TLSConfig *tls.Config
+}
+
+// OptionsFromConfig returns the options of the config. The certificate for STARTTLS is read from the files
+// in INBOUND_SMTP_CERT_FILE and INBOUND_SMTP_KEY_FILE
+func OptionsFromConfig(c config.Inbound) (Options, error) {
+	options := Options{
+		Addr:            c.Addr,
+		Domain:          c.Domain,
+		MaxMessageBytes: c.MaxMessageBytes,
+		MaxRecipients:   c.MaxRecipients,
+	}
+	certFile, keyFile := os.Getenv("INBOUND_SMTP_CERT_FILE"), os.Getenv("INBOUND_SMTP_KEY_FILE")
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return Options{}, fmt.Errorf("loading inbound smtp certificate: %w", err)
+		}
+		options.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	}
+	return options, nil
+}
+
+// Reply is a verified reply to a mail of the app
+type Reply struct {
+	UserID uuid.UUID
+	// The context passed to ReplyAddress, f.e. "ticket:42"
+	Context string
+	// The sender as written in the From header. The signed address proves the mail was sent to the user,
+	// not that the user sent the reply. Compare it to the address of the user for sensitive actions
+	From string
+	// The text of the reply without the quoted mail and the signature
+	Text    string
+	Message mail.ParsedMessage
+}
+
+// Handler processes a reply. Returning an error makes the sending server deliver the mail again later,
+// handlers should skip replies they already processed, f.e. by Message.MessageID
+type Handler func(ctx context.Context, reply Reply) error
+
+// ErrNoHandler is returned for replies to a context kind without a registered handler
+var ErrNoHandler = errors.New("no handler for reply context")
+
+type Receiver struct {
+	options  Options
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	server   *smtp.Server
+}
+
+func NewReceiver(options Options) *Receiver {
+	if options.Addr == "" {
+		options.Addr = ":2525"
+	}
+	if options.MaxMessageBytes == 0 {
+		options.MaxMessageBytes = 10 << 20
+	}
+	if options.MaxRecipients == 0 {
+		options.MaxRecipients = 10
+	}
+	receiver := &Receiver{options: options, handlers: map[string]Handler{}}
+	server := smtp.NewServer(receiver)
+	server.Addr = options.Addr
+	server.Domain = options.Domain
+	server.MaxMessageBytes = options.MaxMessageBytes
+	server.MaxRecipients = options.MaxRecipients
+	server.ReadTimeout = time.Minute
+	server.WriteTimeout = time.Minute
+	server.TLSConfig = options.TLSConfig
+	receiver.server = server
+	return receiver
+}
+
+// Handle registers the handler for replies to contexts of the kind, f.e. "ticket" for "ticket:42"
+func (r *Receiver) Handle(kind string, handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[kind] = handler
+}
+
+func (r *Receiver) handler(kind string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	handler, ok := r.handlers[kind]
+	return handler, ok
+}
+
+// ListenAndServe accepts mail on the configured address until Close is called
+func (r *Receiver) ListenAndServe() error {
+	log.Printf("Receiving mail for %s on %s", r.options.Domain, r.options.Addr)
+	err := r.server.ListenAndServe()
+	if errors.Is(err, smtp.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Serve accepts mail on the listener until Close is called
+func (r *Receiver) Serve(listener net.Listener) error {
+	err := r.server.Serve(listener)
+	if errors.Is(err, smtp.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (r *Receiver) Close() error {
+	return r.server.Close()
+}
+
+// Receive dispatches a raw mail sent to the recipients, as if it was received by SMTP.
+// Useful for providers that post inbound mails to a webhook
+func (r *Receiver) Receive(ctx context.Context, recipients []string, data io.Reader) error {
+	var verified []recipient
+	for _, address := range recipients {
+		recipient, err := r.verify(address)
+		if err != nil {
+			return err
+		}
+		verified = append(verified, recipient)
+	}
+	message, err := mail.ParseMessage(data)
+	if err != nil {
+		return err
+	}
+	return r.dispatch(ctx, verified, message)
+}
+
+type recipient struct {
+	userID  uuid.UUID
+	context string
+	handler Handler
+}
+
+func (r *Receiver) verify(address string) (recipient, error) {
+	userID, replyContext, err := ParseReplyAddress(address, r.options.Domain)
+	if err != nil {
+		return recipient{}, err
+	}
+	handler, ok := r.handler(contextKind(replyContext))
+	if !ok {
+		return recipient{}, fmt.Errorf("%w: %q", ErrNoHandler, replyContext)
+	}
+	return recipient{userID: userID, context: replyContext, handler: handler}, nil
+}
+
+func (r *Receiver) dispatch(ctx context.Context, recipients []recipient, message mail.ParsedMessage) error {
+	// Out of office notices and other automatic replies would start a loop
+	if isAutoReply(message) {
+		log.Printf("Ignoring automatic reply %q from %s", message.Subject, message.From)
+		return nil
+	}
+	text := message.Text
+	if text == "" && message.HTML != "" {
+		var err error
+		if text, err = mail.HTMLToText(message.HTML); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for _, recipient := range recipients {
+		err := recipient.handler(ctx, Reply{
+			UserID:  recipient.userID,
+			Context: recipient.context,
+			From:    message.From,
+			Text:    StripQuotedReply(text),
+			Message: message,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("handling reply to %q: %w", recipient.context, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isAutoReply checks the headers of RFC 3834 and the ones used before it
+func isAutoReply(message mail.ParsedMessage) bool {
+	if submitted := message.Header.Get("Auto-Submitted"); submitted != "" && !strings.EqualFold(submitted, "no") {
+		return true
+	}
+	switch strings.ToLower(message.Header.Get("Precedence")) {
+	case "bulk", "junk", "list", "auto_reply":
+		return true
+	}
+	return message.Header.Get("X-Autoreply") != "" || message.Header.Get("X-Autorespond") != ""
+}
+
+// NewSession implements smtp.Backend
+func (r *Receiver) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return &session{receiver: r}, nil
+}
+
+type session struct {
+	receiver   *Receiver
+	recipients []recipient
+}
+
+func (s *session) Mail(from string, opts *smtp.MailOptions) error {
+	s.recipients = nil
+	return nil
+}
+
+// Rcpt rejects addresses that are not signed, so nothing is accepted that can't be dispatched
+func (s *session) Rcpt(to string, opts *smtp.RcptOptions) error {
+	recipient, err := s.receiver.verify(to)
+	if err != nil {
+		return &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 1, 1}, Message: "No such recipient"}
+	}
+	s.recipients = append(s.recipients, recipient)
+	return nil
+}
+
+func (s *session) Data(r io.Reader) error {
+	message, err := mail.ParseMessage(r)
+	if err != nil {
+		if errors.Is(err, smtp.ErrDataTooLarge) {
+			return err
+		}
+		return &smtp.SMTPError{Code: 554, EnhancedCode: smtp.EnhancedCode{5, 6, 0}, Message: "Invalid message"}
+	}
+	if err := s.receiver.dispatch(context.Background(), s.recipients, message); err != nil {
+		log.Printf("Error processing inbound mail: %v", err)
+		return &smtp.SMTPError{Code: 451, EnhancedCode: smtp.EnhancedCode{4, 3, 0}, Message: "Error processing the mail, try again later"}
+	}
+	return nil
+}
+
+func (s *session) Reset()        { s.recipients = nil }
+func (s *session) Logout() error { return nil }
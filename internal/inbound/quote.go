@@ -0,0 +1,54 @@
+package inbound
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// "On Mon, Aug 5, 2024 at 10:00 AM Alice <alice@example.org> wrote:", Gmail wraps it over two lines.
+	// German clients put the sender after the verb: "Am 05.08.2024 um 10:00 schrieb Alice <alice@example.org>:"
+	attributionStart = regexp.MustCompile(`^(On|Am|Le|El|Il|Op) .+`)
+	attributionEnd   = regexp.MustCompile(`((wrote|a écrit|escribió|ha scritto|schreef)\s*|schrieb .*):$`)
+	// Outlook puts the headers of the quoted mail below a separator
+	quoteSeparator = regexp.MustCompile(`^(-{2,}\s*(Original Message|Ursprüngliche Nachricht)\s*-{2,}|_{10,})$`)
+	quoteHeader    = regexp.MustCompile(`^\*?(From|Von|De):\*?\s`)
+	headerFollows  = regexp.MustCompile(`^\*?(Sent|Date|To|Gesendet|Datum|An|Envoyé|Enviado):\*?\s`)
+	mobileFooter   = regexp.MustCompile(`^Sent from my \w+`)
+)
+
+// StripQuotedReply returns the new text of a reply without the quoted mail, the attribution line above it
+// and the signature below the text
+func StripQuotedReply(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var kept []string
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimSpace(line)
+		if isQuoteStart(lines, i) || line == "--" || line == "-- " || mobileFooter.MatchString(trimmed) {
+			break
+		}
+		// Inline quotes between the answers of the reply
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+func isQuoteStart(lines []string, i int) bool {
+	line := strings.TrimSpace(lines[i])
+	if quoteSeparator.MatchString(line) {
+		return true
+	}
+	if attributionStart.MatchString(line) {
+		if attributionEnd.MatchString(line) {
+			return true
+		}
+		if i+1 < len(lines) && attributionEnd.MatchString(strings.TrimSpace(lines[i+1])) {
+			return true
+		}
+	}
+	return quoteHeader.MatchString(line) && i+1 < len(lines) && headerFollows.MatchString(strings.TrimSpace(lines[i+1]))
+}
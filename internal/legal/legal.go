@@ -0,0 +1,86 @@
+package legal
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/store"
+	"atomic-go-template/web/embed"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yuin/goldmark"
+)
+
+// Documents are all documents a user has to accept
+var Documents = []model.LegalDocument{model.LegalDocumentTerms, model.LegalDocumentPrivacy}
+
+// ErrDocumentNotFound is returned when there is no file for the document version
+var ErrDocumentNotFound = errors.New("legal document not found")
+
+// CurrentVersion returns the currently published version of the document
+func CurrentVersion(c config.Legal, document model.LegalDocument) string {
+	switch document {
+	case model.LegalDocumentTerms:
+		return c.TermsVersion
+	case model.LegalDocumentPrivacy:
+		return c.PrivacyVersion
+	}
+	return ""
+}
+
+// Render returns the document version rendered from Markdown to HTML
+func Render(document model.LegalDocument, version string) (string, error) {
+	source, err := fs.ReadFile(embed.Legal, fmt.Sprintf("legal/%s/%s.md", document, version))
+	if err != nil {
+		return "", ErrDocumentNotFound
+	}
+	var buf bytes.Buffer
+	if err := goldmark.Convert(source, &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// PendingDocuments returns the documents the user has not accepted in their current version
+func PendingDocuments(ctx context.Context, users store.UserStore, userID uuid.UUID, c config.Legal) ([]model.LegalDocument, error) {
+	accepted, err := users.LegalAcceptances(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	var pending []model.LegalDocument
+	for _, document := range Documents {
+		current := func(acceptance model.LegalAcceptance) bool {
+			return acceptance.Document == document && acceptance.Version == CurrentVersion(c, document)
+		}
+		if !slices.ContainsFunc(accepted, current) {
+			pending = append(pending, document)
+		}
+	}
+	return pending, nil
+}
+
+// AcceptCurrent records that the user accepted the current version of every document they have not accepted yet
+func AcceptCurrent(ctx context.Context, users store.UserStore, userID uuid.UUID, ipAddress string, c config.Legal) error {
+	pending, err := PendingDocuments(ctx, users, userID, c)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var acceptances []model.LegalAcceptance
+	for _, document := range pending {
+		acceptances = append(acceptances, model.LegalAcceptance{
+			UserID:     userID,
+			Document:   document,
+			Version:    CurrentVersion(c, document),
+			AcceptedAt: now,
+			IPAddress:  ipAddress,
+		})
+	}
+	return users.AcceptLegal(ctx, acceptances)
+}
@@ -2,6 +2,7 @@ package mail
 
 import (
 	"fmt"
+	"strings"
 )
 
 type ConsoleService struct {
@@ -12,10 +13,38 @@ func NewConsoleService() (Service, error) {
 }
 
 func (r *ConsoleService) Send(to, subject, body string) error {
+	return r.SendMessage(Message{To: []string{to}, Subject: subject, HTML: body})
+}
+
+func (r *ConsoleService) SendMessage(message Message) error {
+	if err := message.Validate(); err != nil {
+		return err
+	}
 
-	fmt.Println("Email sent to:", to)
-	fmt.Println("Email subject:", subject)
-	fmt.Println("Email body:", body)
+	fmt.Println("Email sent to:", strings.Join(message.To, ", "))
+	if len(message.Cc) > 0 {
+		fmt.Println("Email cc:", strings.Join(message.Cc, ", "))
+	}
+	if len(message.Bcc) > 0 {
+		fmt.Println("Email bcc:", strings.Join(message.Bcc, ", "))
+	}
+	if message.ReplyTo != "" {
+		fmt.Println("Email reply-to:", message.ReplyTo)
+	}
+	fmt.Println("Email subject:", message.Subject)
+	for _, name := range sortedKeys(message.Headers) {
+		fmt.Printf("Email header: %s: %s\n", name, message.Headers[name])
+	}
+	for _, name := range sortedKeys(message.Tags) {
+		fmt.Printf("Email tag: %s=%s\n", name, message.Tags[name])
+	}
+	for _, attachment := range message.Attachments {
+		fmt.Printf("Email attachment: %s (%s, %d bytes)\n", attachment.Filename, attachment.MediaType(), len(attachment.Content))
+	}
+	if message.Text != "" {
+		fmt.Println("Email text:", message.Text)
+	}
+	fmt.Println("Email body:", message.HTML)
 
 	return nil
 }
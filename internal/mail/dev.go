@@ -0,0 +1,201 @@
+package mail
+
+import (
+	"atomic-go-template/internal/config"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DevOptions struct {
+	From mail.Address
+	// Also write every mail as .eml file into the directory, mails already in it are loaded on start. Empty keeps them only in memory
+	Directory string
+	// The oldest mails are dropped from memory beyond this, files are kept
+	MaxMessages int
+}
+
+// DevOptionsFromConfig sends from noreply@ the host of APP_URL
+func DevOptionsFromConfig(c config.DevMail) DevOptions {
+	host := "localhost"
+	if appURL, err := url.Parse(os.Getenv("APP_URL")); err == nil && appURL.Hostname() != "" {
+		host = appURL.Hostname()
+	}
+	return DevOptions{
+		From:        mail.Address{Name: os.Getenv("APP_NAME"), Address: "noreply@" + host},
+		Directory:   c.Directory,
+		MaxMessages: c.MaxMessages,
+	}
+}
+
+// DevMessage is a mail caught by the DevService
+type DevMessage struct {
+	ParsedMessage
+	ID       string
+	Received time.Time
+	// Bcc is not part of the raw message, so it is empty for mails loaded from files
+	Bcc []string
+	Raw []byte
+}
+
+// DevService keeps the mails for the development inbox at /dev/mail instead of sending them
+type DevService struct {
+	options  DevOptions
+	mu       sync.RWMutex
+	messages []DevMessage
+}
+
+// ErrDevMessageNotFound is returned for unknown message IDs
+var ErrDevMessageNotFound = errors.New("dev mail not found")
+
+func NewDevService(options DevOptions) (*DevService, error) {
+	if options.MaxMessages <= 0 {
+		options.MaxMessages = 100
+	}
+	d := &DevService{options: options}
+	if options.Directory == "" {
+		return d, nil
+	}
+	if err := os.MkdirAll(options.Directory, 0o755); err != nil {
+		return nil, fmt.Errorf("dev mail: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(options.Directory, "*.eml"))
+	if err != nil {
+		return nil, fmt.Errorf("dev mail: %w", err)
+	}
+	// The file names start with the time, so they sort oldest first
+	sort.Strings(files)
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("dev mail: %w", err)
+		}
+		message, err := newDevMessage(strings.TrimSuffix(filepath.Base(file), ".eml"), raw)
+		if err != nil {
+			// Files could be copied in by hand, a broken one should not stop the app
+			fmt.Printf("dev mail: skipping %s: %v\n", file, err)
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			message.Received = info.ModTime()
+		}
+		d.add(message)
+	}
+	return d, nil
+}
+
+func (d *DevService) Send(to, subject, body string) error {
+	return d.SendMessage(Message{To: []string{to}, Subject: subject, HTML: body})
+}
+
+func (d *DevService) SendMessage(msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	if msg.Text == "" && msg.HTML != "" {
+		if text, err := HTMLToText(msg.HTML); err == nil {
+			msg.Text = text
+		}
+	}
+	raw, err := buildMIME(d.options.From, msg)
+	if err != nil {
+		return err
+	}
+	message, err := newDevMessage(newDevMessageID(), raw)
+	if err != nil {
+		return err
+	}
+	message.Bcc = msg.Bcc
+
+	if d.options.Directory != "" {
+		path := filepath.Join(d.options.Directory, message.ID+".eml")
+		if err := os.WriteFile(path, raw, 0o644); err != nil {
+			return fmt.Errorf("dev mail: %w", err)
+		}
+	}
+	d.mu.Lock()
+	d.add(message)
+	d.mu.Unlock()
+
+	fmt.Printf("Email to %s caught: %s/dev/mail?id=%s\n", strings.Join(msg.Recipients(), ", "), os.Getenv("APP_URL"), message.ID)
+	return nil
+}
+
+// Messages returns the mails, newest first
+func (d *DevService) Messages() []DevMessage {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	messages := make([]DevMessage, len(d.messages))
+	for i, message := range d.messages {
+		messages[len(d.messages)-1-i] = message
+	}
+	return messages
+}
+
+func (d *DevService) Message(id string) (DevMessage, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, message := range d.messages {
+		if message.ID == id {
+			return message, nil
+		}
+	}
+	return DevMessage{}, ErrDevMessageNotFound
+}
+
+// Clear removes all mails, including the files
+func (d *DevService) Clear() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.messages = nil
+	if d.options.Directory == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(d.options.Directory, "*.eml"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			return fmt.Errorf("dev mail: %w", err)
+		}
+	}
+	return nil
+}
+
+// add has to be called with the lock held
+func (d *DevService) add(message DevMessage) {
+	d.messages = append(d.messages, message)
+	if len(d.messages) > d.options.MaxMessages {
+		d.messages = d.messages[len(d.messages)-d.options.MaxMessages:]
+	}
+}
+
+func newDevMessage(id string, raw []byte) (DevMessage, error) {
+	parsed, err := ParseMessage(bytes.NewReader(raw))
+	if err != nil {
+		return DevMessage{}, err
+	}
+	received := parsed.Date
+	if received.IsZero() {
+		received = time.Now()
+	}
+	return DevMessage{ParsedMessage: parsed, ID: id, Received: received, Raw: raw}, nil
+}
+
+// newDevMessageID starts with the time, so IDs and file names sort by it
+func newDevMessageID() string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(suffix)
+}
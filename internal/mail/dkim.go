@@ -0,0 +1,127 @@
+package mail
+
+import (
+	"atomic-go-template/internal/config"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// DKIMOptions configure the signature
+type DKIMOptions struct {
+	// The public key is published at <Selector>._domainkey.<Domain>
+	Domain   string
+	Selector string
+	// Headers covered by the signature, has to include From
+	Headers []string
+	// *rsa.PrivateKey or ed25519.PrivateKey
+	Key crypto.Signer
+}
+
+// DKIMOptionsFromConfig reads the key from DKIM_PRIVATE_KEY or DKIM_PRIVATE_KEY_FILE.
+// Without a configured domain the domain of the sender is used
+func DKIMOptionsFromConfig(c config.DKIM, from string) (DKIMOptions, error) {
+	data := []byte(strings.ReplaceAll(os.Getenv("DKIM_PRIVATE_KEY"), `\n`, "\n"))
+	if file := os.Getenv("DKIM_PRIVATE_KEY_FILE"); len(data) == 0 && file != "" {
+		var err error
+		data, err = os.ReadFile(file)
+		if err != nil {
+			return DKIMOptions{}, fmt.Errorf("dkim: reading key: %w", err)
+		}
+	}
+	key, err := ParseDKIMKey(data)
+	if err != nil {
+		return DKIMOptions{}, err
+	}
+
+	domain := c.Domain
+	if domain == "" {
+		if at := strings.LastIndex(from, "@"); at >= 0 {
+			domain = from[at+1:]
+		}
+	}
+	return DKIMOptions{
+		Domain:   domain,
+		Selector: c.Selector,
+		Headers:  c.Headers,
+		Key:      key,
+	}, nil
+}
+
+// ParseDKIMKey parses a PEM encoded RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8) private key
+func ParseDKIMKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("dkim: no PEM data in key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("dkim: parsing key: %w", err)
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, errors.New("dkim: key has to be an RSA or Ed25519 key")
+}
+
+// DKIMSigner adds a DKIM-Signature header to raw MIME messages
+type DKIMSigner struct {
+	options dkim.SignOptions
+}
+
+func NewDKIMSigner(options DKIMOptions) (*DKIMSigner, error) {
+	if options.Domain == "" || options.Selector == "" {
+		return nil, errors.New("dkim: domain and selector are required")
+	}
+	if options.Key == nil {
+		return nil, errors.New("dkim: key is required")
+	}
+	headers := options.Headers
+	if len(headers) > 0 && !containsFold(headers, "From") {
+		// The From header must always be signed, see RFC 6376 section 5.4
+		headers = append([]string{"From"}, headers...)
+	}
+	return &DKIMSigner{options: dkim.SignOptions{
+		Domain:                 options.Domain,
+		Selector:               options.Selector,
+		Signer:                 options.Key,
+		Hash:                   crypto.SHA256,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+		HeaderKeys:             headers,
+	}}, nil
+}
+
+// Sign returns the message with the DKIM-Signature header prepended.
+// Listed headers missing in the message are signed as empty, so they cannot be added on the way
+func (s *DKIMSigner) Sign(message []byte) ([]byte, error) {
+	var signed bytes.Buffer
+	if err := dkim.Sign(&signed, bytes.NewReader(message), &s.options); err != nil {
+		return nil, fmt.Errorf("dkim: %w", err)
+	}
+	return signed.Bytes(), nil
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+package mail
+
+import (
+	"atomic-go-template/internal/config"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FailoverOptions struct {
+	// Consecutive failures after which a provider is skipped
+	FailureThreshold int
+	// How long a failing provider is skipped before a single trial mail is sent with it again
+	Cooldown time.Duration
+}
+
+func FailoverOptionsFromConfig(c config.Failover) FailoverOptions {
+	return FailoverOptions{
+		FailureThreshold: c.FailureThreshold,
+		Cooldown:         c.Cooldown,
+	}
+}
+
+// FailoverProvider is one provider of the chain
+type FailoverProvider struct {
+	Name    string
+	Service Service
+}
+
+// Deliverer is implemented by services that choose between providers, it reports the provider that delivered the message
+type Deliverer interface {
+	Deliver(message Message) (provider string, err error)
+}
+
+// FailoverService sends with the first provider that works. Every provider has a circuit breaker,
+// so a provider that is down is skipped instead of slowing down every mail with its timeout
+type FailoverService struct {
+	options   FailoverOptions
+	mu        sync.Mutex
+	providers []*circuit
+}
+
+type circuit struct {
+	FailoverProvider
+	failures        int
+	openUntil       time.Time
+	trialRunning    bool
+	delivered       int64
+	lastDeliveredAt time.Time
+	lastError       string
+}
+
+// ProviderStatus is the state of a provider, reported to admins by /admin/health
+type ProviderStatus struct {
+	Name string `json:"name"`
+	// "closed" if the provider is used, "open" if it is skipped and "half-open" while a trial mail is sent
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Delivered           int64      `json:"delivered"`
+	LastDeliveredAt     *time.Time `json:"last_delivered_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	OpenUntil           *time.Time `json:"open_until,omitempty"`
+}
+
+// ErrAllProvidersFailed is returned if no provider delivered the message
+var ErrAllProvidersFailed = errors.New("all mail providers failed")
+
+func NewFailoverService(providers []FailoverProvider, options FailoverOptions) (*FailoverService, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("failover: no mail providers")
+	}
+	if options.FailureThreshold <= 0 {
+		options.FailureThreshold = 3
+	}
+	if options.Cooldown <= 0 {
+		options.Cooldown = time.Minute
+	}
+	f := &FailoverService{options: options}
+	for _, provider := range providers {
+		f.providers = append(f.providers, &circuit{FailoverProvider: provider})
+	}
+	return f, nil
+}
+
+func (f *FailoverService) Send(to, subject, body string) error {
+	return f.SendMessage(Message{To: []string{to}, Subject: subject, HTML: body})
+}
+
+func (f *FailoverService) SendMessage(message Message) error {
+	_, err := f.Deliver(message)
+	return err
+}
+
+// Deliver tries the providers in order and returns the name of the one that delivered the message.
+// Permanent errors like a rejected recipient are returned right away, the next provider would fail the same way
+func (f *FailoverService) Deliver(message Message) (string, error) {
+	if err := message.Validate(); err != nil {
+		return "", err
+	}
+	recipients := strings.Join(message.Recipients(), ", ")
+	var errs []error
+	for _, provider := range f.providers {
+		if !f.allow(provider) {
+			errs = append(errs, fmt.Errorf("%s: skipped after %d failures", provider.Name, f.failures(provider)))
+			continue
+		}
+		err := provider.Service.SendMessage(message)
+		if err == nil || IsPermanentError(err) {
+			// A rejection shows the provider itself is working
+			f.succeeded(provider, err == nil)
+			if err != nil {
+				return "", err
+			}
+			if len(errs) > 0 {
+				log.Printf("Mail to %s delivered by %s after %v", recipients, provider.Name, errors.Join(errs...))
+			} else {
+				log.Printf("Mail to %s delivered by %s", recipients, provider.Name)
+			}
+			return provider.Name, nil
+		}
+		f.failed(provider, err)
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name, err))
+	}
+	return "", fmt.Errorf("%w: %w", ErrAllProvidersFailed, errors.Join(errs...))
+}
+
+// Status returns the state of every provider in order
+func (f *FailoverService) Status() []ProviderStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	statuses := make([]ProviderStatus, 0, len(f.providers))
+	for _, provider := range f.providers {
+		status := ProviderStatus{
+			Name:                provider.Name,
+			State:               "closed",
+			ConsecutiveFailures: provider.failures,
+			Delivered:           provider.delivered,
+			LastError:           provider.lastError,
+		}
+		if !provider.lastDeliveredAt.IsZero() {
+			lastDeliveredAt := provider.lastDeliveredAt
+			status.LastDeliveredAt = &lastDeliveredAt
+		}
+		if !provider.openUntil.IsZero() {
+			status.State = "open"
+			if provider.trialRunning || !now.Before(provider.openUntil) {
+				status.State = "half-open"
+			}
+			openUntil := provider.openUntil
+			status.OpenUntil = &openUntil
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// allow returns false while the circuit is open. After the cooldown one trial mail is let through
+func (f *FailoverService) allow(provider *circuit) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if provider.openUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(provider.openUntil) || provider.trialRunning {
+		return false
+	}
+	provider.trialRunning = true
+	return true
+}
+
+func (f *FailoverService) succeeded(provider *circuit, delivered bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !provider.openUntil.IsZero() {
+		log.Printf("Mail provider %s recovered", provider.Name)
+	}
+	provider.failures = 0
+	provider.openUntil = time.Time{}
+	provider.trialRunning = false
+	if delivered {
+		provider.delivered++
+		provider.lastDeliveredAt = time.Now()
+	}
+}
+
+func (f *FailoverService) failed(provider *circuit, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	provider.failures++
+	provider.trialRunning = false
+	provider.lastError = err.Error()
+	if provider.failures >= f.options.FailureThreshold {
+		provider.openUntil = time.Now().Add(f.options.Cooldown)
+		log.Printf("Mail provider %s failed %d times in a row, skipping it for %s: %v", provider.Name, provider.failures, f.options.Cooldown, err)
+	}
+}
+
+func (f *FailoverService) failures(provider *circuit) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return provider.failures
+}
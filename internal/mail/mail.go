@@ -3,26 +3,79 @@ package mail
 import (
 	"atomic-go-template/internal/config"
 	"errors"
+	"fmt"
 	"os"
 )
 
 // TODO: Print to Console Provider
 
 type Service interface {
+	// Send is a shortcut for a HTML mail to a single recipient
 	Send(to, subject, body string) error
+	// SendMessage sends the message, see Message for what can be set
+	SendMessage(message Message) error
 }
 
 func NewMailProvider(c config.Mail) (Service, error) {
-	switch c.MailProvider {
+	if len(c.Providers) <= 1 {
+		return newProvider(c.MailProvider, c)
+	}
+	// Fail over to the next provider in the chain
+	var providers []FailoverProvider
+	for _, name := range c.Providers {
+		service, err := newProvider(name, c)
+		if err != nil {
+			return nil, fmt.Errorf("mail provider %s: %w", name, err)
+		}
+		providers = append(providers, FailoverProvider{Name: string(name), Service: service})
+	}
+	failover, err := NewFailoverService(providers, FailoverOptionsFromConfig(c.Failover))
+	if err != nil {
+		return nil, err
+	}
+	return failover, nil
+}
+
+func newProvider(provider config.MailProvider, c config.Mail) (Service, error) {
+	switch provider {
 	case config.MailProviderResend:
 		return NewResendService(os.Getenv("RESEND_API_KEY"))
 	case config.MailProviderConsole:
 		return NewConsoleService()
+	case config.MailProviderSMTP:
+		options, err := SMTPOptionsFromConfig(c.SMTP)
+		if err != nil {
+			return nil, err
+		}
+		if c.DKIM.EnableDKIM {
+			if options.DKIM, err = newDKIMSignerFromConfig(c.DKIM, options.From.Address); err != nil {
+				return nil, err
+			}
+		}
+		smtpService, err := NewSMTPService(options)
+		if err != nil {
+			return nil, err
+		}
+		return smtpService, nil
+	case config.MailProviderDev:
+		devService, err := NewDevService(DevOptionsFromConfig(c.Dev))
+		if err != nil {
+			return nil, err
+		}
+		return devService, nil
 	// Add more cases for future providers here
 	default:
 		return nil, ErrUnsupportedMailProvider
 	}
 }
 
+func newDKIMSignerFromConfig(c config.DKIM, from string) (*DKIMSigner, error) {
+	options, err := DKIMOptionsFromConfig(c, from)
+	if err != nil {
+		return nil, err
+	}
+	return NewDKIMSigner(options)
+}
+
 // ErrUnsupportedMailProvider is returned when an unsupported mail provider is specified
 var ErrUnsupportedMailProvider = errors.New("unsupported mail provider")
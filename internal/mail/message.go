@@ -0,0 +1,130 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"path/filepath"
+	"strings"
+)
+
+// Message is a mail with everything the providers support
+type Message struct {
+	// Recipients, either "alice@example.org" or "Alice <alice@example.org>"
+	To      []string
+	Cc      []string
+	Bcc     []string
+	ReplyTo string
+	Subject string
+	HTML    string
+	// Plain text alternative for clients that don't show HTML. Optional, see HTMLToText
+	Text string
+	// Additional headers, f.e. List-Unsubscribe. The headers set by the providers can't be overwritten
+	Headers map[string]string
+	// Metadata the provider shows in its dashboard and webhooks, f.e. {"category": "password_reset"}.
+	// Names and values may only contain ASCII letters, numbers, underscores and dashes
+	Tags        map[string]string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to the message
+type Attachment struct {
+	Filename string
+	// Derived from the filename if empty
+	ContentType string
+	Content     []byte
+	// Makes the attachment an inline image, referenced in the HTML as <img src="cid:ContentID">
+	ContentID string
+}
+
+// ErrInvalidMessage is returned for messages that can't be sent
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Headers the providers set themselves
+var reservedHeaders = []string{
+	"From", "To", "Cc", "Bcc", "Reply-To", "Subject", "Date", "Message-ID", "MIME-Version",
+	"Content-Type", "Content-Transfer-Encoding", "DKIM-Signature",
+}
+
+// Validate checks the recipients, headers and tags
+func (m Message) Validate() error {
+	if len(m.To)+len(m.Cc)+len(m.Bcc) == 0 {
+		return fmt.Errorf("%w: no recipients", ErrInvalidMessage)
+	}
+	for _, address := range m.Recipients() {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("%w: recipient %q: %v", ErrInvalidMessage, address, err)
+		}
+	}
+	if m.ReplyTo != "" {
+		if _, err := mail.ParseAddress(m.ReplyTo); err != nil {
+			return fmt.Errorf("%w: reply-to %q: %v", ErrInvalidMessage, m.ReplyTo, err)
+		}
+	}
+	if m.HTML == "" && m.Text == "" {
+		return fmt.Errorf("%w: no body", ErrInvalidMessage)
+	}
+	for name, value := range m.Headers {
+		if !validHeaderName(name) || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("%w: header %q", ErrInvalidMessage, name)
+		}
+		if containsFold(reservedHeaders, name) {
+			return fmt.Errorf("%w: header %q is set by the provider", ErrInvalidMessage, name)
+		}
+	}
+	for name, value := range m.Tags {
+		if !validTag(name) || !validTag(value) {
+			return fmt.Errorf("%w: tag %q", ErrInvalidMessage, name)
+		}
+	}
+	for _, attachment := range m.Attachments {
+		if attachment.Filename == "" || strings.ContainsAny(attachment.ContentID, "<>\r\n") {
+			return fmt.Errorf("%w: attachment %q", ErrInvalidMessage, attachment.Filename)
+		}
+	}
+	return nil
+}
+
+// Recipients returns all addresses the message is delivered to
+func (m Message) Recipients() []string {
+	recipients := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	recipients = append(recipients, m.To...)
+	recipients = append(recipients, m.Cc...)
+	return append(recipients, m.Bcc...)
+}
+
+// MediaType returns the content type of the attachment
+func (a Attachment) MediaType() string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(a.Filename)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 127 || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+func validTag(tag string) bool {
+	if tag == "" {
+		return false
+	}
+	for _, c := range tag {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+)
+
+// buildMIME returns the raw message for providers that deliver it themselves, like SMTP.
+// The body is nested as mixed (attachments) > related (inline images) > alternative (text and HTML) as needed
+func buildMIME(from mail.Address, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", from.String())
+	if len(msg.To) > 0 {
+		to, err := formatAddressList(msg.To)
+		if err != nil {
+			return nil, err
+		}
+		writeHeader(&buf, "To", to)
+	}
+	if len(msg.Cc) > 0 {
+		cc, err := formatAddressList(msg.Cc)
+		if err != nil {
+			return nil, err
+		}
+		writeHeader(&buf, "Cc", cc)
+	}
+	if msg.ReplyTo != "" {
+		replyTo, err := formatAddressList([]string{msg.ReplyTo})
+		if err != nil {
+			return nil, err
+		}
+		writeHeader(&buf, "Reply-To", replyTo)
+	}
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", messageID(from.Address))
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	for _, name := range sortedKeys(msg.Headers) {
+		writeHeader(&buf, name, mime.QEncoding.Encode("utf-8", msg.Headers[name]))
+	}
+	if len(msg.Tags) > 0 {
+		tags := []string{}
+		for _, name := range sortedKeys(msg.Tags) {
+			tags = append(tags, name+"="+msg.Tags[name])
+		}
+		writeHeader(&buf, "X-Tags", strings.Join(tags, "; "))
+	}
+
+	if err := bodyEntity(msg).write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// entity is a MIME part, either a leaf with content or a multipart container
+type entity struct {
+	// f.e. "alternative", empty for leaves
+	multipart string
+	parts     []*entity
+
+	contentType string
+	headers     [][2]string
+	// "quoted-printable" for text, "base64" for binary content
+	encoding string
+	content  []byte
+}
+
+func bodyEntity(msg Message) *entity {
+	var body *entity
+	html := &entity{contentType: "text/html; charset=UTF-8", encoding: "quoted-printable", content: []byte(msg.HTML)}
+	text := &entity{contentType: "text/plain; charset=UTF-8", encoding: "quoted-printable", content: []byte(msg.Text)}
+	switch {
+	case msg.HTML == "":
+		body = text
+	case msg.Text == "":
+		body = html
+	default:
+		// The last part is the preferred one, so the text comes first
+		body = &entity{multipart: "alternative", parts: []*entity{text, html}}
+	}
+
+	inline := []*entity{}
+	attachments := []*entity{}
+	for _, attachment := range msg.Attachments {
+		part := &entity{contentType: attachment.MediaType(), encoding: "base64", content: attachment.Content}
+		if attachment.ContentID != "" {
+			part.headers = [][2]string{
+				{"Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": attachment.Filename})},
+				{"Content-ID", "<" + attachment.ContentID + ">"},
+			}
+			inline = append(inline, part)
+		} else {
+			part.headers = [][2]string{
+				{"Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+			}
+			attachments = append(attachments, part)
+		}
+	}
+
+	if len(inline) > 0 {
+		body = &entity{multipart: "related", parts: append([]*entity{body}, inline...)}
+	}
+	if len(attachments) > 0 {
+		body = &entity{multipart: "mixed", parts: append([]*entity{body}, attachments...)}
+	}
+	return body
+}
+
+// write writes the content headers, an empty line and the body
+func (e *entity) write(w io.Writer) error {
+	if e.multipart != "" {
+		boundary := multipart.NewWriter(io.Discard).Boundary()
+		writeHeader(w, "Content-Type", fmt.Sprintf("multipart/%s; boundary=%q", e.multipart, boundary))
+		io.WriteString(w, "\r\n")
+		for _, part := range e.parts {
+			fmt.Fprintf(w, "--%s\r\n", boundary)
+			if err := part.write(w); err != nil {
+				return err
+			}
+			io.WriteString(w, "\r\n")
+		}
+		fmt.Fprintf(w, "--%s--\r\n", boundary)
+		return nil
+	}
+
+	writeHeader(w, "Content-Type", e.contentType)
+	for _, header := range e.headers {
+		writeHeader(w, header[0], header[1])
+	}
+	writeHeader(w, "Content-Transfer-Encoding", e.encoding)
+	io.WriteString(w, "\r\n")
+
+	if e.encoding == "base64" {
+		encoded := base64.StdEncoding.EncodeToString(e.content)
+		for len(encoded) > 76 {
+			io.WriteString(w, encoded[:76]+"\r\n")
+			encoded = encoded[76:]
+		}
+		_, err := io.WriteString(w, encoded)
+		return err
+	}
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write(e.content); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeHeader(w io.Writer, name, value string) {
+	fmt.Fprintf(w, "%s: %s\r\n", name, value)
+}
+
+// formatAddressList parses the addresses and formats them with encoded names
+func formatAddressList(addresses []string) (string, error) {
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		parsed, err := mail.ParseAddress(address)
+		if err != nil {
+			return "", fmt.Errorf("%w: %q: %v", ErrInvalidMessage, address, err)
+		}
+		formatted = append(formatted, parsed.String())
+	}
+	return strings.Join(formatted, ", "), nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,380 @@
+package mail
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type OutboxOptions struct {
+	// How often the worker looks for due messages. New messages wake it up right away
+	PollInterval time.Duration
+	// Attempts before a message is dead-lettered
+	MaxAttempts int
+	// Delay before the first retry, doubled after every failed attempt up to MaxRetryDelay
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// Messages per recipient within RecipientWindow. Zero or negative disables the throttle
+	RecipientLimit  int
+	RecipientWindow time.Duration
+	// Messages claimed per pass
+	BatchSize int
+	// How long a claimed message is locked. Another worker takes it over afterwards
+	LockTimeout time.Duration
+}
+
+func OutboxOptionsFromConfig(c config.Outbox) OutboxOptions {
+	return OutboxOptions{
+		PollInterval:    c.PollInterval,
+		MaxAttempts:     c.MaxAttempts,
+		RetryDelay:      c.RetryDelay,
+		MaxRetryDelay:   c.MaxRetryDelay,
+		RecipientLimit:  c.RecipientLimit,
+		RecipientWindow: c.RecipientWindow,
+		BatchSize:       20,
+		LockTimeout:     5 * time.Minute,
+	}
+}
+
+// Outbox stores mails in the database and delivers them with the provider in the background.
+// It implements Service, so handlers enqueue by sending as usual and never wait for the provider.
+type Outbox struct {
+	db       *gorm.DB
+	provider Service
+	options  OutboxOptions
+	wake     chan struct{}
+}
+
+// ErrOutboxMessageNotFound is returned by Retry for unknown or already sent messages
+var ErrOutboxMessageNotFound = errors.New("outbox message not found")
+
+func NewOutbox(db *gorm.DB, provider Service, options OutboxOptions) *Outbox {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 20
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 1
+	}
+	if options.LockTimeout <= 0 {
+		options.LockTimeout = 5 * time.Minute
+	}
+	return &Outbox{
+		db:       db,
+		provider: provider,
+		options:  options,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (o *Outbox) Send(to, subject, body string) error {
+	return o.SendMessage(Message{To: []string{to}, Subject: subject, HTML: body})
+}
+
+// SendMessage queues the message. Invalid messages are rejected right away instead of dead-lettered later
+func (o *Outbox) SendMessage(message Message) error {
+	if err := message.Validate(); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	entry := model.OutboxMessage{
+		Status:        model.OutboxStatusPending,
+		Recipient:     recipientKey(message.Recipients()[0]),
+		Subject:       message.Subject,
+		Payload:       string(payload),
+		NextAttemptAt: time.Now(),
+	}
+	// Broadcast mails are tagged by emails.Mailer.RenderBroadcast
+	if id, err := uuid.Parse(message.Tags["broadcast"]); err == nil {
+		entry.BroadcastID = &id
+	}
+	if err := o.db.Create(&entry).Error; err != nil {
+		return fmt.Errorf("outbox: queueing message: %w", err)
+	}
+	o.notify()
+	return nil
+}
+
+// Run delivers due messages until the context is canceled
+func (o *Outbox) Run(ctx context.Context) {
+	interval := o.options.PollInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := o.ProcessDue(ctx); err != nil {
+			log.Printf("Error delivering outbox: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// ProcessDue makes one delivery attempt for every due message and returns how many were delivered
+func (o *Outbox) ProcessDue(ctx context.Context) (int, error) {
+	delivered := 0
+	for {
+		now := time.Now()
+		var due []model.OutboxMessage
+		err := o.due(o.db.WithContext(ctx), now).
+			Order("next_attempt_at").
+			Limit(o.options.BatchSize).
+			Find(&due).Error
+		if err != nil {
+			return delivered, err
+		}
+		claimed := 0
+		for _, entry := range due {
+			if ctx.Err() != nil {
+				return delivered, ctx.Err()
+			}
+			ok, err := o.claim(ctx, entry.ID, now)
+			if err != nil {
+				return delivered, err
+			}
+			if !ok {
+				// Another worker was faster
+				continue
+			}
+			claimed++
+			sent, err := o.deliver(ctx, entry)
+			if err != nil {
+				return delivered, err
+			}
+			if sent {
+				delivered++
+			}
+		}
+		// Throttled and failed messages are due later, so the loop ends once a batch is not full
+		if len(due) < o.options.BatchSize || claimed == 0 {
+			return delivered, nil
+		}
+	}
+}
+
+// Retry queues a dead or pending message for an immediate attempt with a fresh set of attempts
+func (o *Outbox) Retry(id uuid.UUID) error {
+	err := o.db.Transaction(func(tx *gorm.DB) error {
+		var entry model.OutboxMessage
+		if err := tx.Where("id = ?", id).Find(&entry).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&model.OutboxMessage{}).
+			Where("id = ? AND status IN ?", id, []model.OutboxStatus{model.OutboxStatusDead, model.OutboxStatusPending}).
+			Updates(map[string]interface{}{
+				"status":          model.OutboxStatusPending,
+				"attempts":        0,
+				"next_attempt_at": time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOutboxMessageNotFound
+		}
+		// The failure is counted again if the retry fails too
+		if entry.Status == model.OutboxStatusDead {
+			return countBroadcast(tx, entry, "failed", -1, nil)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	o.notify()
+	return nil
+}
+
+// due selects pending messages whose time has come and messages left behind by a crashed worker
+func (o *Outbox) due(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.Where(
+		"(status = ? AND next_attempt_at <= ?) OR (status = ? AND locked_until < ?)",
+		model.OutboxStatusPending, now, model.OutboxStatusSending, now,
+	)
+}
+
+// claim locks the message for this worker. The conditional update makes sure only one worker gets it
+func (o *Outbox) claim(ctx context.Context, id uuid.UUID, now time.Time) (bool, error) {
+	result := o.due(o.db.WithContext(ctx).Model(&model.OutboxMessage{}).Where("id = ?", id), now).
+		Updates(map[string]interface{}{
+			"status":       model.OutboxStatusSending,
+			"locked_until": now.Add(o.options.LockTimeout),
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (o *Outbox) deliver(ctx context.Context, entry model.OutboxMessage) (bool, error) {
+	db := o.db.WithContext(ctx).Model(&model.OutboxMessage{}).Where("id = ?", entry.ID)
+
+	if until, err := o.throttledUntil(ctx, entry.Recipient); err != nil {
+		return false, err
+	} else if !until.IsZero() {
+		// Throttling is not the message's fault, so it does not count as an attempt
+		return false, db.Updates(map[string]interface{}{
+			"status":          model.OutboxStatusPending,
+			"next_attempt_at": until,
+			"locked_until":    nil,
+		}).Error
+	}
+
+	var message Message
+	var provider string
+	sendErr := json.Unmarshal([]byte(entry.Payload), &message)
+	if sendErr == nil {
+		if deliverer, ok := o.provider.(Deliverer); ok {
+			provider, sendErr = deliverer.Deliver(message)
+		} else {
+			sendErr = o.provider.SendMessage(message)
+		}
+	}
+	attempts := entry.Attempts + 1
+	now := time.Now()
+
+	if sendErr == nil {
+		updates := map[string]interface{}{
+			"status":       model.OutboxStatusSent,
+			"attempts":     attempts,
+			"sent_at":      now,
+			"locked_until": nil,
+			"last_error":   nil,
+		}
+		if provider != "" {
+			updates["provider"] = provider
+		}
+		return true, o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&model.OutboxMessage{}).Where("id = ?", entry.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+			return countBroadcast(tx, entry, "sent", 1, nil)
+		})
+	}
+
+	lastError := sendErr.Error()
+	if IsPermanentError(sendErr) || attempts >= o.options.MaxAttempts {
+		log.Printf("Outbox message %s to %s dead-lettered after %d attempts: %v", entry.ID, entry.Recipient, attempts, sendErr)
+		return false, o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&model.OutboxMessage{}).Where("id = ?", entry.ID).Updates(map[string]interface{}{
+				"status":       model.OutboxStatusDead,
+				"attempts":     attempts,
+				"locked_until": nil,
+				"last_error":   lastError,
+			}).Error
+			if err != nil {
+				return err
+			}
+			return countBroadcast(tx, entry, "failed", 1, &lastError)
+		})
+	}
+	return false, db.Updates(map[string]interface{}{
+		"status":          model.OutboxStatusPending,
+		"attempts":        attempts,
+		"next_attempt_at": now.Add(o.backoff(attempts)),
+		"locked_until":    nil,
+		"last_error":      lastError,
+	}).Error
+}
+
+// throttledUntil returns when the recipient may get the next mail, or zero if it may get one now
+func (o *Outbox) throttledUntil(ctx context.Context, recipient string) (time.Time, error) {
+	if o.options.RecipientLimit <= 0 || o.options.RecipientWindow <= 0 {
+		return time.Time{}, nil
+	}
+	since := time.Now().Add(-o.options.RecipientWindow)
+	var sent []model.OutboxMessage
+	err := o.db.WithContext(ctx).
+		Select("sent_at").
+		Where("recipient = ? AND status = ? AND sent_at > ?", recipient, model.OutboxStatusSent, since).
+		Order("sent_at desc").
+		Limit(o.options.RecipientLimit).
+		Find(&sent).Error
+	if err != nil || len(sent) < o.options.RecipientLimit {
+		return time.Time{}, err
+	}
+	// The oldest of the last RecipientLimit mails has to leave the window first
+	return sent[len(sent)-1].SentAt.Add(o.options.RecipientWindow), nil
+}
+
+// backoff doubles the delay with every attempt and adds up to 10% jitter, so retries of many messages spread out
+func (o *Outbox) backoff(attempts int) time.Duration {
+	delay := o.options.RetryDelay
+	for i := 1; i < attempts && (o.options.MaxRetryDelay <= 0 || delay < o.options.MaxRetryDelay); i++ {
+		delay *= 2
+	}
+	if o.options.MaxRetryDelay > 0 && delay > o.options.MaxRetryDelay {
+		delay = o.options.MaxRetryDelay
+	}
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)/10 + 1))
+	}
+	return delay
+}
+
+// countBroadcast adds the result of a broadcast mail to the sent or failed count of its broadcast, see broadcast.Worker
+func countBroadcast(tx *gorm.DB, entry model.OutboxMessage, column string, delta int, lastError *string) error {
+	if entry.BroadcastID == nil {
+		return nil
+	}
+	updates := map[string]interface{}{column: gorm.Expr(column+" + ?", delta)}
+	if lastError != nil {
+		updates["last_error"] = *lastError
+	}
+	return tx.Model(&model.Broadcast{}).Where("id = ?", *entry.BroadcastID).Updates(updates).Error
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// IsPermanentError returns true if retrying the message can't succeed, like an invalid message,
+// a suppressed or a rejected recipient. Authentication errors are not permanent, they go away once the credentials are fixed
+func IsPermanentError(err error) bool {
+	if errors.Is(err, ErrInvalidMessage) || errors.Is(err, ErrSuppressed) {
+		return true
+	}
+	// SMTPService uses net/smtp, which returns the replies of the server as textproto errors
+	var replyErr *textproto.Error
+	if errors.As(err, &replyErr) {
+		return isPermanentSMTPCode(replyErr.Code)
+	}
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		return isPermanentSMTPCode(smtpErr.Code)
+	}
+	return false
+}
+
+// isPermanentSMTPCode returns true for 5xx replies except the 53x authentication failures
+func isPermanentSMTPCode(code int) bool {
+	return code/100 == 5 && code/10 != 53
+}
+
+func recipientKey(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		return strings.ToLower(parsed.Address)
+	}
+	return strings.ToLower(strings.TrimSpace(address))
+}
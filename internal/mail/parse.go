@@ -0,0 +1,172 @@
+package mail
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// ParsedMessage is a raw mail split into its headers, bodies and attachments
+type ParsedMessage struct {
+	Header    mail.Header
+	From      string
+	To        []string
+	Cc        []string
+	ReplyTo   string
+	Subject   string
+	Date      time.Time
+	MessageID string
+	// The first text/html and text/plain bodies that are not attachments
+	HTML        string
+	Text        string
+	Attachments []Attachment
+}
+
+var wordDecoder = &mime.WordDecoder{}
+
+// ParseMessage reads a raw RFC 5322 message. Nested multiparts are walked depth first
+func ParseMessage(r io.Reader) (ParsedMessage, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return ParsedMessage{}, fmt.Errorf("parsing message: %w", err)
+	}
+	parsed := ParsedMessage{
+		Header:    msg.Header,
+		From:      decodeAddressHeader(msg.Header, "From"),
+		ReplyTo:   decodeAddressHeader(msg.Header, "Reply-To"),
+		To:        decodeAddressList(msg.Header, "To"),
+		Cc:        decodeAddressList(msg.Header, "Cc"),
+		Subject:   decodeHeader(msg.Header.Get("Subject")),
+		MessageID: strings.Trim(msg.Header.Get("Message-ID"), "<> "),
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		parsed.Date = date
+	}
+	header := partHeader{
+		contentType:      msg.Header.Get("Content-Type"),
+		disposition:      msg.Header.Get("Content-Disposition"),
+		transferEncoding: msg.Header.Get("Content-Transfer-Encoding"),
+		contentID:        msg.Header.Get("Content-ID"),
+	}
+	if err := parsed.walk(header, msg.Body); err != nil {
+		return ParsedMessage{}, err
+	}
+	return parsed, nil
+}
+
+type partHeader struct {
+	contentType      string
+	disposition      string
+	transferEncoding string
+	contentID        string
+}
+
+func (p *ParsedMessage) walk(header partHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.contentType)
+	if err != nil {
+		// RFC 2045 default
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("parsing %s: %w", mediaType, err)
+			}
+			err = p.walk(partHeader{
+				contentType:      part.Header.Get("Content-Type"),
+				disposition:      part.Header.Get("Content-Disposition"),
+				transferEncoding: part.Header.Get("Content-Transfer-Encoding"),
+				contentID:        part.Header.Get("Content-ID"),
+			}, part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := io.ReadAll(decodeTransferEncoding(header.transferEncoding, body))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", mediaType, err)
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.disposition)
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	isAttachment := disposition == "attachment" || filename != "" || header.contentID != ""
+
+	switch {
+	case mediaType == "text/html" && !isAttachment && p.HTML == "":
+		p.HTML = string(content)
+	case mediaType == "text/plain" && !isAttachment && p.Text == "":
+		p.Text = string(content)
+	default:
+		p.Attachments = append(p.Attachments, Attachment{
+			Filename:    decodeHeader(filename),
+			ContentType: mediaType,
+			Content:     content,
+			ContentID:   strings.Trim(header.contentID, "<> "),
+		})
+	}
+	return nil
+}
+
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// The decoder skips the line breaks
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+func decodeAddressHeader(header mail.Header, name string) string {
+	if list := decodeAddressList(header, name); len(list) > 0 {
+		return list[0]
+	}
+	return ""
+}
+
+// decodeAddressList falls back to the raw header if it is not a valid address list
+func decodeAddressList(header mail.Header, name string) []string {
+	if header.Get(name) == "" {
+		return nil
+	}
+	addresses, err := header.AddressList(name)
+	if err != nil {
+		return []string{decodeHeader(header.Get(name))}
+	}
+	list := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if address.Name != "" {
+			list = append(list, address.Name+" <"+address.Address+">")
+		} else {
+			list = append(list, address.Address)
+		}
+	}
+	return list
+}
@@ -1,7 +1,10 @@
 package mail
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/resend/resend-go/v2"
@@ -16,19 +19,63 @@ func NewResendService(apiKey string) (Service, error) {
 	return &ResendService{client: client}, nil
 }
 
+// NewResendServiceWithClient uses the client, f.e. with another base URL or HTTP client
+func NewResendServiceWithClient(client *resend.Client) Service {
+	return &ResendService{client: client}
+}
+
+// sendEmailRequest adds inline attachments, which the SDK does not support yet
+type sendEmailRequest struct {
+	*resend.SendEmailRequest
+	Attachments []resendAttachment `json:"attachments,omitempty"`
+}
+
+type resendAttachment struct {
+	// Base64 encoded
+	Content     string `json:"content"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
 func (r *ResendService) Send(to, subject, body string) error {
+	return r.SendMessage(Message{To: []string{to}, Subject: subject, HTML: body})
+}
 
-	params := &resend.SendEmailRequest{
+func (r *ResendService) SendMessage(message Message) error {
+	if err := message.Validate(); err != nil {
+		return err
+	}
+
+	params := &sendEmailRequest{SendEmailRequest: &resend.SendEmailRequest{
 		From:    fmt.Sprintf("%s <%s>", os.Getenv("APP_NAME"), os.Getenv("RESEND_FROM_EMAIL")),
-		To:      []string{to},
-		Html:    body,
-		Subject: subject,
-		// Cc:      []string{"cc@example.com"},
-		// Bcc:     []string{"bcc@example.com"},
-		// ReplyTo: "replyto@example.com",
+		To:      message.To,
+		Cc:      message.Cc,
+		Bcc:     message.Bcc,
+		ReplyTo: message.ReplyTo,
+		Html:    message.HTML,
+		Text:    message.Text,
+		Subject: message.Subject,
+		Headers: message.Headers,
+	}}
+	for _, name := range sortedKeys(message.Tags) {
+		params.Tags = append(params.Tags, resend.Tag{Name: name, Value: message.Tags[name]})
+	}
+	for _, attachment := range message.Attachments {
+		params.Attachments = append(params.Attachments, resendAttachment{
+			Content:     base64.StdEncoding.EncodeToString(attachment.Content),
+			Filename:    attachment.Filename,
+			ContentType: attachment.MediaType(),
+			ContentID:   attachment.ContentID,
+		})
 	}
-	sent, err := r.client.Emails.Send(params)
+
+	req, err := r.client.NewRequest(context.Background(), http.MethodPost, "emails", params)
 	if err != nil {
+		return err
+	}
+	sent := new(resend.SendEmailResponse)
+	if _, err := r.client.Perform(req, sent); err != nil {
 		fmt.Println(err.Error())
 		return err
 	}
@@ -0,0 +1,342 @@
+package mail
+
+import (
+	"atomic-go-template/internal/config"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTPOptions configure the connection to the mail server
+type SMTPOptions struct {
+	Host string
+	Port int
+	// Empty for servers without authentication
+	Username string
+	Password string
+	// The sender, f.e. "App <noreply@example.org>"
+	From    mail.Address
+	TLSMode config.SMTPTLSMode
+	Auth    config.SMTPAuth
+	// Timeout for connecting and sending a mail
+	Timeout time.Duration
+	// How long an idle connection is kept open for the next mail. Zero or negative closes it after every mail
+	IdleTimeout time.Duration
+	// Overrides the TLS config, f.e. to trust a self signed certificate in tests
+	TLSConfig *tls.Config
+	// Signs the messages if set
+	DKIM *DKIMSigner
+}
+
+// SMTPOptionsFromConfig reads the server and credentials from the environment and the rest from the config
+func SMTPOptionsFromConfig(c config.SMTP) (SMTPOptions, error) {
+	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil {
+		return SMTPOptions{}, fmt.Errorf("smtp: invalid SMTP_PORT: %w", err)
+	}
+	name := os.Getenv("SMTP_FROM_NAME")
+	if name == "" {
+		name = os.Getenv("APP_NAME")
+	}
+	return SMTPOptions{
+		Host:        os.Getenv("SMTP_HOST"),
+		Port:        port,
+		Username:    os.Getenv("SMTP_USERNAME"),
+		Password:    os.Getenv("SMTP_PASSWORD"),
+		From:        mail.Address{Name: name, Address: os.Getenv("SMTP_FROM_EMAIL")},
+		TLSMode:     c.TLSMode,
+		Auth:        c.Auth,
+		Timeout:     c.Timeout,
+		IdleTimeout: c.IdleTimeout,
+	}, nil
+}
+
+// SMTPService sends mails over SMTP. The connection is kept open for IdleTimeout and reused for the next mails
+type SMTPService struct {
+	options SMTPOptions
+
+	mu     sync.Mutex
+	conn   net.Conn
+	client *smtp.Client
+	// Closes the idle connection
+	idleTimer *time.Timer
+}
+
+func NewSMTPService(options SMTPOptions) (*SMTPService, error) {
+	if options.Host == "" || options.Port == 0 {
+		return nil, errors.New("smtp: host and port are required")
+	}
+	if options.From.Address == "" {
+		return nil, errors.New("smtp: from address is required")
+	}
+	switch options.TLSMode {
+	case config.SMTPTLSModeStartTLS, config.SMTPTLSModeImplicit, config.SMTPTLSModeNone:
+	default:
+		return nil, fmt.Errorf("smtp: unsupported TLS mode %q", options.TLSMode)
+	}
+	switch options.Auth {
+	case config.SMTPAuthPlain, config.SMTPAuthLogin, config.SMTPAuthCRAMMD5, config.SMTPAuthNone:
+	default:
+		return nil, fmt.Errorf("smtp: unsupported auth %q", options.Auth)
+	}
+	if options.Timeout == 0 {
+		options.Timeout = 10 * time.Second
+	}
+	return &SMTPService{options: options}, nil
+}
+
+func (s *SMTPService) Send(to, subject, body string) error {
+	return s.SendMessage(Message{To: []string{to}, Subject: subject, HTML: body})
+}
+
+func (s *SMTPService) SendMessage(msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	recipients := []string{}
+	for _, recipient := range msg.Recipients() {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, address.Address)
+	}
+	message, err := buildMIME(s.options.From, msg)
+	if err != nil {
+		return err
+	}
+	if s.options.DKIM != nil {
+		if message, err = s.options.DKIM.Sign(message); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A reused connection may have been closed by the server in the meantime, so we retry once with a new one
+	reused := s.client != nil
+	err = s.send(recipients, message)
+	if err != nil && reused {
+		s.close()
+		err = s.send(recipients, message)
+	}
+	if err != nil {
+		s.close()
+		return err
+	}
+
+	if s.options.IdleTimeout <= 0 {
+		s.close()
+		return nil
+	}
+	s.resetIdleTimer()
+	return nil
+}
+
+// Close closes the open connection, if there is one
+func (s *SMTPService) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.close()
+	return nil
+}
+
+// send delivers the message over the open connection or a new one
+func (s *SMTPService) send(recipients []string, message []byte) error {
+	if s.client == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	} else if err := s.client.Reset(); err != nil {
+		return err
+	}
+
+	if err := s.conn.SetDeadline(time.Now().Add(s.options.Timeout)); err != nil {
+		return err
+	}
+	if err := s.client.Mail(s.options.From.Address); err != nil {
+		return err
+	}
+	// Bcc recipients only get the envelope, they are not in the headers
+	for _, recipient := range recipients {
+		if err := s.client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := s.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// connect dials the server, upgrades the connection to TLS and logs in
+func (s *SMTPService) connect() error {
+	address := net.JoinHostPort(s.options.Host, strconv.Itoa(s.options.Port))
+	dialer := &net.Dialer{Timeout: s.options.Timeout}
+	tlsConfig := s.tlsConfig()
+
+	var conn net.Conn
+	var err error
+	if s.options.TLSMode == config.SMTPTLSModeImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp: connecting to %s: %w", address, err)
+	}
+	// The deadline covers the greeting, TLS handshake and login
+	if err := conn.SetDeadline(time.Now().Add(s.options.Timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, s.options.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp: %w", err)
+	}
+	if err := s.handshake(client, tlsConfig); err != nil {
+		client.Close()
+		return err
+	}
+
+	s.conn = conn
+	s.client = client
+	return nil
+}
+
+func (s *SMTPService) handshake(client *smtp.Client, tlsConfig *tls.Config) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	if err := client.Hello(hostname); err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+
+	if s.options.TLSMode == config.SMTPTLSModeStartTLS {
+		// Never fall back to plain text, the credentials would be sent unencrypted
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp: server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("smtp: STARTTLS: %w", err)
+		}
+	}
+
+	var auth smtp.Auth
+	switch s.options.Auth {
+	case config.SMTPAuthPlain:
+		auth = smtp.PlainAuth("", s.options.Username, s.options.Password, s.options.Host)
+	case config.SMTPAuthLogin:
+		auth = &loginAuth{username: s.options.Username, password: s.options.Password, host: s.options.Host}
+	case config.SMTPAuthCRAMMD5:
+		auth = smtp.CRAMMD5Auth(s.options.Username, s.options.Password)
+	case config.SMTPAuthNone:
+		return nil
+	}
+	if err := client.Auth(auth); err != nil {
+		return fmt.Errorf("smtp: auth: %w", err)
+	}
+	return nil
+}
+
+func (s *SMTPService) tlsConfig() *tls.Config {
+	if s.options.TLSConfig != nil {
+		tlsConfig := s.options.TLSConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = s.options.Host
+		}
+		return tlsConfig
+	}
+	return &tls.Config{ServerName: s.options.Host, MinVersion: tls.VersionTLS12}
+}
+
+// close quits the connection. Must be called with the lock held
+func (s *SMTPService) close() {
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	if s.client == nil {
+		return
+	}
+	_ = s.conn.SetDeadline(time.Now().Add(s.options.Timeout))
+	if err := s.client.Quit(); err != nil {
+		s.client.Close()
+	}
+	s.client = nil
+	s.conn = nil
+}
+
+// resetIdleTimer closes the connection if it is not used again within IdleTimeout. Must be called with the lock held
+func (s *SMTPService) resetIdleTimer() {
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	s.idleTimer = time.AfterFunc(s.options.IdleTimeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.close()
+	})
+}
+
+// messageID returns a unique Message-ID in the domain of the sender
+func messageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+	random := make([]byte, 16)
+	_, _ = rand.Read(random)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(random), domain)
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp does not support.
+// Like smtp.PlainAuth it refuses to send the password over unencrypted connections to other hosts
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,132 @@
+package mail
+
+import (
+	"atomic-go-template/internal/model"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrSuppressed is returned if every recipient of a message is on the suppression list
+var ErrSuppressed = errors.New("all recipients are suppressed")
+
+// SuppressionFilter removes suppressed addresses from the recipients before the next service sends the message
+type SuppressionFilter struct {
+	db   *gorm.DB
+	next Service
+}
+
+func NewSuppressionFilter(db *gorm.DB, next Service) *SuppressionFilter {
+	return &SuppressionFilter{db: db, next: next}
+}
+
+func (s *SuppressionFilter) Send(to, subject, body string) error {
+	return s.SendMessage(Message{To: []string{to}, Subject: subject, HTML: body})
+}
+
+func (s *SuppressionFilter) SendMessage(message Message) error {
+	_, err := s.Deliver(message)
+	return err
+}
+
+// Deliver passes the provider of a failover chain through
+func (s *SuppressionFilter) Deliver(message Message) (string, error) {
+	message, err := s.filter(message)
+	if err != nil {
+		return "", err
+	}
+	if deliverer, ok := s.next.(Deliverer); ok {
+		return deliverer.Deliver(message)
+	}
+	return "", s.next.SendMessage(message)
+}
+
+func (s *SuppressionFilter) filter(message Message) (Message, error) {
+	recipients := message.Recipients()
+	keys := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		keys = append(keys, recipientKey(recipient))
+	}
+	var suppressed []string
+	if err := s.db.Model(&model.MailSuppression{}).Where("email IN ?", keys).Pluck("email", &suppressed).Error; err != nil {
+		return message, fmt.Errorf("checking suppression list: %w", err)
+	}
+	if len(suppressed) == 0 {
+		return message, nil
+	}
+
+	isSuppressed := map[string]bool{}
+	for _, email := range suppressed {
+		isSuppressed[email] = true
+	}
+	keep := func(list []string) []string {
+		var kept []string
+		for _, address := range list {
+			if !isSuppressed[recipientKey(address)] {
+				kept = append(kept, address)
+			}
+		}
+		return kept
+	}
+	message.To, message.Cc, message.Bcc = keep(message.To), keep(message.Cc), keep(message.Bcc)
+	if len(message.To)+len(message.Cc)+len(message.Bcc) == 0 {
+		return message, fmt.Errorf("%w: %s", ErrSuppressed, strings.Join(suppressed, ", "))
+	}
+	log.Printf("Mail %q not sent to suppressed addresses %s", message.Subject, strings.Join(suppressed, ", "))
+	return message, nil
+}
+
+// Suppress adds the address to the suppression list, or updates the reason if it is already on it
+func Suppress(db *gorm.DB, email string, reason model.SuppressionReason, detail, source string) error {
+	suppression := model.MailSuppression{
+		Email:  recipientKey(email),
+		Reason: reason,
+		Detail: detail,
+		Source: source,
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "email"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"reason":     reason,
+			"detail":     detail,
+			"source":     source,
+			"updated_at": time.Now(),
+			"deleted_at": nil,
+		}),
+	}).Create(&suppression).Error
+}
+
+// IsSuppressed returns true if the address is on the suppression list
+func IsSuppressed(db *gorm.DB, email string) (bool, error) {
+	var count int64
+	err := db.Model(&model.MailSuppression{}).Where("email = ?", recipientKey(email)).Count(&count).Error
+	return count > 0, err
+}
+
+// HandleDeliveryEvent suppresses hard bounces and complaints. Users with a bounced address are flagged,
+// so they are asked to update it. Soft bounces like a full mailbox are ignored, the provider retries them
+func HandleDeliveryEvent(db *gorm.DB, event DeliveryEvent) error {
+	if event.Email == "" {
+		return nil
+	}
+	switch event.Type {
+	case DeliveryEventBounce:
+		if !event.Permanent {
+			return nil
+		}
+		if err := Suppress(db, event.Email, model.SuppressionReasonBounce, event.Reason, event.Source); err != nil {
+			return err
+		}
+		return db.Model(&model.User{}).
+			Where("LOWER(email) = ?", recipientKey(event.Email)).
+			Update("email_bounced_at", time.Now()).Error
+	case DeliveryEventComplaint:
+		return Suppress(db, event.Email, model.SuppressionReasonComplaint, event.Reason, event.Source)
+	}
+	return nil
+}
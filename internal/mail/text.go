@@ -0,0 +1,137 @@
+package mail
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLToText returns a plain text version of a HTML mail for the text/plain alternative.
+// Links keep their target in parentheses, hidden elements like the preview text are left out
+func HTMLToText(body string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	t := &textWriter{}
+	t.walk(doc)
+	return t.String(), nil
+}
+
+// Elements that start on a new line, the ones with true are separated by an empty line
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Table: true, atom.Ul: true, atom.Ol: true, atom.Blockquote: true, atom.Pre: true, atom.Hr: true,
+	atom.Div: false, atom.Tr: false, atom.Td: false, atom.Th: false, atom.Li: false, atom.Br: false,
+	atom.Section: false, atom.Header: false, atom.Footer: false,
+}
+
+var hiddenStyle = regexp.MustCompile(`display\s*:\s*none`)
+
+type textWriter struct {
+	b strings.Builder
+	// Newlines to write before the next text
+	pendingNewlines int
+	// Whether the last text ended with whitespace
+	space bool
+}
+
+func (t *textWriter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		t.text(n.Data)
+		return
+	case html.ElementNode:
+		switch n.DataAtom {
+		case atom.Head, atom.Style, atom.Script, atom.Title:
+			return
+		case atom.Br:
+			t.newlines(1)
+			return
+		case atom.Hr:
+			t.newlines(2)
+			t.text("----------")
+			t.newlines(2)
+			return
+		case atom.Img:
+			t.text(attribute(n, "alt"))
+			return
+		}
+		if hiddenStyle.MatchString(attribute(n, "style")) {
+			return
+		}
+	}
+
+	separated, block := blockElements[n.DataAtom]
+	if n.Type == html.ElementNode && block {
+		t.newlines(separation(separated))
+	}
+	if n.DataAtom == atom.Li {
+		t.text("- ")
+	}
+
+	start := t.b.Len()
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		t.walk(child)
+	}
+
+	if n.DataAtom == atom.A {
+		// Show where the link goes, unless the text is the link itself
+		href := attribute(n, "href")
+		linkText := strings.TrimSpace(t.b.String()[start:])
+		if href != "" && !strings.HasPrefix(href, "#") && href != linkText && "mailto:"+linkText != href {
+			t.text(" (" + href + ")")
+		}
+	}
+	if n.Type == html.ElementNode && block {
+		t.newlines(separation(separated))
+	}
+}
+
+func separation(separated bool) int {
+	if separated {
+		return 2
+	}
+	return 1
+}
+
+// text writes the text with collapsed whitespace
+func (t *textWriter) text(raw string) {
+	text := strings.Join(strings.Fields(raw), " ")
+	if text == "" {
+		t.space = t.space || raw != ""
+		return
+	}
+	if t.b.Len() > 0 {
+		if t.pendingNewlines > 0 {
+			t.b.WriteString(strings.Repeat("\n", t.pendingNewlines))
+		} else if t.space || unicode.IsSpace(rune(raw[0])) {
+			t.b.WriteString(" ")
+		}
+	}
+	t.pendingNewlines = 0
+	t.space = unicode.IsSpace(rune(raw[len(raw)-1]))
+	t.b.WriteString(text)
+}
+
+func (t *textWriter) newlines(count int) {
+	if count > t.pendingNewlines {
+		t.pendingNewlines = count
+	}
+}
+
+func (t *textWriter) String() string {
+	return t.b.String() + "\n"
+}
+
+func attribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
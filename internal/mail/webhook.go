@@ -0,0 +1,166 @@
+package mail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DeliveryEventType string
+
+const (
+	DeliveryEventBounce    DeliveryEventType = "bounce"
+	DeliveryEventComplaint DeliveryEventType = "complaint"
+)
+
+// DeliveryEvent is a bounce or complaint reported by a provider, see HandleDeliveryEvent
+type DeliveryEvent struct {
+	Type  DeliveryEventType `json:"type"`
+	Email string            `json:"email"`
+	// True for hard bounces. Soft bounces like a full mailbox are not suppressed
+	Permanent bool   `json:"permanent"`
+	Reason    string `json:"reason"`
+	// Set by the parser, f.e. "resend"
+	Source string `json:"-"`
+}
+
+// ErrInvalidSignature is returned for webhooks that are not signed with the secret
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Resend signs webhooks like Svix, a replayed webhook older than this is rejected. The generic webhook uses it too
+const webhookTolerance = 5 * time.Minute
+
+// VerifyResendWebhook checks the signature Resend sends in the svix-id, svix-timestamp and svix-signature headers.
+// The secret is the signing secret of the webhook in the Resend dashboard, starting with "whsec_"
+func VerifyResendWebhook(secret string, header http.Header, body []byte, now time.Time) error {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return fmt.Errorf("webhook secret: %w", err)
+	}
+	id, timestamp, signatures := header.Get("svix-id"), header.Get("svix-timestamp"), header.Get("svix-signature")
+	if id == "" || timestamp == "" || signatures == "" {
+		return fmt.Errorf("%w: missing headers", ErrInvalidSignature)
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", ErrInvalidSignature)
+	}
+	if sent := time.Unix(seconds, 0); sent.Before(now.Add(-webhookTolerance)) || sent.After(now.Add(webhookTolerance)) {
+		return fmt.Errorf("%w: timestamp too old or too new", ErrInvalidSignature)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	// Multiple signatures are sent while the secret is rotated
+	for _, signature := range strings.Fields(signatures) {
+		version, value, found := strings.Cut(signature, ",")
+		if !found || version != "v1" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+type resendWebhook struct {
+	Type string `json:"type"`
+	Data struct {
+		To     []string `json:"to"`
+		Bounce *struct {
+			Type    string `json:"type"`
+			SubType string `json:"subType"`
+			Message string `json:"message"`
+		} `json:"bounce"`
+	} `json:"data"`
+}
+
+// ParseResendWebhook returns the bounces and complaints of a Resend webhook. Other events return no events
+func ParseResendWebhook(body []byte) ([]DeliveryEvent, error) {
+	var webhook resendWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, fmt.Errorf("parsing webhook: %w", err)
+	}
+	event := DeliveryEvent{Source: "resend"}
+	switch webhook.Type {
+	case "email.bounced":
+		event.Type = DeliveryEventBounce
+		// Resend only reports bounces it gave up on, unless it says otherwise
+		event.Permanent = true
+		if bounce := webhook.Data.Bounce; bounce != nil {
+			event.Permanent = !strings.EqualFold(bounce.Type, "Transient")
+			event.Reason = strings.TrimSpace(bounce.SubType + " " + bounce.Message)
+		}
+	case "email.complained":
+		event.Type = DeliveryEventComplaint
+		event.Reason = "Marked as spam"
+	default:
+		return nil, nil
+	}
+	var events []DeliveryEvent
+	for _, to := range webhook.Data.To {
+		event.Email = to
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// VerifyGenericWebhook checks the X-Webhook-Timestamp header, the unix time the webhook was sent, and the
+// X-Webhook-Signature header, "sha256=" followed by the hex HMAC-SHA256 of the timestamp, a dot and the body.
+// Like for Resend, a replayed webhook older than webhookTolerance is rejected
+func VerifyGenericWebhook(secret string, header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get("X-Webhook-Timestamp")
+	signature, found := strings.CutPrefix(header.Get("X-Webhook-Signature"), "sha256=")
+	if !found || timestamp == "" {
+		return fmt.Errorf("%w: missing headers", ErrInvalidSignature)
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", ErrInvalidSignature)
+	}
+	if sent := time.Unix(seconds, 0); sent.Before(now.Add(-webhookTolerance)) || sent.After(now.Add(webhookTolerance)) {
+		return fmt.Errorf("%w: timestamp too old or too new", ErrInvalidSignature)
+	}
+	decoded, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if !hmac.Equal(decoded, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// ParseGenericWebhook reads the format for other providers and own relays:
+//
+//	{"events": [{"type": "bounce", "email": "alice@example.org", "permanent": true, "reason": "Mailbox does not exist"}]}
+func ParseGenericWebhook(body []byte) ([]DeliveryEvent, error) {
+	var webhook struct {
+		Events []DeliveryEvent `json:"events"`
+	}
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, fmt.Errorf("parsing webhook: %w", err)
+	}
+	for i, event := range webhook.Events {
+		if event.Type != DeliveryEventBounce && event.Type != DeliveryEventComplaint {
+			return nil, fmt.Errorf("parsing webhook: unknown event type %q", event.Type)
+		}
+		webhook.Events[i].Source = "webhook"
+	}
+	return webhook.Events, nil
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"atomic-go-template/internal/model"
+	"net/http"
+)
+
+// IsAdmin checks if the user has the admin role, otherwise responds with 404 so admin pages are not discoverable.
+// Use it after IsLoggedIn
+func (m *Middleware) IsAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(UserKey).(model.User)
+		if !ok || !user.IsAdmin() {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
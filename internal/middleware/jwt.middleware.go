@@ -25,7 +25,7 @@ func (m *Middleware) JWTMiddleware(next http.Handler) http.Handler {
 		// Get User from DB
 		// TODO: Clear out Passwords or use another struct
 		user := model.User{}
-		err = m.db.GetDB().First(&user, "id = ?", userID).Error
+		err = m.db.GetDB().WithContext(r.Context()).First(&user, "id = ?", userID).Error
 		if err != nil {
 			// http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			next.ServeHTTP(w, r)
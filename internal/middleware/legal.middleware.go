@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"atomic-go-template/internal/legal"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/store"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Paths that stay reachable while the user has not accepted the current legal documents.
+// The signed unsubscribe links of mails work without a login, so they work for logged in users too
+var legalExemptPrefixes = []string{"/legal/", "/assets/", "/public/", "/auth/logout", "/auth/reauthenticate", "/unsubscribe", "/health", "/theme"}
+
+// RequireLegalAcceptance redirects logged in users to the acceptance page until they accepted the current Terms of Service and Privacy Policy
+func (m *Middleware) RequireLegalAcceptance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(UserKey).(model.User)
+		if !ok || !m.config.Legal.EnableLegal {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, prefix := range legalExemptPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		pending, err := legal.PendingDocuments(r.Context(), store.NewGormUserStore(m.db.GetDB()), user.ID, m.config.Legal)
+		if err != nil {
+			fmt.Println("Error checking legal acceptance:", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(pending) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		returnTo := "/"
+		if r.Method == http.MethodGet {
+			returnTo = r.URL.RequestURI()
+		}
+		redirectUrl := "/legal/accept?next=" + url.QueryEscape(returnTo)
+
+		// HTMX requests need a client side redirect
+		if r.Header.Get("HX-Request") == "true" {
+			w.Header().Add("HX-Redirect", redirectUrl)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Redirect(w, r, redirectUrl, http.StatusSeeOther)
+	})
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"atomic-go-template/internal/database"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const readPrimaryCookie = "read_primary_until"
+
+// ReadYourWrites sends the reads of a session to the primary for Database.ReadYourWritesWindow after a mutation,
+// so users see their changes even if the replicas lag behind. Queries need the request context: db.WithContext(r.Context())
+func (m *Middleware) ReadYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			cookie, err := r.Cookie(readPrimaryCookie)
+			if err != nil {
+				break
+			}
+			until, err := strconv.ParseInt(cookie.Value, 10, 64)
+			if err == nil && time.Now().UnixMilli() < until {
+				r = r.WithContext(database.WithPrimary(r.Context()))
+			}
+		default:
+			until := time.Now().Add(m.config.Database.ReadYourWritesWindow)
+			http.SetCookie(w, &http.Cookie{
+				Name:     readPrimaryCookie,
+				Value:    strconv.FormatInt(until.UnixMilli(), 10),
+				Path:     "/",
+				Expires:  until,
+				HttpOnly: true,
+				Secure:   true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			r = r.WithContext(database.WithPrimary(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
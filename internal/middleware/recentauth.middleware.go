@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"atomic-go-template/internal/utils"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RequireRecentAuth makes sure the user entered their credentials recently, otherwise redirects to the re-authentication page.
+// After re-authenticating the user is sent back to the page the action was started from.
+// Use it after IsLoggedIn for sensitive actions like downloading a backup. Handlers serving sensitive and harmless
+// actions, like the profile, check HasRecentAuth themselves
+func (m *Middleware) RequireRecentAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if HasRecentAuth(r, m.config.Auth.RecentAuthMaxAge) {
+			next(w, r)
+			return
+		}
+		RedirectToReauthenticate(w, r)
+	}
+}
+
+// HasRecentAuth reports whether the user of the request entered their credentials within maxAge
+func HasRecentAuth(r *http.Request, maxAge time.Duration) bool {
+	claims, err := utils.GetJWTClaims(r)
+	return err == nil && claims.AuthTime != nil && time.Since(claims.AuthTime.Time) <= maxAge
+}
+
+// RedirectToReauthenticate sends the user to the re-authentication page and back to the current page afterwards
+func RedirectToReauthenticate(w http.ResponseWriter, r *http.Request) {
+	// GET requests can be repeated, for form submissions we return to the page holding the form
+	returnTo := r.URL.RequestURI()
+	if r.Method != http.MethodGet {
+		returnTo = "/"
+		if referer, err := url.Parse(r.Referer()); err == nil && referer.Path != "" {
+			returnTo = referer.RequestURI()
+		}
+	}
+	redirectUrl := "/auth/reauthenticate?next=" + url.QueryEscape(returnTo)
+
+	// HTMX requests need a client side redirect
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Add("HX-Redirect", redirectUrl)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, redirectUrl, http.StatusSeeOther)
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type BroadcastStatus string
+
+const (
+	// Waiting for the worker
+	BroadcastStatusQueued  BroadcastStatus = "queued"
+	BroadcastStatusSending BroadcastStatus = "sending"
+	BroadcastStatusSent    BroadcastStatus = "sent"
+	// Stopped by an admin, the remaining users don't get the mail
+	BroadcastStatusCancelled BroadcastStatus = "cancelled"
+)
+
+type BroadcastSegment string
+
+const (
+	BroadcastSegmentAll      BroadcastSegment = "all"
+	BroadcastSegmentVerified BroadcastSegment = "verified"
+	// Users who did not log in for 30 days
+	BroadcastSegmentInactive BroadcastSegment = "inactive"
+	// Members of an organization, the users with an email of SegmentDomain
+	BroadcastSegmentDomain BroadcastSegment = "domain"
+)
+
+// Broadcast is a mail an admin sends to all users of a segment who did not unsubscribe from the category.
+// The worker sends it in batches of users ordered by ID, Cursor is the last user of the finished batches
+type Broadcast struct {
+	BaseModel
+	Subject string `gorm:"not null"`
+	// Plain text, paragraphs are separated by blank lines
+	Body          string           `gorm:"not null"`
+	Category      string           `gorm:"not null"`
+	Segment       BroadcastSegment `gorm:"not null"`
+	SegmentDomain string           `gorm:""`
+	Status        BroadcastStatus  `gorm:"not null;index"`
+	CreatedByID   uuid.UUID        `gorm:"type:uuid;not null"`
+	// Users in the segment when the broadcast was queued
+	Total int `gorm:"not null;default:0"`
+	Sent  int `gorm:"not null;default:0"`
+	// Unsubscribed or suppressed users
+	Skipped     int        `gorm:"not null;default:0"`
+	Failed      int        `gorm:"not null;default:0"`
+	Cursor      *uuid.UUID `gorm:"type:uuid"`
+	LastError   *string    `gorm:""`
+	LockedUntil *time.Time `gorm:""`
+	StartedAt   *time.Time `gorm:""`
+	FinishedAt  *time.Time `gorm:""`
+}
+
+func (Broadcast) TableName() string {
+	return "broadcasts"
+}
+
+type BroadcastInput struct {
+	Subject       string `validate:"required,max=200" form:"subject"`
+	Body          string `validate:"required" form:"body"`
+	Category      string `validate:"required" form:"category"`
+	Segment       string `validate:"required,oneof=all verified inactive domain" form:"segment"`
+	SegmentDomain string `validate:"required_if=Segment domain" form:"segment_domain"`
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LegalDocument is the kind of legal document a user has to accept
+type LegalDocument string
+
+const (
+	LegalDocumentTerms   LegalDocument = "terms"
+	LegalDocumentPrivacy LegalDocument = "privacy"
+)
+
+// LegalAcceptance records that a user accepted a version of a legal document.
+type LegalAcceptance struct {
+	BaseModel
+	UserID     uuid.UUID     `gorm:"type:uuid;not null;index;uniqueIndex:idx_legal_acceptances_user_document_version"`
+	Document   LegalDocument `gorm:"not null;uniqueIndex:idx_legal_acceptances_user_document_version"`
+	Version    string        `gorm:"not null;uniqueIndex:idx_legal_acceptances_user_document_version"`
+	AcceptedAt time.Time     `gorm:"not null"`
+	IPAddress  string        `gorm:""`
+}
+
+type AcceptLegalInput struct {
+	AcceptTerms bool   `validate:"required" form:"accept_terms"`
+	Next        string `validate:"omitempty" form:"next"`
+}
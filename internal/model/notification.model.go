@@ -0,0 +1,21 @@
+package model
+
+import "github.com/google/uuid"
+
+// NotificationPreference stores the choice of a user for a category of non-transactional mail.
+// Without a row the default of the category applies, see notification.Categories
+type NotificationPreference struct {
+	BaseModel
+	UserID     uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_notification_preferences_user_category"`
+	Category   string    `gorm:"not null;uniqueIndex:idx_notification_preferences_user_category"`
+	Subscribed bool      `gorm:"not null"`
+}
+
+func (NotificationPreference) TableName() string {
+	return "notification_preferences"
+}
+
+type NotificationPreferencesInput struct {
+	// Keys of the subscribed categories, unchecked categories are unsubscribed
+	Categories []string `validate:"-" form:"categories"`
+}
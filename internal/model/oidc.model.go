@@ -0,0 +1,80 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OIDCClient is a service that is allowed to log in users with the OpenID Connect provider.
+type OIDCClient struct {
+	BaseModel
+	Name     string `gorm:"not null"`
+	ClientID string `gorm:"unique;not null"`
+	// SHA-256 of the client secret. Public clients like SPAs have no secret and must use PKCE
+	SecretHash *string `gorm:""`
+	// Allowed redirect URIs, one per line
+	RedirectURIs string `gorm:"not null"`
+	// Trusted internal clients can skip the consent screen
+	SkipConsent bool `gorm:"not null;default:false"`
+}
+
+func (OIDCClient) TableName() string {
+	return "oidc_clients"
+}
+
+// IsPublic returns true if the client has no secret
+func (c OIDCClient) IsPublic() bool {
+	return c.SecretHash == nil
+}
+
+// AllowsRedirectURI returns true if the redirect URI is registered for the client. URIs have to match exactly
+func (c OIDCClient) AllowsRedirectURI(uri string) bool {
+	for _, allowed := range strings.Split(c.RedirectURIs, "\n") {
+		if strings.TrimSpace(allowed) == uri && uri != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCAuthorizationCode is a short lived code issued by the authorization endpoint.
+type OIDCAuthorizationCode struct {
+	BaseModel
+	// SHA-256 of the code
+	CodeHash            string     `gorm:"unique;not null"`
+	ClientID            uuid.UUID  `gorm:"type:uuid;not null"`
+	UserID              uuid.UUID  `gorm:"type:uuid;not null"`
+	RedirectURI         string     `gorm:"not null"`
+	Scope               string     `gorm:"not null"`
+	Nonce               *string    `gorm:""`
+	CodeChallenge       *string    `gorm:""`
+	CodeChallengeMethod *string    `gorm:""`
+	AuthTime            *time.Time `gorm:""`
+	ExpiresAt           time.Time  `gorm:"not null"`
+	UsedAt              *time.Time `gorm:""`
+}
+
+func (OIDCAuthorizationCode) TableName() string {
+	return "oidc_authorization_codes"
+}
+
+// OIDCConsent stores the scopes a user granted to a client, so the consent screen is only shown once.
+type OIDCConsent struct {
+	BaseModel
+	UserID   uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_oidc_consent_user_client"`
+	ClientID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_oidc_consent_user_client"`
+	Scope    string    `gorm:"not null"`
+}
+
+func (OIDCConsent) TableName() string {
+	return "oidc_consents"
+}
+
+type CreateOIDCClientInput struct {
+	Name         string `validate:"required,max=100" form:"name"`
+	RedirectURIs string `validate:"required" form:"redirect_uris"`
+	Public       bool   `validate:"-" form:"public"`
+	SkipConsent  bool   `validate:"-" form:"skip_consent"`
+}
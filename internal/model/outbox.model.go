@@ -0,0 +1,45 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OutboxStatus string
+
+const (
+	// Waiting for the next delivery attempt
+	OutboxStatusPending OutboxStatus = "pending"
+	// A worker is delivering the message right now
+	OutboxStatusSending OutboxStatus = "sending"
+	// Delivered to the mail provider
+	OutboxStatusSent OutboxStatus = "sent"
+	// Failed permanently or ran out of attempts. Admins can retry it
+	OutboxStatusDead OutboxStatus = "dead"
+)
+
+// OutboxMessage is a mail waiting for delivery by the background worker, see mail.Outbox.
+type OutboxMessage struct {
+	BaseModel
+	Status OutboxStatus `gorm:"not null;index"`
+	// Lowercased address of the first recipient, the per recipient throttle counts by it
+	Recipient string `gorm:"not null;index"`
+	Subject   string `gorm:"not null"`
+	// The mail.Message as JSON
+	Payload       string    `gorm:"not null"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"not null;index"`
+	// A worker that crashed while sending leaves the message claimed until then
+	LockedUntil *time.Time `gorm:""`
+	LastError   *string    `gorm:""`
+	SentAt      *time.Time `gorm:"index"`
+	// The provider of the failover chain that delivered the message
+	Provider *string `gorm:""`
+	// The broadcast the message belongs to, its sent and failed counts are updated once the message is sent or dead
+	BroadcastID *uuid.UUID `gorm:"type:uuid;index"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox_messages"
+}
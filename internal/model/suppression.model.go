@@ -0,0 +1,26 @@
+package model
+
+type SuppressionReason string
+
+const (
+	// The address does not exist or the mailbox rejects mail permanently
+	SuppressionReasonBounce SuppressionReason = "bounce"
+	// The recipient marked a mail as spam
+	SuppressionReasonComplaint SuppressionReason = "complaint"
+)
+
+// MailSuppression is an address no mails are sent to anymore, see mail.SuppressionFilter.
+type MailSuppression struct {
+	BaseModel
+	// Lowercased address
+	Email  string            `gorm:"unique;not null"`
+	Reason SuppressionReason `gorm:"not null"`
+	// Message of the provider, f.e. why the mail bounced
+	Detail string `gorm:""`
+	// Where the event came from, f.e. "resend" or "webhook"
+	Source string `gorm:"not null"`
+}
+
+func (MailSuppression) TableName() string {
+	return "mail_suppressions"
+}
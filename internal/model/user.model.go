@@ -2,6 +2,14 @@ package model
 
 import "time"
 
+// Role is the role of a user
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
 // User represents a user in the database.
 type User struct {
 	BaseModel
@@ -16,6 +24,14 @@ type User struct {
 	AvatarURL                *string    `gorm:""` // Avatar URL is optional
 	OAuthProvider            *string    `gorm:""` // OAuth provider name (e.g., "google", "github")
 	OAuthID                  *string    `gorm:""` // OAuth provider user ID
+	Role                     Role       `gorm:"not null;default:user"`
+	EmailBouncedAt           *time.Time `gorm:""` // Set when mails to the email address bounce, the user is asked to update it
+	LastLoginAt              *time.Time `gorm:""` // Set on every login
+}
+
+// IsAdmin returns true if the user has the admin role
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
 }
 
 type SignUpInput struct {
@@ -23,6 +39,7 @@ type SignUpInput struct {
 	Email           string `validate:"required,email" form:"email"`
 	Password        string `validate:"required,min=8" form:"password"`
 	PasswordConfirm string `validate:"required,min=8" form:"confirm_password"`
+	AcceptTerms     bool   `validate:"-" form:"accept_terms"`
 }
 
 type EditProfileInput struct {
@@ -36,6 +53,12 @@ type EditProfileInput struct {
 type LoginInput struct {
 	Email    string `validate:"required,email" form:"email"`
 	Password string `validate:"required" form:"password"`
+	Next     string `validate:"omitempty" form:"next"`
+}
+
+type ReauthenticateInput struct {
+	Password string `validate:"required" form:"password"`
+	Next     string `validate:"omitempty" form:"next"`
 }
 
 type ForgotPasswordInput struct {
@@ -0,0 +1,166 @@
+// Package notification manages which categories of non-transactional mail users receive.
+// Transactional mails like password resets don't have a category and are always sent
+package notification
+
+import (
+	"atomic-go-template/internal/model"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Category is a kind of non-transactional mail users can unsubscribe from
+type Category struct {
+	// Stored in the preferences and the unsubscribe links, don't change it once mails were sent
+	Key         string
+	Name        string
+	Description string
+	// Whether users receive the category before they chose
+	Default bool
+}
+
+// Categories are shown on the notification preferences page in this order. Add the categories of your app here
+var Categories = []Category{
+	{
+		Key:         "product_updates",
+		Name:        "Product updates",
+		Description: "New features and important changes",
+		Default:     true,
+	},
+	{
+		Key:         "newsletter",
+		Name:        "Newsletter",
+		Description: "Tips, stories and news",
+		Default:     false,
+	},
+}
+
+var (
+	// ErrUnknownCategory is returned for category keys that are not in Categories
+	ErrUnknownCategory = errors.New("unknown notification category")
+	// ErrUnsubscribed is returned when sending a category the user unsubscribed from
+	ErrUnsubscribed = errors.New("user unsubscribed from the category")
+	// ErrInvalidToken is returned for unsubscribe tokens that were not signed by the app
+	ErrInvalidToken = errors.New("invalid unsubscribe token")
+)
+
+func CategoryByKey(key string) (Category, error) {
+	for _, category := range Categories {
+		if category.Key == key {
+			return category, nil
+		}
+	}
+	return Category{}, ErrUnknownCategory
+}
+
+// Preferences returns for every category whether the user receives it
+func Preferences(db *gorm.DB, userID uuid.UUID) (map[string]bool, error) {
+	var stored []model.NotificationPreference
+	if err := db.Where("user_id = ?", userID).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	preferences := map[string]bool{}
+	for _, category := range Categories {
+		preferences[category.Key] = category.Default
+	}
+	for _, preference := range stored {
+		if _, known := preferences[preference.Category]; known {
+			preferences[preference.Category] = preference.Subscribed
+		}
+	}
+	return preferences, nil
+}
+
+// IsSubscribed returns whether the user receives the category
+func IsSubscribed(db *gorm.DB, userID uuid.UUID, key string) (bool, error) {
+	category, err := CategoryByKey(key)
+	if err != nil {
+		return false, err
+	}
+	var preference model.NotificationPreference
+	err = db.Where("user_id = ? AND category = ?", userID, key).Limit(1).Find(&preference).Error
+	if err != nil {
+		return false, err
+	}
+	if preference.ID == uuid.Nil {
+		return category.Default, nil
+	}
+	return preference.Subscribed, nil
+}
+
+// SubscribedUsers returns which of the users receive the category
+func SubscribedUsers(db *gorm.DB, userIDs []uuid.UUID, key string) (map[uuid.UUID]bool, error) {
+	category, err := CategoryByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var stored []model.NotificationPreference
+	if err := db.Where("category = ? AND user_id IN ?", key, userIDs).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	subscribed := make(map[uuid.UUID]bool, len(userIDs))
+	for _, userID := range userIDs {
+		subscribed[userID] = category.Default
+	}
+	for _, preference := range stored {
+		subscribed[preference.UserID] = preference.Subscribed
+	}
+	return subscribed, nil
+}
+
+// SetSubscribed stores the choice of the user for the category
+func SetSubscribed(db *gorm.DB, userID uuid.UUID, key string, subscribed bool) error {
+	if _, err := CategoryByKey(key); err != nil {
+		return err
+	}
+	preference := model.NotificationPreference{UserID: userID, Category: key, Subscribed: subscribed}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "category"}},
+		DoUpdates: clause.AssignmentColumns([]string{"subscribed", "updated_at"}),
+	}).Create(&preference).Error
+}
+
+var secretKey = []byte(os.Getenv("SECRET_KEY"))
+
+// UnsubscribeToken signs the user and category for the unsubscribe link. It does not expire,
+// links in old mails have to keep working
+func UnsubscribeToken(userID uuid.UUID, category string) string {
+	payload := userID.String() + ":" + category
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(sign(payload))
+}
+
+// ParseUnsubscribeToken returns the user and category of a token created by UnsubscribeToken
+func ParseUnsubscribeToken(token string) (uuid.UUID, string, error) {
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, sign(string(payload))) {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	id, category, _ := strings.Cut(string(payload), ":")
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	return userID, category, nil
+}
+
+func sign(payload string) []byte {
+	// The prefix keeps the signature from being valid for other uses of the secret
+	mac := hmac.New(sha256.New, secretKey)
+	mac.Write([]byte("unsubscribe:" + payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,193 @@
+package oidc
+
+import (
+	"atomic-go-template/internal/model"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuthorizeRequest holds the parameters of an authorization request
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// ParseAuthorizeRequest reads the request parameters from a query string or form
+func ParseAuthorizeRequest(values url.Values) AuthorizeRequest {
+	return AuthorizeRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		Nonce:               values.Get("nonce"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+}
+
+// Values returns the request parameters, used to carry the request through login and consent
+func (a AuthorizeRequest) Values() url.Values {
+	values := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	set("response_type", a.ResponseType)
+	set("client_id", a.ClientID)
+	set("redirect_uri", a.RedirectURI)
+	set("scope", a.Scope)
+	set("state", a.State)
+	set("nonce", a.Nonce)
+	set("code_challenge", a.CodeChallenge)
+	set("code_challenge_method", a.CodeChallengeMethod)
+	return values
+}
+
+// Scopes returns the requested scopes that are supported, unknown scopes are ignored
+func (a AuthorizeRequest) Scopes() []string {
+	var scopes []string
+	for _, scope := range strings.Fields(a.Scope) {
+		if slices.Contains(SupportedScopes, scope) && !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// ErrorRedirect returns the redirect URI of the client with the error attached
+func (a AuthorizeRequest) ErrorRedirect(err *Error) string {
+	values := url.Values{}
+	values.Set("error", err.Code)
+	if err.Description != "" {
+		values.Set("error_description", err.Description)
+	}
+	if a.State != "" {
+		values.Set("state", a.State)
+	}
+	return appendQuery(a.RedirectURI, values)
+}
+
+// ValidateClient checks the client and redirect URI. If they are invalid we must not redirect back to the client
+func (p *Provider) ValidateClient(a AuthorizeRequest) (model.OIDCClient, error) {
+	client, err := p.GetClient(a.ClientID)
+	if err != nil {
+		return client, newError(ErrorInvalidClient, "unknown client")
+	}
+	if !client.AllowsRedirectURI(a.RedirectURI) {
+		return client, newError(ErrorInvalidRequest, "redirect_uri is not registered for this client")
+	}
+	return client, nil
+}
+
+// ValidateRequest checks the remaining parameters, errors can be sent back to the client with ErrorRedirect
+func (p *Provider) ValidateRequest(a AuthorizeRequest, client model.OIDCClient) *Error {
+	if a.ResponseType != "code" {
+		return newError(ErrorUnsupportedResponseType, "only the authorization code flow is supported")
+	}
+	if !slices.Contains(a.Scopes(), "openid") {
+		return newError(ErrorInvalidScope, "the openid scope is required")
+	}
+	if a.CodeChallenge == "" && client.IsPublic() {
+		return newError(ErrorInvalidRequest, "public clients must use PKCE")
+	}
+	if a.CodeChallenge != "" && a.CodeChallengeMethod != "S256" {
+		return newError(ErrorInvalidRequest, "code_challenge_method must be S256")
+	}
+	return nil
+}
+
+// NeedsConsent returns true if the user has not yet granted all requested scopes to the client
+func (p *Provider) NeedsConsent(a AuthorizeRequest, client model.OIDCClient, userID uuid.UUID) bool {
+	if client.SkipConsent {
+		return false
+	}
+	consent := model.OIDCConsent{}
+	if err := p.db.First(&consent, "user_id = ? AND client_id = ?", userID, client.ID).Error; err != nil {
+		return true
+	}
+	granted := strings.Fields(consent.Scope)
+	for _, scope := range a.Scopes() {
+		if !slices.Contains(granted, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveConsent remembers the scopes the user granted to the client
+func (p *Provider) SaveConsent(a AuthorizeRequest, client model.OIDCClient, userID uuid.UUID) error {
+	consent := model.OIDCConsent{}
+	p.db.First(&consent, "user_id = ? AND client_id = ?", userID, client.ID)
+
+	scopes := strings.Fields(consent.Scope)
+	for _, scope := range a.Scopes() {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	consent.UserID = userID
+	consent.ClientID = client.ID
+	consent.Scope = strings.Join(scopes, " ")
+	return p.db.Save(&consent).Error
+}
+
+// Approve issues an authorization code and returns the redirect URI of the client with the code attached
+func (p *Provider) Approve(a AuthorizeRequest, client model.OIDCClient, userID uuid.UUID, authTime *time.Time) (string, error) {
+	code, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	authorizationCode := model.OIDCAuthorizationCode{
+		CodeHash:    hashToken(code),
+		ClientID:    client.ID,
+		UserID:      userID,
+		RedirectURI: a.RedirectURI,
+		Scope:       strings.Join(a.Scopes(), " "),
+		AuthTime:    authTime,
+		ExpiresAt:   time.Now().Add(p.config.OIDC.AuthorizationCodeLifetime),
+	}
+	if a.Nonce != "" {
+		authorizationCode.Nonce = &a.Nonce
+	}
+	if a.CodeChallenge != "" {
+		authorizationCode.CodeChallenge = &a.CodeChallenge
+		authorizationCode.CodeChallengeMethod = &a.CodeChallengeMethod
+	}
+	if err := p.db.Create(&authorizationCode).Error; err != nil {
+		return "", err
+	}
+
+	values := url.Values{}
+	values.Set("code", code)
+	if a.State != "" {
+		values.Set("state", a.State)
+	}
+	return appendQuery(a.RedirectURI, values), nil
+}
+
+// appendQuery adds the values to the query of the URI, keeping existing parameters
+func appendQuery(uri string, values url.Values) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	query := parsed.Query()
+	for key := range values {
+		query.Set(key, values.Get(key))
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
@@ -0,0 +1,72 @@
+package oidc
+
+import (
+	"atomic-go-template/internal/model"
+	"crypto/subtle"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// CreateClient registers a new client. The returned secret is only available now, only its hash is stored.
+// Public clients get no secret
+func (p *Provider) CreateClient(input model.CreateOIDCClientInput) (model.OIDCClient, string, error) {
+	clientID, err := randomToken()
+	if err != nil {
+		return model.OIDCClient{}, "", err
+	}
+
+	// Normalize the redirect URIs to one per line
+	var redirectURIs []string
+	for _, uri := range strings.Fields(input.RedirectURIs) {
+		redirectURIs = append(redirectURIs, uri)
+	}
+
+	client := model.OIDCClient{
+		Name:         input.Name,
+		ClientID:     clientID,
+		RedirectURIs: strings.Join(redirectURIs, "\n"),
+		SkipConsent:  input.SkipConsent,
+	}
+
+	var secret string
+	if !input.Public {
+		secret, err = randomToken()
+		if err != nil {
+			return model.OIDCClient{}, "", err
+		}
+		secretHash := hashToken(secret)
+		client.SecretHash = &secretHash
+	}
+
+	if err := p.db.Create(&client).Error; err != nil {
+		return model.OIDCClient{}, "", err
+	}
+	return client, secret, nil
+}
+
+// GetClient finds a client by its client_id
+func (p *Provider) GetClient(clientID string) (model.OIDCClient, error) {
+	client := model.OIDCClient{}
+	err := p.db.First(&client, "client_id = ?", clientID).Error
+	return client, err
+}
+
+// authenticateClient checks the client credentials of the token request.
+// Public clients send no secret, confidential clients must send theirs
+func (p *Provider) authenticateClient(clientID, clientSecret string) (model.OIDCClient, error) {
+	client, err := p.GetClient(clientID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return client, newError(ErrorInvalidClient, "unknown client")
+		}
+		return client, err
+	}
+	if client.IsPublic() {
+		return client, nil
+	}
+	if clientSecret == "" || subtle.ConstantTimeCompare([]byte(hashToken(clientSecret)), []byte(*client.SecretHash)) != 1 {
+		return client, newError(ErrorInvalidClient, "invalid client credentials")
+	}
+	return client, nil
+}
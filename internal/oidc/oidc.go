@@ -0,0 +1,138 @@
+package oidc
+
+import (
+	"atomic-go-template/internal/config"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Scopes supported by the provider
+var SupportedScopes = []string{"openid", "profile", "email"}
+
+// Provider is the OpenID Connect provider. It issues codes and tokens for the accounts of this app.
+type Provider struct {
+	db     *gorm.DB
+	config *config.Config
+	key    *rsa.PrivateKey
+	keyID  string
+}
+
+// NewProvider creates the provider with the signing key from OIDC_SIGNING_KEY_FILE.
+// If the variable is not set a temporary key is generated, tokens become invalid on restart
+func NewProvider(db *gorm.DB, config *config.Config) (*Provider, error) {
+	var key *rsa.PrivateKey
+	var err error
+	if keyFile := os.Getenv("OIDC_SIGNING_KEY_FILE"); keyFile != "" {
+		key, err = loadKey(keyFile)
+	} else {
+		fmt.Println("Warning: OIDC_SIGNING_KEY_FILE environment variable is not set")
+		fmt.Println("Generating a temporary signing key, issued tokens become invalid on restart")
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The key id is derived from the public key, so it changes with the key
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(publicKey)
+
+	return &Provider{
+		db:     db,
+		config: config,
+		key:    key,
+		keyID:  base64.RawURLEncoding.EncodeToString(sum[:8]),
+	}, nil
+}
+
+// loadKey reads a PEM encoded RSA private key in PKCS#1 or PKCS#8 format
+func loadKey(file string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("oidc: no PEM data in signing key file")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("oidc: signing key is not an RSA key")
+	}
+	return key, nil
+}
+
+// Issuer returns the issuer identifier, which is the URL of the app
+func (p *Provider) Issuer() string {
+	return strings.TrimSuffix(p.config.App.Url, "/")
+}
+
+// Discovery returns the OpenID Provider Metadata served at /.well-known/openid-configuration
+func (p *Provider) Discovery() map[string]interface{} {
+	issuer := p.Issuer()
+	return map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oidc/authorize",
+		"token_endpoint":                        issuer + "/oidc/token",
+		"userinfo_endpoint":                     issuer + "/oidc/userinfo",
+		"jwks_uri":                              issuer + "/oidc/jwks",
+		"scopes_supported":                      SupportedScopes,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "preferred_username", "picture"},
+	}
+}
+
+// JWKS returns the public signing keys served at /oidc/jwks
+func (p *Provider) JWKS() map[string]interface{} {
+	return map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": p.keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.PublicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.PublicKey.E)).Bytes()),
+		}},
+	}
+}
+
+// randomToken returns a random URL safe string for codes and secrets
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the SHA-256 of a code or secret as it is stored in the database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,195 @@
+package oidc
+
+import (
+	"atomic-go-template/internal/model"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// TokenRequest holds the parameters of a token request
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	ClientID     string
+	ClientSecret string
+	CodeVerifier string
+}
+
+// TokenResponse is the successful response of the token endpoint
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// AccessTokenClaims are the claims of the access tokens issued by the provider
+type AccessTokenClaims struct {
+	jwt.RegisteredClaims
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+}
+
+// Exchange redeems an authorization code for an access and id token
+func (p *Provider) Exchange(req TokenRequest) (*TokenResponse, error) {
+	if req.GrantType != "authorization_code" {
+		return nil, newError(ErrorUnsupportedGrantType, "only authorization_code is supported")
+	}
+	client, err := p.authenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	var response *TokenResponse
+	err = p.db.Transaction(func(tx *gorm.DB) error {
+		code := model.OIDCAuthorizationCode{}
+		if err := tx.First(&code, "code_hash = ?", hashToken(req.Code)).Error; err != nil {
+			return newError(ErrorInvalidGrant, "unknown authorization code")
+		}
+		// Codes can only be used once
+		if code.UsedAt != nil || time.Now().After(code.ExpiresAt) {
+			return newError(ErrorInvalidGrant, "authorization code expired")
+		}
+		if code.ClientID != client.ID || code.RedirectURI != req.RedirectURI {
+			return newError(ErrorInvalidGrant, "authorization code was issued for another client or redirect_uri")
+		}
+		if err := verifyCodeChallenge(code, req.CodeVerifier); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		result := tx.Model(&code).Where("used_at IS NULL").Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return newError(ErrorInvalidGrant, "authorization code expired")
+		}
+
+		user := model.User{}
+		if err := tx.First(&user, "id = ?", code.UserID).Error; err != nil {
+			return newError(ErrorInvalidGrant, "user not found")
+		}
+
+		response, err = p.issueTokens(client, user, code)
+		return err
+	})
+	return response, err
+}
+
+// verifyCodeChallenge checks the PKCE code verifier against the stored challenge
+func verifyCodeChallenge(code model.OIDCAuthorizationCode, verifier string) error {
+	if code.CodeChallenge == nil {
+		return nil
+	}
+	if verifier == "" {
+		return newError(ErrorInvalidGrant, "code_verifier is required")
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(*code.CodeChallenge)) != 1 {
+		return newError(ErrorInvalidGrant, "invalid code_verifier")
+	}
+	return nil
+}
+
+// issueTokens signs the access and id token for the user
+func (p *Provider) issueTokens(client model.OIDCClient, user model.User, code model.OIDCAuthorizationCode) (*TokenResponse, error) {
+	now := time.Now()
+	expiresAt := now.Add(p.config.OIDC.TokenLifetime)
+
+	accessToken := jwt.NewWithClaims(jwt.SigningMethodRS256, AccessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.Issuer(),
+			Subject:   user.ID.String(),
+			Audience:  jwt.ClaimStrings{client.ClientID},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		ClientID: client.ClientID,
+		Scope:    code.Scope,
+	})
+	accessToken.Header["kid"] = p.keyID
+	accessToken.Header["typ"] = "at+jwt"
+	signedAccessToken, err := accessToken.SignedString(p.key)
+	if err != nil {
+		return nil, err
+	}
+
+	idClaims := jwt.MapClaims{
+		"iss": p.Issuer(),
+		"sub": user.ID.String(),
+		"aud": client.ClientID,
+		"exp": expiresAt.Unix(),
+		"iat": now.Unix(),
+	}
+	if code.AuthTime != nil {
+		idClaims["auth_time"] = code.AuthTime.Unix()
+	}
+	if code.Nonce != nil {
+		idClaims["nonce"] = *code.Nonce
+	}
+	for key, value := range UserClaims(user, code.Scope) {
+		idClaims[key] = value
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, idClaims)
+	idToken.Header["kid"] = p.keyID
+	signedIDToken, err := idToken.SignedString(p.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken: signedAccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(p.config.OIDC.TokenLifetime.Seconds()),
+		IDToken:     signedIDToken,
+		Scope:       code.Scope,
+	}, nil
+}
+
+// VerifyAccessToken checks an access token issued by the provider and returns its claims
+func (p *Provider) VerifyAccessToken(tokenString string) (*AccessTokenClaims, error) {
+	claims := &AccessTokenClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// Only access tokens are accepted, not id tokens
+		if token.Header["typ"] != "at+jwt" {
+			return nil, errors.New("not an access token")
+		}
+		return &p.key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(p.Issuer()))
+	if err != nil || !token.Valid {
+		return nil, newError(ErrorInvalidToken, "invalid access token")
+	}
+	return claims, nil
+}
+
+// UserClaims returns the claims about the user allowed by the scope, used for the id token and userinfo
+func UserClaims(user model.User, scope string) map[string]interface{} {
+	scopes := strings.Fields(scope)
+	claims := map[string]interface{}{
+		"sub": user.ID.String(),
+	}
+	if slices.Contains(scopes, "email") {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.VerifiedAt != nil
+	}
+	if slices.Contains(scopes, "profile") {
+		claims["preferred_username"] = user.Username
+		claims["name"] = user.Username
+		if user.AvatarURL != nil {
+			claims["picture"] = *user.AvatarURL
+		}
+	}
+	return claims
+}
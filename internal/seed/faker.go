@@ -0,0 +1,102 @@
+package seed
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+var firstNames = []string{
+	"Emma", "Liam", "Olivia", "Noah", "Ava", "Elias", "Mia", "Lucas", "Sofia", "Mateo", "Hannah", "Leon", "Amelia", "Finn",
+	"Chloe", "Jonas", "Lea", "Ben", "Zoe", "Paul", "Nora", "Felix", "Clara", "Theo", "Ida", "Oskar", "Maya", "Henry",
+	"Aisha", "Yusuf", "Mei", "Hiroshi", "Priya", "Arjun", "Fatima", "Omar", "Lena", "Jakob", "Sara", "David",
+}
+
+var lastNames = []string{
+	"Smith", "Müller", "Garcia", "Johnson", "Schmidt", "Rossi", "Martin", "Fischer", "Lopez", "Weber", "Brown", "Meyer",
+	"Novak", "Kowalski", "Jansen", "Nielsen", "Tanaka", "Kim", "Patel", "Singh", "Nguyen", "Silva", "Costa", "Dubois",
+	"Wagner", "Becker", "Hoffmann", "Moreau", "Larsen", "Andersson", "O'Brien", "Murphy", "Cohen", "Haddad", "Yilmaz",
+}
+
+var mailDomains = []string{"example.org", "example.com", "example.net"}
+
+// Faker generates realistic fake data. The same seed generates the same data, so seeders find the rows of earlier runs
+type Faker struct {
+	rand *rand.Rand
+}
+
+func NewFaker(seed uint64) *Faker {
+	return &Faker{rand: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// fakerFor returns a faker seeded with the name, every seeder gets its own sequence
+func fakerFor(name string) *Faker {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	return NewFaker(hash.Sum64())
+}
+
+func (f *Faker) FirstName() string {
+	return pick(f, firstNames)
+}
+
+func (f *Faker) LastName() string {
+	return pick(f, lastNames)
+}
+
+// Username returns a username of 3 to 20 characters like the signup requires, the number keeps it unique
+func (f *Faker) Username(first, last string, number int) string {
+	name := strings.ToLower(first + "." + last)
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || r == '.' {
+			return r
+		}
+		return -1
+	}, name)
+	suffix := fmt.Sprintf("%d", number)
+	if len(name)+len(suffix) > 20 {
+		name = name[:20-len(suffix)]
+	}
+	return name + suffix
+}
+
+// Email returns an address on one of the example domains, which never receive mail
+func (f *Faker) Email(username string) string {
+	return username + "@" + pick(f, mailDomains)
+}
+
+// Chance returns true with the probability p between 0 and 1
+func (f *Faker) Chance(p float64) bool {
+	return f.rand.Float64() < p
+}
+
+// Before returns a time up to max before now
+func (f *Faker) Before(now time.Time, max time.Duration) time.Time {
+	if max <= 0 {
+		return now
+	}
+	return now.Add(-time.Duration(f.rand.Int64N(int64(max)))).Truncate(time.Second)
+}
+
+// AvatarURL returns a placeholder picture for the email. External URLs are shown like avatars of OAuth providers
+func (f *Faker) AvatarURL(email string) string {
+	return "https://i.pravatar.cc/300?u=" + email
+}
+
+// Digits returns a number with n digits, f.e. for IDs of OAuth providers
+func (f *Faker) Digits(n int) string {
+	digits := make([]byte, n)
+	for i := range digits {
+		digits[i] = byte('0' + f.rand.IntN(10))
+	}
+	if digits[0] == '0' {
+		digits[0] = '1'
+	}
+	return string(digits)
+}
+
+func pick[T any](f *Faker, values []T) T {
+	return values[f.rand.IntN(len(values))]
+}
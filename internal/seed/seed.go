@@ -0,0 +1,226 @@
+package seed
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/legal"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/store"
+	"atomic-go-template/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The password of the seeded users
+const Password = "password"
+
+// Seeder fills the database with data for development or tests.
+// Seeders have to be idempotent: running them again must not duplicate or change rows
+type Seeder struct {
+	Name        string
+	Description string
+	// Values of App.Env the seeder runs in
+	Environments []string
+	// The faker is seeded with the name of the seeder, it returns the same data on every run
+	Run func(ctx context.Context, db *gorm.DB, c *config.Config, fake *Faker) error
+}
+
+// Seeders run in this order. Add the seeders of your app here.
+// The seeded users share Password and fixed verify tokens, so they stay out of environments reachable by others
+var Seeders = []Seeder{
+	{
+		Name:         "admin",
+		Description:  "admin@example.org with the admin role",
+		Environments: []string{"local", "test"},
+		Run:          seedAdmin,
+	},
+	{
+		Name:         "examples",
+		Description:  "alice (verified, avatar), bob (unverified) and carol (GitHub login only) at example.org",
+		Environments: []string{"local", "test"},
+		Run:          seedExamples,
+	},
+	{
+		Name:         "fake-users",
+		Description:  "50 generated users, verified or not, with avatars and OAuth-only accounts",
+		Environments: []string{"local", "test"},
+		Run:          seedFakeUsers,
+	},
+}
+
+// Fixtures are named sets of seeders, f.e. for tests: seed.LoadFixture(ctx, db, c, "examples")
+var Fixtures = map[string][]string{
+	"admin":    {"admin"},
+	"examples": {"admin", "examples"},
+	"demo":     {"admin", "examples", "fake-users"},
+}
+
+var (
+	// ErrUnknownSeeder is returned for names that are not in Seeders or Fixtures
+	ErrUnknownSeeder = errors.New("seed: unknown seeder")
+	// ErrEnvironment is returned for seeders that don't run in App.Env, f.e. in production
+	ErrEnvironment = errors.New("seed: seeder does not run in this environment")
+)
+
+// Run runs the seeders with the names in the order of Seeders and returns the names of the seeders that ran.
+// Without names all seeders of App.Env run, named seeders of other environments return ErrEnvironment
+func Run(ctx context.Context, db *gorm.DB, c *config.Config, names ...string) ([]string, error) {
+	for _, name := range names {
+		seeder, ok := find(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownSeeder, name)
+		}
+		if !slices.Contains(seeder.Environments, c.App.Env) {
+			return nil, fmt.Errorf("%w: %s in %s", ErrEnvironment, name, c.App.Env)
+		}
+	}
+
+	var ran []string
+	for _, seeder := range Seeders {
+		if len(names) > 0 && !slices.Contains(names, seeder.Name) {
+			continue
+		}
+		if !slices.Contains(seeder.Environments, c.App.Env) {
+			continue
+		}
+		if err := seeder.Run(ctx, db.WithContext(ctx), c, fakerFor(seeder.Name)); err != nil {
+			return ran, fmt.Errorf("seed: %s: %w", seeder.Name, err)
+		}
+		ran = append(ran, seeder.Name)
+	}
+	return ran, nil
+}
+
+// LoadFixture runs the seeders of the fixture set
+func LoadFixture(ctx context.Context, db *gorm.DB, c *config.Config, name string) ([]string, error) {
+	names, ok := Fixtures[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: fixture %s", ErrUnknownSeeder, name)
+	}
+	return Run(ctx, db, c, names...)
+}
+
+func find(name string) (Seeder, bool) {
+	for _, seeder := range Seeders {
+		if seeder.Name == name {
+			return seeder, true
+		}
+	}
+	return Seeder{}, false
+}
+
+var passwordHash = sync.OnceValues(func() (string, error) {
+	// Hashing is slow on purpose, the users share the hash
+	return utils.HashPassword(Password)
+})
+
+// createUser creates the user unless the email or username exists and accepts the legal documents for it
+func createUser(ctx context.Context, db *gorm.DB, c *config.Config, user model.User) error {
+	users := store.NewGormUserStore(db)
+	if _, err := users.ByEmail(ctx, user.Email); err == nil {
+		return nil
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	err := users.Transaction(ctx, func(users store.UserStore) error {
+		if err := users.Create(ctx, &user); err != nil {
+			return err
+		}
+		if c.Legal.EnableLegal {
+			return legal.AcceptCurrent(ctx, users, user.ID, "127.0.0.1", c.Legal)
+		}
+		return nil
+	})
+	if errors.Is(err, store.ErrDuplicate) {
+		// The username was taken by a user of the app
+		return nil
+	}
+	return err
+}
+
+func seedAdmin(ctx context.Context, db *gorm.DB, c *config.Config, fake *Faker) error {
+	hash, err := passwordHash()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return createUser(ctx, db, c, model.User{
+		Username:   "admin",
+		Email:      "admin@example.org",
+		Password:   &hash,
+		VerifiedAt: &now,
+		Role:       model.RoleAdmin,
+	})
+}
+
+func seedExamples(ctx context.Context, db *gorm.DB, c *config.Config, fake *Faker) error {
+	hash, err := passwordHash()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	avatar := fake.AvatarURL("alice@example.org")
+	verifyAddress, verifyToken := "bob@example.org", "seeded-verify-token-bob"
+	provider, providerID := "github", fake.Digits(8)
+	users := []model.User{
+		{Username: "alice", Email: "alice@example.org", Password: &hash, VerifiedAt: &now, AvatarURL: &avatar, LastLoginAt: &now},
+		{Username: "bob", Email: "bob@example.org", Password: &hash, VerifyMailAddress: &verifyAddress, VerifyMailToken: &verifyToken},
+		{Username: "carol", Email: "carol@example.org", VerifiedAt: &now, OAuthProvider: &provider, OAuthID: &providerID},
+	}
+	for _, user := range users {
+		if err := createUser(ctx, db, c, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func seedFakeUsers(ctx context.Context, db *gorm.DB, c *config.Config, fake *Faker) error {
+	hash, err := passwordHash()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := 1; i <= 50; i++ {
+		first, last := fake.FirstName(), fake.LastName()
+		username := fake.Username(first, last, i)
+		user := model.User{Username: username, Email: fake.Email(username)}
+
+		// Draw every value on every run, so the following users stay the same
+		oauth, verified, avatar := fake.Chance(0.2), fake.Chance(0.75), fake.Chance(0.4)
+		provider, providerID := "google", fake.Digits(21)
+		if fake.Chance(0.5) {
+			provider, providerID = "github", fake.Digits(8)
+		}
+		joined := fake.Before(now, 365*24*time.Hour)
+		lastLogin := fake.Before(now, now.Sub(joined))
+		verifyToken := fmt.Sprintf("seeded-verify-token-%d", i)
+		// Users who never logged in are inactive by their signup, see broadcast.Recipients
+		user.CreatedAt = joined
+
+		switch {
+		case oauth:
+			// OAuth providers verify the email and there is no local password
+			user.OAuthProvider, user.OAuthID = &provider, &providerID
+			user.VerifiedAt, user.LastLoginAt = &joined, &lastLogin
+		case verified:
+			user.Password, user.VerifiedAt, user.LastLoginAt = &hash, &joined, &lastLogin
+		default:
+			address := user.Email
+			user.Password, user.VerifyMailAddress, user.VerifyMailToken = &hash, &address, &verifyToken
+		}
+		if avatar {
+			avatarURL := fake.AvatarURL(user.Email)
+			user.AvatarURL = &avatarURL
+		}
+		if err := createUser(ctx, db, c, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
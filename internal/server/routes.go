@@ -5,20 +5,42 @@ import (
 	"os"
 	"strings"
 
+	"atomic-go-template/internal/config"
 	mw "atomic-go-template/internal/middleware"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/store"
 	"atomic-go-template/web/components/theme"
 	"atomic-go-template/web/embed"
 	"atomic-go-template/web/routes"
+	"atomic-go-template/web/routes/admin/broadcasts"
+	database_backup "atomic-go-template/web/routes/admin/database_backup"
+	mail_outbox "atomic-go-template/web/routes/admin/mail_outbox"
+	oidc_clients "atomic-go-template/web/routes/admin/oidc_clients"
 	forget_password "atomic-go-template/web/routes/auth/forget_password"
 	"atomic-go-template/web/routes/auth/login"
 	"atomic-go-template/web/routes/auth/logout"
+	"atomic-go-template/web/routes/auth/reauthenticate"
 	reset_password "atomic-go-template/web/routes/auth/reset_password"
 	"atomic-go-template/web/routes/auth/signup"
 	verify_mail "atomic-go-template/web/routes/auth/verify-mail"
+	dev_mail "atomic-go-template/web/routes/dev/mail"
 	"atomic-go-template/web/routes/health"
+	"atomic-go-template/web/routes/legal"
+	"atomic-go-template/web/routes/legal/accept"
+	"atomic-go-template/web/routes/oidc/authorize"
+	"atomic-go-template/web/routes/oidc/discovery"
+	"atomic-go-template/web/routes/oidc/jwks"
+	"atomic-go-template/web/routes/oidc/token"
+	"atomic-go-template/web/routes/oidc/userinfo"
 	"atomic-go-template/web/routes/protected"
 	react_example "atomic-go-template/web/routes/react-example"
+	"atomic-go-template/web/routes/saml/acs"
+	saml_login "atomic-go-template/web/routes/saml/login"
+	"atomic-go-template/web/routes/saml/metadata"
+	"atomic-go-template/web/routes/unsubscribe"
 	"atomic-go-template/web/routes/user/profile"
+	"atomic-go-template/web/routes/user/profile/notifications"
+	mail_webhooks "atomic-go-template/web/routes/webhooks/mail"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -32,12 +54,23 @@ func (s *Server) RegisterRoutes() http.Handler {
 	// Create a new middleware instance for own middlewares
 	m := mw.NewMiddleware(s.db, s.validate, s.formDecoder, s.config)
 
+	// Handlers load and save users through the store
+	users := store.NewGormUserStore(s.db.GetDB())
+
 	// Add Config to Context
 	r.Use(m.ConfigMiddleware)
 
+	// Reads of a session go to the primary for a while after it changed data, so the replicas can catch up
+	if len(s.config.Database.Replicas) > 0 {
+		r.Use(m.ReadYourWrites)
+	}
+
 	// Checks for the JWT token in the cookie and sets the user data into the context
 	r.Use(m.JWTMiddleware)
 
+	// Logged in users have to accept new versions of the legal documents before continuing
+	r.Use(m.RequireLegalAcceptance)
+
 	// Serve static files without directory listing
 	fileServer := http.FileServer(NoListingFileSystem{http.FS(embed.Files)})
 	r.Handle("/assets/*", fileServer)
@@ -47,7 +80,7 @@ func (s *Server) RegisterRoutes() http.Handler {
 	r.Handle("/public/*", http.StripPrefix("/public", publicFileServer))
 
 	// Health Check
-	r.Get("/health", health.New(s.db, s.config).GET)
+	r.Get("/health", health.New(s.db, s.config, s.failover).GET)
 
 	// Home
 	r.Get("/", routes.GET)
@@ -57,6 +90,33 @@ func (s *Server) RegisterRoutes() http.Handler {
 
 	r.Get("/react-example", react_example.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail).GET)
 
+	// Legal Documents
+	if s.config.Legal.EnableLegal {
+		r.Get("/legal/terms", legal.New(s.config, model.LegalDocumentTerms).GET)
+		r.Get("/legal/privacy", legal.New(s.config, model.LegalDocumentPrivacy).GET)
+		r.Get("/legal/accept", m.IsLoggedIn(accept.New(users, s.config, s.validate, s.formDecoder).GET))
+		r.Post("/legal/accept", m.IsLoggedIn(accept.New(users, s.config, s.validate, s.formDecoder).POST))
+	}
+
+	// Development Inbox, only if the dev mail provider is used outside production
+	if s.devMail != nil && !s.config.IsProduction() {
+		r.Get("/dev/mail", dev_mail.New(s.devMail).GET)
+		r.Get("/dev/mail/{id}/html", dev_mail.New(s.devMail).HTML)
+		r.Get("/dev/mail/{id}/raw", dev_mail.New(s.devMail).Raw)
+		r.Get("/dev/mail/{id}/attachments/{index}", dev_mail.New(s.devMail).Attachment)
+		r.Post("/dev/mail/clear", dev_mail.New(s.devMail).Clear)
+	}
+
+	// Bounce and complaint webhooks of the mail providers
+	if s.config.Mail.Suppression.EnableSuppression {
+		if secret := s.config.Mail.Suppression.ResendWebhookSecret; secret != "" {
+			r.Post("/webhooks/resend", mail_webhooks.New(s.db.GetDB(), secret).Resend)
+		}
+		if secret := s.config.Mail.Suppression.WebhookSecret; secret != "" {
+			r.Post("/webhooks/mail", mail_webhooks.New(s.db.GetDB(), secret).Generic)
+		}
+	}
+
 	// Theme
 	if s.config.Theme.EnableThemeSwitcher {
 		r.Post("/theme", theme.New().POST)
@@ -66,31 +126,90 @@ func (s *Server) RegisterRoutes() http.Handler {
 		r.Route("/auth", func(r chi.Router) {
 			// Signup Routes
 			if s.config.Auth.EnableRegistration {
-				r.Get("/signup", signup.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail).GET)
-				r.Post("/signup", signup.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail).POST)
+				r.Get("/signup", signup.New(users, s.config, s.validate, s.formDecoder, s.mail).GET)
+				r.Post("/signup", signup.New(users, s.config, s.validate, s.formDecoder, s.mail).POST)
 			}
 			// Login Routes
 			if s.config.Auth.EnableLogin {
-				r.Get("/login", login.New(s.db.GetDB(), s.config, s.validate, s.formDecoder).GET)
-				r.Post("/login", login.New(s.db.GetDB(), s.config, s.validate, s.formDecoder).POST)
+				r.Get("/login", login.New(users, s.config, s.validate, s.formDecoder, s.auth, s.sso).GET)
+				r.Post("/login", login.New(users, s.config, s.validate, s.formDecoder, s.auth, s.sso).POST)
 				r.Get("/logout", logout.New().GET)
 			}
+			// Asks for the password again before sensitive actions, see RequireRecentAuth
+			r.Get("/reauthenticate", m.IsLoggedIn(reauthenticate.New(users, s.config, s.validate, s.formDecoder, s.auth, s.sso).GET))
+			r.Post("/reauthenticate", m.IsLoggedIn(reauthenticate.New(users, s.config, s.validate, s.formDecoder, s.auth, s.sso).POST))
 			// Reset Password Routes
 			if s.config.Auth.EnableResetPassword {
-				r.Get("/forget-password", forget_password.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail).GET)
-				r.Post("/forget-password", forget_password.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail).POST)
-				r.Get("/reset-password", reset_password.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail).GET)
-				r.Post("/reset-password", reset_password.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail).POST)
+				r.Get("/forget-password", forget_password.New(users, s.config, s.validate, s.formDecoder, s.mail).GET)
+				r.Post("/forget-password", forget_password.New(users, s.config, s.validate, s.formDecoder, s.mail).POST)
+				r.Get("/reset-password", reset_password.New(users, s.config, s.validate, s.formDecoder, s.mail).GET)
+				r.Post("/reset-password", reset_password.New(users, s.config, s.validate, s.formDecoder, s.mail).POST)
 			}
 			// Verify Email Routes
 			if s.config.Auth.EnableVerifyEmail {
-				r.Get("/verify-email", verify_mail.New(s.db.GetDB()).GET)
+				r.Get("/verify-email", verify_mail.New(users).GET)
 			}
 		}) // End of Auth Group
 
 		// Profile Routes
-		r.Get("/user/profile", m.IsLoggedIn(profile.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail).GET))
-		r.Post("/user/profile", m.IsLoggedIn(profile.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail).POST))
+		// Changing email and password requires a recent login, the handler checks it
+		r.Get("/user/profile", m.IsLoggedIn(profile.New(users, s.config, s.validate, s.formDecoder, s.mail).GET))
+		r.Post("/user/profile", m.IsLoggedIn(profile.New(users, s.config, s.validate, s.formDecoder, s.mail).POST))
+		r.Get("/user/profile/notifications", m.IsLoggedIn(notifications.New(s.db.GetDB(), s.config, s.formDecoder).GET))
+		r.Post("/user/profile/notifications", m.IsLoggedIn(notifications.New(s.db.GetDB(), s.config, s.formDecoder).POST))
+		// Signed links of notification mails, they work without login
+		r.Get("/unsubscribe", unsubscribe.New(s.db.GetDB()).GET)
+		r.Post("/unsubscribe", unsubscribe.New(s.db.GetDB()).POST)
+
+		// OpenID Connect Provider Routes
+		if s.config.OIDC.EnableOIDC {
+			r.Get("/.well-known/openid-configuration", discovery.New(s.oidc).GET)
+			r.Get("/oidc/jwks", jwks.New(s.oidc).GET)
+			r.Get("/oidc/authorize", authorize.New(s.config, s.oidc).GET)
+			r.Post("/oidc/authorize", authorize.New(s.config, s.oidc).POST)
+			r.Post("/oidc/token", token.New(s.oidc).POST)
+			r.Get("/oidc/userinfo", userinfo.New(users, s.oidc).GET)
+			r.Post("/oidc/userinfo", userinfo.New(users, s.oidc).POST)
+
+			// Admin Routes
+			r.Get("/admin/oidc-clients", m.IsLoggedIn(m.IsAdmin(oidc_clients.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.oidc).GET)))
+			r.Post("/admin/oidc-clients", m.IsLoggedIn(m.IsAdmin(oidc_clients.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.oidc).POST)))
+			r.Delete("/admin/oidc-clients/{id}", m.IsLoggedIn(m.IsAdmin(oidc_clients.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.oidc).DELETE)))
+		}
+
+		// Health with the errors of the mail providers
+		r.Get("/admin/health", m.IsLoggedIn(m.IsAdmin(health.New(s.db, s.config, s.failover).Admin)))
+
+		// Mail Outbox Admin Routes
+		if s.config.Mail.Outbox.EnableOutbox {
+			r.Get("/admin/mail-outbox", m.IsLoggedIn(m.IsAdmin(mail_outbox.New(s.db.GetDB(), s.config, s.outbox).GET)))
+			r.Post("/admin/mail-outbox/{id}/retry", m.IsLoggedIn(m.IsAdmin(mail_outbox.New(s.db.GetDB(), s.config, s.outbox).Retry)))
+		}
+
+		// Broadcast Admin Routes
+		if s.config.Mail.Broadcast.EnableBroadcast {
+			r.Get("/admin/broadcasts", m.IsLoggedIn(m.IsAdmin(broadcasts.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail, s.broadcaster).GET)))
+			r.Post("/admin/broadcasts", m.IsLoggedIn(m.IsAdmin(broadcasts.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail, s.broadcaster).POST)))
+			r.Get("/admin/broadcasts/progress", m.IsLoggedIn(m.IsAdmin(broadcasts.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail, s.broadcaster).Progress)))
+			r.Post("/admin/broadcasts/preview", m.IsLoggedIn(m.IsAdmin(broadcasts.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail, s.broadcaster).Preview)))
+			r.Post("/admin/broadcasts/test", m.IsLoggedIn(m.IsAdmin(broadcasts.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail, s.broadcaster).Test)))
+			r.Post("/admin/broadcasts/{id}/cancel", m.IsLoggedIn(m.IsAdmin(broadcasts.New(s.db.GetDB(), s.config, s.validate, s.formDecoder, s.mail, s.broadcaster).Cancel)))
+		}
+
+		// Database Backup Admin Routes
+		// The snapshot holds all data, so admins enter their password again
+		if s.config.Database.Enabled && s.config.Database.Type == config.DatabaseTypeSQLite {
+			r.Get("/admin/database-backup", m.IsLoggedIn(m.IsAdmin(database_backup.New(s.db.GetDB(), s.config, s.backups).GET)))
+			r.Get("/admin/database-backup/download", m.IsLoggedIn(m.IsAdmin(m.RequireRecentAuth(database_backup.New(s.db.GetDB(), s.config, s.backups).Download))))
+			r.Get("/admin/database-backup/{name}", m.IsLoggedIn(m.IsAdmin(m.RequireRecentAuth(database_backup.New(s.db.GetDB(), s.config, s.backups).File))))
+		}
+
+		// SAML Single Sign-On Routes
+		if s.config.SAML.EnableSAML {
+			r.Get("/saml/{idp}/metadata", metadata.New(s.sso).GET)
+			r.Get("/saml/{idp}/login", saml_login.New(s.sso).GET)
+			r.Post("/saml/{idp}/acs", acs.New(s.sso).POST)
+		}
 	} // End of Auth Feature Routes
 	return r
 }
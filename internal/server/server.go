@@ -1,20 +1,30 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-playground/form/v4"
 	"github.com/go-playground/validator/v10"
 	_ "github.com/joho/godotenv/autoload"
+	"gorm.io/gorm"
 
+	"atomic-go-template/internal/auth"
+	"atomic-go-template/internal/broadcast"
 	"atomic-go-template/internal/config"
 	"atomic-go-template/internal/database"
+	"atomic-go-template/internal/inbound"
 	"atomic-go-template/internal/mail"
+	"atomic-go-template/internal/oidc"
+	"atomic-go-template/internal/sso"
+	"atomic-go-template/internal/user"
+	"atomic-go-template/web/emails"
 )
 
 type Server struct {
@@ -29,9 +39,26 @@ type Server struct {
 	config *config.Config
 	// The mail service instance
 	mail mail.Service
+	// The OpenID Connect provider, nil if disabled
+	oidc *oidc.Provider
+	// Checks the login credentials against the database or directory
+	auth auth.Authenticator
+	// The SAML identity providers, nil if disabled
+	sso *sso.Manager
+	// The mail outbox, nil if disabled. Also used as the mail service
+	outbox *mail.Outbox
+	// The development inbox, nil unless MailProviderDev is used
+	devMail *mail.DevService
+	// The failover chain of mail providers, nil if a single provider is used
+	failover *mail.FailoverService
+	// The worker sending broadcasts, nil if disabled
+	broadcaster *broadcast.Worker
+	// The scheduled backups of SQLite, nil if disabled
+	backups *database.Backups
 }
 
-func NewServer() *http.Server {
+// NewConfig creates the config of the server, the migrate command uses it as well
+func NewConfig() *config.Config {
 	// Get Port from Environment Variables
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
 
@@ -39,13 +66,20 @@ func NewServer() *http.Server {
 	// Can be used in middleware and handler as well
 	// If you dont want to change values its safe to remove them here.
 	// Default values are set in config.go
-	config := config.New(&config.Config{
+	return config.New(&config.Config{
 		Server: config.Server{
 			Port: port,
 		},
 		Database: config.Database{
-			Enabled: true,
-			Type:    config.DatabaseTypeSQLite,
+			Enabled:     true,
+			Type:        config.DatabaseTypeSQLite,
+			AutoMigrate: true,
+			SQLite: config.SQLite{
+				ForeignKeys: true,
+			},
+			Backup: config.DatabaseBackup{
+				EnableBackup: false,
+			},
 		},
 		Theme: config.Theme{
 			StandardTheme:       "",
@@ -63,17 +97,65 @@ func NewServer() *http.Server {
 		Mail: config.Mail{
 			EnableMail:   true,
 			MailProvider: config.MailProviderConsole,
+			DKIM: config.DKIM{
+				EnableDKIM: false,
+			},
+			Outbox: config.Outbox{
+				EnableOutbox: true,
+			},
+			Suppression: config.Suppression{
+				EnableSuppression: true,
+			},
+			Inbound: config.Inbound{
+				EnableInbound: false,
+			},
+			Broadcast: config.Broadcast{
+				EnableBroadcast: true,
+			},
+		},
+		Legal: config.Legal{
+			EnableLegal:    true,
+			TermsVersion:   "2024-08-01",
+			PrivacyVersion: "2024-08-01",
+		},
+		OIDC: config.OIDC{
+			EnableOIDC: false,
+		},
+		LDAP: config.LDAP{
+			EnableLDAP:      false,
+			AllowLocalLogin: true,
+			StartTLS:        true,
+		},
+		SAML: config.SAML{
+			EnableSAML: false,
 		},
 	})
+}
+
+func NewServer() *http.Server {
+	config := NewConfig()
 
 	// Create database service
-	db := database.New(config.Database)
-	// Automigrate
-	database.MigrateUserSchema(db.GetDB())
+	db, err := database.New(context.Background(), config.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Apply the pending migrations, see cmd/migrate
+	if config.Database.Enabled {
+		if err := migrate(db.GetDB(), config.Database.AutoMigrate); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Give the admin role to the users listed in ADMIN_EMAILS
+	if adminEmails := os.Getenv("ADMIN_EMAILS"); adminEmails != "" && config.Database.Enabled {
+		if err := user.SyncAdmins(db.GetDB(), strings.Split(adminEmails, ",")); err != nil {
+			log.Printf("Error syncing admins: %v", err)
+		}
+	}
 
 	// Mail Service
 	var mailService mail.Service
-	var err error
 	if config.Mail.EnableMail {
 		mailService, err = mail.NewMailProvider(config.Mail)
 		if err != nil {
@@ -81,6 +163,79 @@ func NewServer() *http.Server {
 		}
 	}
 
+	// The development inbox shows the mails caught by the dev provider
+	devMail, _ := mailService.(*mail.DevService)
+	// The health endpoint reports the state of the providers in the chain
+	failover, _ := mailService.(*mail.FailoverService)
+
+	// Addresses that bounced or complained don't get mails anymore
+	if config.Mail.Suppression.EnableSuppression {
+		mailService = mail.NewSuppressionFilter(db.GetDB(), mailService)
+	}
+
+	// Mail Outbox
+	// Handlers queue their mails, the worker delivers them with retries
+	var outbox *mail.Outbox
+	if config.Mail.Outbox.EnableOutbox {
+		outbox = mail.NewOutbox(db.GetDB(), mailService, mail.OutboxOptionsFromConfig(config.Mail.Outbox))
+		go outbox.Run(database.WithPrimary(context.Background()))
+		mailService = outbox
+	}
+
+	// Broadcasts of admins, sent in batches by a background worker through the mail service
+	var broadcaster *broadcast.Worker
+	if config.Mail.Broadcast.EnableBroadcast {
+		broadcaster = broadcast.NewWorker(db.GetDB(), mailService, emails.New(config, mailService).RenderBroadcast, broadcast.OptionsFromConfig(config.Mail.Broadcast))
+		go broadcaster.Run(database.WithPrimary(context.Background()))
+	}
+
+	// Scheduled backups of the SQLite database to a local directory, old ones are deleted
+	var backups *database.Backups
+	if config.Database.Backup.EnableBackup {
+		backups = database.NewBackups(db.GetDB(), database.BackupOptionsFromConfig(config.Database.Backup))
+		go backups.Run(context.Background())
+	}
+
+	// Inbound Mail
+	// Replies to inbound.ReplyAddress are dispatched by the kind of their context.
+	// Register the handlers of your app here, f.e. receiver.Handle("ticket", tickets.HandleReply)
+	if config.Mail.Inbound.EnableInbound {
+		options, err := inbound.OptionsFromConfig(config.Mail.Inbound)
+		if err != nil {
+			log.Fatal(err)
+		}
+		receiver := inbound.NewReceiver(options)
+		go func() {
+			if err := receiver.ListenAndServe(); err != nil {
+				log.Printf("Error receiving mail: %v", err)
+			}
+		}()
+	}
+
+	// OpenID Connect Provider
+	var oidcProvider *oidc.Provider
+	if config.OIDC.EnableOIDC {
+		oidcProvider, err = oidc.NewProvider(db.GetDB(), config)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Authentication against the database and optionally LDAP
+	authenticator, err := auth.New(db.GetDB(), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// SAML Single Sign-On
+	var ssoManager *sso.Manager
+	if config.SAML.EnableSAML {
+		ssoManager, err = sso.NewFromConfig(context.Background(), db.GetDB(), config)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// Create server struct
 	NewServer := &Server{
 		port:        config.Server.Port,
@@ -89,6 +244,14 @@ func NewServer() *http.Server {
 		formDecoder: form.NewDecoder(),
 		config:      config,
 		mail:        mailService,
+		oidc:        oidcProvider,
+		auth:        authenticator,
+		sso:         ssoManager,
+		outbox:      outbox,
+		devMail:     devMail,
+		failover:    failover,
+		broadcaster: broadcaster,
+		backups:     backups,
 	}
 
 	// Declare Server config
@@ -102,3 +265,24 @@ func NewServer() *http.Server {
 	fmt.Printf("Server is running on port: %d", NewServer.port)
 	return server
 }
+
+// migrate applies the pending migrations, or only warns about them if automatic migrations are disabled
+func migrate(db *gorm.DB, apply bool) error {
+	migrator, err := database.NewMigrator(db, database.MigratorOptions{})
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if !apply {
+		pending, err := migrator.Pending(ctx)
+		if err == nil && pending > 0 {
+			log.Printf("Warning: %d migrations are pending, run `go run ./cmd/migrate up`", pending)
+		}
+		return err
+	}
+	applied, err := migrator.Up(ctx)
+	for _, migration := range applied {
+		log.Printf("Applied migration %d_%s", migration.Version, migration.Name)
+	}
+	return err
+}
@@ -0,0 +1,356 @@
+// Package sso lets users log in with SAML 2.0 identity providers.
+// This app is the service provider, every configured identity provider gets its own metadata and ACS URL
+package sso
+
+import (
+	"atomic-go-template/internal/auth"
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/store"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	dsig "github.com/russellhaering/goxmldsig"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrUnknownProvider is returned for identity provider names that are not configured
+	ErrUnknownProvider = errors.New("sso: unknown identity provider")
+	// ErrForeignDomain is returned for assertions with an email outside the domains of the identity provider
+	ErrForeignDomain = errors.New("sso: email domain is not served by the identity provider")
+)
+
+// IdentityProviderOptions describe one identity provider and how its attributes map to users
+type IdentityProviderOptions struct {
+	// Used in the URLs, f.e. "okta" for /saml/okta/acs
+	Name string
+	// The parsed metadata of the identity provider
+	Metadata *saml.EntityDescriptor
+	// Email domains that log in with this identity provider. If set, assertions with other emails are rejected
+	Domains []string
+	// Attribute holding the email. The NameID is used if empty
+	EmailAttribute string
+	// Attribute used as username for new users. The local part of the email is used if empty
+	UsernameAttribute string
+	// Attribute listing the groups of the user
+	GroupAttribute string
+	// Maps lower case groups to roles
+	GroupRoles map[string]model.Role
+	// Link existing accounts with the email on the first login, f.e. users who signed up with a password.
+	// Otherwise these users can't log in with the identity provider
+	LinkAccounts bool
+}
+
+// IdentityProvider is a configured identity provider together with the service provider talking to it
+type IdentityProvider struct {
+	options IdentityProviderOptions
+	sp      *saml.ServiceProvider
+}
+
+// Manager holds all configured identity providers
+type Manager struct {
+	db        *gorm.DB
+	providers map[string]*IdentityProvider
+	// Lower case email domain to identity provider
+	domains map[string]*IdentityProvider
+}
+
+// New creates the service providers for the identity providers.
+// baseURL is the public URL of the app, key and certificate sign the requests and decrypt assertions
+func New(db *gorm.DB, baseURL string, key *rsa.PrivateKey, certificate *x509.Certificate, identityProviders []IdentityProviderOptions) (*Manager, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("sso: invalid base url: %w", err)
+	}
+
+	m := &Manager{
+		db:        db,
+		providers: make(map[string]*IdentityProvider, len(identityProviders)),
+		domains:   map[string]*IdentityProvider{},
+	}
+	for _, options := range identityProviders {
+		if options.Name == "" || url.PathEscape(options.Name) != options.Name {
+			return nil, fmt.Errorf("sso: invalid identity provider name %q", options.Name)
+		}
+		if _, ok := m.providers[options.Name]; ok {
+			return nil, fmt.Errorf("sso: duplicate identity provider %q", options.Name)
+		}
+		if options.Metadata == nil {
+			return nil, fmt.Errorf("sso: identity provider %q has no metadata", options.Name)
+		}
+
+		metadataURL := base.JoinPath("saml", options.Name, "metadata")
+		acsURL := base.JoinPath("saml", options.Name, "acs")
+		provider := &IdentityProvider{
+			options: options,
+			sp: &saml.ServiceProvider{
+				EntityID:          metadataURL.String(),
+				Key:               key,
+				Certificate:       certificate,
+				MetadataURL:       *metadataURL,
+				AcsURL:            *acsURL,
+				IDPMetadata:       options.Metadata,
+				SignatureMethod:   dsig.RSASHA256SignatureMethod,
+				AllowIDPInitiated: false,
+			},
+		}
+		m.providers[options.Name] = provider
+
+		for _, domain := range options.Domains {
+			domain = strings.ToLower(domain)
+			if other, ok := m.domains[domain]; ok {
+				return nil, fmt.Errorf("sso: domain %s is used by %q and %q", domain, other.options.Name, options.Name)
+			}
+			m.domains[domain] = provider
+		}
+	}
+	return m, nil
+}
+
+// NewFromConfig reads the service provider key and certificate from SAML_SP_KEY_FILE and SAML_SP_CERT_FILE
+// and loads the metadata of the configured identity providers
+func NewFromConfig(ctx context.Context, db *gorm.DB, c *config.Config) (*Manager, error) {
+	key, err := readKey(os.Getenv("SAML_SP_KEY_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := readCertificate(os.Getenv("SAML_SP_CERT_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	identityProviders := make([]IdentityProviderOptions, 0, len(c.SAML.IdentityProviders))
+	for _, idp := range c.SAML.IdentityProviders {
+		metadata, err := loadMetadata(ctx, idp)
+		if err != nil {
+			return nil, fmt.Errorf("sso: loading metadata of %q: %w", idp.Name, err)
+		}
+		groupRoles := make(map[string]model.Role, len(idp.GroupRoles))
+		for group, role := range idp.GroupRoles {
+			groupRoles[strings.ToLower(group)] = model.Role(role)
+		}
+		identityProviders = append(identityProviders, IdentityProviderOptions{
+			Name:              idp.Name,
+			Metadata:          metadata,
+			Domains:           idp.Domains,
+			EmailAttribute:    idp.EmailAttribute,
+			UsernameAttribute: idp.UsernameAttribute,
+			GroupAttribute:    idp.GroupAttribute,
+			GroupRoles:        groupRoles,
+			LinkAccounts:      idp.LinkAccounts,
+		})
+	}
+	return New(db, c.App.Url, key, certificate, identityProviders)
+}
+
+// Provider returns the identity provider with the name
+func (m *Manager) Provider(name string) (*IdentityProvider, error) {
+	provider, ok := m.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// ProviderForEmail returns the identity provider responsible for the domain of the email, if there is one
+func (m *Manager) ProviderForEmail(email string) (*IdentityProvider, bool) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return nil, false
+	}
+	provider, ok := m.domains[strings.ToLower(email[at+1:])]
+	return provider, ok
+}
+
+func (p *IdentityProvider) Name() string {
+	return p.options.Name
+}
+
+// Metadata returns the service provider metadata to register at the identity provider
+func (p *IdentityProvider) Metadata() ([]byte, error) {
+	return xml.MarshalIndent(p.sp.Metadata(), "", "  ")
+}
+
+// AuthenticationRequest returns the URL of the identity provider to send the user to.
+// The request ID has to be passed to Authenticate to check the response belongs to this request
+func (p *IdentityProvider) AuthenticationRequest(relayState string) (redirectURL string, requestID string, err error) {
+	request, err := p.sp.MakeAuthenticationRequest(p.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", "", err
+	}
+	// The relay state is added to the query without escaping
+	redirect, err := request.Redirect(url.QueryEscape(relayState), p.sp)
+	if err != nil {
+		return "", "", err
+	}
+	return redirect.String(), request.ID, nil
+}
+
+// Authenticate validates the SAML response posted to the ACS URL and returns the local user.
+// The signature, audience, destination, validity and request ID are checked.
+// Users are created on their first login
+func (m *Manager) Authenticate(r *http.Request, provider *IdentityProvider, requestIDs []string) (model.User, error) {
+	if err := r.ParseForm(); err != nil {
+		return model.User{}, err
+	}
+	// Artifact resolution is not supported, only the POST binding
+	if r.Form.Get("SAMLResponse") == "" {
+		return model.User{}, errors.New("sso: missing SAMLResponse")
+	}
+	assertion, err := provider.sp.ParseResponse(r, requestIDs)
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) && invalid.PrivateErr != nil {
+			return model.User{}, fmt.Errorf("sso: invalid response: %w", invalid.PrivateErr)
+		}
+		return model.User{}, fmt.Errorf("sso: invalid response: %w", err)
+	}
+
+	external, err := provider.externalUser(assertion)
+	if err != nil {
+		return model.User{}, err
+	}
+	user, err := auth.Provision(r.Context(), m.db, external)
+	if err != nil {
+		return model.User{}, err
+	}
+	if err := store.NewGormUserStore(m.db).RecordLogin(r.Context(), user.ID); err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// externalUser maps the attributes of the assertion to a user
+func (p *IdentityProvider) externalUser(assertion *saml.Assertion) (auth.ExternalUser, error) {
+	nameID := ""
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		nameID = assertion.Subject.NameID.Value
+	}
+
+	email := nameID
+	if p.options.EmailAttribute != "" {
+		email = firstValue(attributeValues(assertion, p.options.EmailAttribute))
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return auth.ExternalUser{}, fmt.Errorf("sso: assertion of %q has no email", p.options.Name)
+	}
+	// Accounts are linked by email, an identity provider must not vouch for addresses of other domains
+	if len(p.options.Domains) > 0 && !slices.ContainsFunc(p.options.Domains, func(domain string) bool {
+		return strings.EqualFold(domain, email[at+1:])
+	}) {
+		return auth.ExternalUser{}, fmt.Errorf("%w: %s at %q", ErrForeignDomain, email, p.options.Name)
+	}
+	id := nameID
+	if id == "" {
+		id = email
+	}
+
+	external := auth.ExternalUser{
+		Provider:     "saml:" + p.options.Name,
+		ID:           id,
+		Email:        email,
+		LinkExisting: p.options.LinkAccounts,
+	}
+	if p.options.UsernameAttribute != "" {
+		external.Username = firstValue(attributeValues(assertion, p.options.UsernameAttribute))
+	}
+	if len(p.options.GroupRoles) > 0 {
+		role := auth.RoleForGroups(attributeValues(assertion, p.options.GroupAttribute), p.options.GroupRoles)
+		external.Role = &role
+	}
+	return external, nil
+}
+
+// attributeValues returns the values of the attribute, matched by name or friendly name
+func attributeValues(assertion *saml.Assertion, name string) []string {
+	values := []string{}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if attribute.Name != name && attribute.FriendlyName != name {
+				continue
+			}
+			for _, value := range attribute.Values {
+				values = append(values, value.Value)
+			}
+		}
+	}
+	return values
+}
+
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// loadMetadata reads the identity provider metadata from the file or URL
+func loadMetadata(ctx context.Context, idp config.SAMLIdentityProvider) (*saml.EntityDescriptor, error) {
+	if idp.MetadataFile != "" {
+		data, err := os.ReadFile(idp.MetadataFile)
+		if err != nil {
+			return nil, err
+		}
+		return samlsp.ParseMetadata(data)
+	}
+	if idp.MetadataURL != "" {
+		metadataURL, err := url.Parse(idp.MetadataURL)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return samlsp.FetchMetadata(ctx, http.DefaultClient, *metadataURL)
+	}
+	return nil, errors.New("MetadataURL or MetadataFile is required")
+}
+
+func readKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sso: reading key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("sso: no PEM block in key file")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("sso: parsing key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("sso: key has to be an RSA key")
+	}
+	return key, nil
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sso: reading certificate: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("sso: no PEM block in certificate file")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"atomic-go-template/internal/model"
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryUserStore keeps the users in memory, f.e. for handler tests without a database.
+// It checks the unique username and email like the users table
+type MemoryUserStore struct {
+	mu          sync.Mutex
+	users       map[uuid.UUID]model.User
+	acceptances []model.LegalAcceptance
+}
+
+func NewMemoryUserStore(users ...model.User) *MemoryUserStore {
+	s := &MemoryUserStore{users: map[uuid.UUID]model.User{}}
+	for _, user := range users {
+		s.Create(context.Background(), &user)
+	}
+	return s
+}
+
+func (s *MemoryUserStore) ByID(ctx context.Context, id uuid.UUID) (model.User, error) {
+	return s.find(func(user model.User) bool { return user.ID == id })
+}
+
+func (s *MemoryUserStore) ByEmail(ctx context.Context, email string) (model.User, error) {
+	return s.find(func(user model.User) bool { return user.Email == email })
+}
+
+func (s *MemoryUserStore) ByPasswordResetToken(ctx context.Context, token string) (model.User, error) {
+	return s.find(func(user model.User) bool {
+		return user.PasswordResetToken != nil && *user.PasswordResetToken == token
+	})
+}
+
+func (s *MemoryUserStore) ByVerifyMailToken(ctx context.Context, token string) (model.User, error) {
+	return s.find(func(user model.User) bool {
+		return user.VerifyMailToken != nil && *user.VerifyMailToken == token
+	})
+}
+
+func (s *MemoryUserStore) Create(ctx context.Context, user *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user.ID == uuid.Nil {
+		user.ID = uuid.New()
+	}
+	if _, ok := s.users[user.ID]; ok || s.taken(*user) {
+		return ErrDuplicate
+	}
+	now := time.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	// The default of the column
+	if user.Role == "" {
+		user.Role = model.RoleUser
+	}
+	s.users[user.ID] = *user
+	return nil
+}
+
+func (s *MemoryUserStore) Update(ctx context.Context, user *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.taken(*user) {
+		return ErrDuplicate
+	}
+	user.CreatedAt = existing.CreatedAt
+	user.UpdatedAt = time.Now()
+	s.users[user.ID] = *user
+	return nil
+}
+
+func (s *MemoryUserStore) RecordLogin(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		// Like the UPDATE of the users table
+		return nil
+	}
+	now := time.Now()
+	user.LastLoginAt = &now
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryUserStore) LegalAcceptances(ctx context.Context, userID uuid.UUID) ([]model.LegalAcceptance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var acceptances []model.LegalAcceptance
+	for _, acceptance := range s.acceptances {
+		if acceptance.UserID == userID {
+			acceptances = append(acceptances, acceptance)
+		}
+	}
+	return acceptances, nil
+}
+
+func (s *MemoryUserStore) AcceptLegal(ctx context.Context, acceptances []model.LegalAcceptance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, acceptance := range acceptances {
+		accepted := func(other model.LegalAcceptance) bool {
+			return other.UserID == acceptance.UserID && other.Document == acceptance.Document && other.Version == acceptance.Version
+		}
+		if slices.ContainsFunc(s.acceptances, accepted) {
+			continue
+		}
+		if acceptance.ID == uuid.Nil {
+			acceptance.ID = uuid.New()
+		}
+		s.acceptances = append(s.acceptances, acceptance)
+	}
+	return nil
+}
+
+// Transaction restores the users and acceptances if fn fails. Unlike a database transaction it does not hide the
+// changes of fn from concurrent calls
+func (s *MemoryUserStore) Transaction(ctx context.Context, fn func(users UserStore) error) error {
+	s.mu.Lock()
+	users := maps.Clone(s.users)
+	acceptances := slices.Clone(s.acceptances)
+	s.mu.Unlock()
+	if err := fn(s); err != nil {
+		s.mu.Lock()
+		s.users = users
+		s.acceptances = acceptances
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (s *MemoryUserStore) find(match func(user model.User) bool) (model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if match(user) {
+			return user, nil
+		}
+	}
+	return model.User{}, ErrNotFound
+}
+
+// taken reports whether another user has the username or email of the user
+func (s *MemoryUserStore) taken(user model.User) bool {
+	for _, other := range s.users {
+		if other.ID != user.ID && (other.Username == user.Username || other.Email == user.Email) {
+			return true
+		}
+	}
+	return false
+}
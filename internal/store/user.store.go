@@ -0,0 +1,131 @@
+package store
+
+import (
+	"atomic-go-template/internal/database"
+	"atomic-go-template/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrNotFound is returned if no user matches
+	ErrNotFound = errors.New("store: user not found")
+	// ErrDuplicate is returned if the username or email is taken by another user
+	ErrDuplicate = errors.New("store: username or email already exists")
+)
+
+// UserStore loads and saves users, so handlers don't depend on the database.
+// The context is passed to the queries, reads of a session follow it to the primary (see database.WithPrimary)
+type UserStore interface {
+	ByID(ctx context.Context, id uuid.UUID) (model.User, error)
+	ByEmail(ctx context.Context, email string) (model.User, error)
+	ByPasswordResetToken(ctx context.Context, token string) (model.User, error)
+	ByVerifyMailToken(ctx context.Context, token string) (model.User, error)
+	// Create sets the ID of new users
+	Create(ctx context.Context, user *model.User) error
+	// Update saves all fields of the user, load it first to keep the others
+	Update(ctx context.Context, user *model.User) error
+	// RecordLogin sets the last login of the user to now, f.e. for the inactive segment of broadcasts
+	RecordLogin(ctx context.Context, id uuid.UUID) error
+	// LegalAcceptances returns the versions of the legal documents the user accepted
+	LegalAcceptances(ctx context.Context, userID uuid.UUID) ([]model.LegalAcceptance, error)
+	// AcceptLegal records the acceptances of legal documents, versions the user accepted before are skipped
+	AcceptLegal(ctx context.Context, acceptances []model.LegalAcceptance) error
+	// Transaction runs fn with a store whose changes are kept only if fn returns nil
+	Transaction(ctx context.Context, fn func(users UserStore) error) error
+}
+
+// GormUserStore keeps the users in the users table
+type GormUserStore struct {
+	db *gorm.DB
+}
+
+func NewGormUserStore(db *gorm.DB) *GormUserStore {
+	return &GormUserStore{db: db}
+}
+
+func (s *GormUserStore) ByID(ctx context.Context, id uuid.UUID) (model.User, error) {
+	return s.first(ctx, "id = ?", id)
+}
+
+func (s *GormUserStore) ByEmail(ctx context.Context, email string) (model.User, error) {
+	return s.first(ctx, "email = ?", email)
+}
+
+func (s *GormUserStore) ByPasswordResetToken(ctx context.Context, token string) (model.User, error) {
+	return s.first(ctx, "password_reset_token = ?", token)
+}
+
+func (s *GormUserStore) ByVerifyMailToken(ctx context.Context, token string) (model.User, error) {
+	return s.first(ctx, "verify_mail_token = ?", token)
+}
+
+func (s *GormUserStore) Create(ctx context.Context, user *model.User) error {
+	return storeError(s.db.WithContext(ctx).Create(user).Error)
+}
+
+func (s *GormUserStore) Update(ctx context.Context, user *model.User) error {
+	result := s.db.WithContext(ctx).Model(user).Select("*").Omit("created_at").Updates(user)
+	if result.Error != nil {
+		return storeError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *GormUserStore) RecordLogin(ctx context.Context, id uuid.UUID) error {
+	return s.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", id).Update("last_login_at", time.Now()).Error
+}
+
+func (s *GormUserStore) LegalAcceptances(ctx context.Context, userID uuid.UUID) ([]model.LegalAcceptance, error) {
+	var acceptances []model.LegalAcceptance
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).Find(&acceptances).Error
+	return acceptances, err
+}
+
+func (s *GormUserStore) AcceptLegal(ctx context.Context, acceptances []model.LegalAcceptance) error {
+	if len(acceptances) == 0 {
+		return nil
+	}
+	// Double submits of the acceptance form race between PendingDocuments and the insert
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "document"}, {Name: "version"}},
+		DoNothing: true,
+	}).Create(&acceptances).Error
+}
+
+func (s *GormUserStore) Transaction(ctx context.Context, fn func(users UserStore) error) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&GormUserStore{db: tx})
+	})
+}
+
+func (s *GormUserStore) first(ctx context.Context, query string, value interface{}) (model.User, error) {
+	user := model.User{}
+	if err := s.db.WithContext(ctx).First(&user, query, value).Error; err != nil {
+		return model.User{}, storeError(err)
+	}
+	return user, nil
+}
+
+// storeError maps the errors of the database to the errors of the store
+func storeError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case database.IsUniqueViolation(err):
+		return fmt.Errorf("%w: %w", ErrDuplicate, err)
+	default:
+		return err
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"atomic-go-template/internal/middleware"
 	"atomic-go-template/internal/model"
 	"net/http"
+
+	"gorm.io/gorm"
 )
 
 func GetUserFromContext(r *http.Request) model.User {
@@ -13,3 +15,12 @@ func GetUserFromContext(r *http.Request) model.User {
 	}
 	return user
 }
+
+// SyncAdmins gives the admin role to the users with the given email addresses.
+// It is called on startup with the ADMIN_EMAILS environment variable
+func SyncAdmins(db *gorm.DB, emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	return db.Model(&model.User{}).Where("email IN ?", emails).Update("role", model.RoleAdmin).Error
+}
@@ -10,13 +10,23 @@ import (
 
 var jwtKey = []byte(os.Getenv("SECRET_KEY"))
 
+// JWTClaims are the claims stored in the auth cookie
+type JWTClaims struct {
+	jwt.RegisteredClaims
+	// AuthTime is the time the user last entered their credentials
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+}
+
 // CreateJWTCookie creates a JWT token and sets it as a cookie
 func CreateJWTCookie(w http.ResponseWriter, userID string) error {
 	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(expirationTime),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		Subject:   userID,
+	claims := &JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Subject:   userID,
+		},
+		AuthTime: jwt.NewNumericDate(time.Now()),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -40,24 +50,33 @@ func CreateJWTCookie(w http.ResponseWriter, userID string) error {
 
 // VerifyJWTCookie verifies the JWT token from the cookie
 func VerifyJWTCookie(r *http.Request) (string, error) {
-	cookie, err := r.Cookie("auth_token")
+	claims, err := GetJWTClaims(r)
 	if err != nil {
 		return "", err
 	}
+	return claims.Subject, nil
+}
 
-	token, err := jwt.ParseWithClaims(cookie.Value, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+// GetJWTClaims verifies the JWT token from the cookie and returns its claims
+func GetJWTClaims(r *http.Request) (*JWTClaims, error) {
+	cookie, err := r.Cookie("auth_token")
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(cookie.Value, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return jwtKey, nil
 	})
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok && token.Valid {
-		return claims.Subject, nil
+	if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
+		return claims, nil
 	}
 
-	return "", jwt.ErrSignatureInvalid
+	return nil, jwt.ErrSignatureInvalid
 }
 
 func DeleteJWTCookie(w http.ResponseWriter) {
@@ -0,0 +1,12 @@
+package utils
+
+import "strings"
+
+// SafeRedirectPath returns the path if it is a local path, otherwise the fallback.
+// Use it for user supplied redirect targets like ?next= to prevent open redirects
+func SafeRedirectPath(path, fallback string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return fallback
+	}
+	return path
+}
@@ -17,8 +17,10 @@ func MsgForTag(fe validator.FieldError) string {
 		return fmt.Sprintf("%s must be at least %s characters long", fe.Field(), fe.Param())
 	case "max":
 		return fmt.Sprintf("%s must be at most %s characters long", fe.Field(), fe.Param())
-	case "required_with":
+	case "required_with", "required_if":
 		return fmt.Sprintf("%s is required", fe.Field())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of %s", fe.Field(), fe.Param())
 	}
 	return fe.Error() // default error
 }
@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/database"
+	"atomic-go-template/internal/model"
+	database_backup "atomic-go-template/web/routes/admin/database_backup"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// newFileDB opens a migrated SQLite file in WAL mode, backups of in-memory databases are not useful
+func newFileDB(t *testing.T, file string) (database.Service, *gorm.DB) {
+	service, err := database.NewSQLiteService(context.Background(), config.Database{
+		File:   file,
+		SQLite: config.SQLite{JournalMode: "WAL", BusyTimeout: 5 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("error opening database. Err: %v", err)
+	}
+	db := service.GetDB()
+	if err := database.Migrate(context.Background(), db); err != nil {
+		t.Fatalf("error migrating database. Err: %v", err)
+	}
+	return service, db
+}
+
+func countUsers(t *testing.T, file string) int64 {
+	service, db := newFileDB(t, file)
+	defer service.Close()
+	var count int64
+	if err := db.Model(&model.User{}).Count(&count).Error; err != nil {
+		t.Fatalf("error counting users. Err: %v", err)
+	}
+	return count
+}
+
+func TestBackupWhileWriting(t *testing.T) {
+	dir := t.TempDir()
+	service, db := newFileDB(t, filepath.Join(dir, "app.sqlite"))
+	defer service.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		db.Create(&model.User{Username: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.org", i)})
+	}
+	// The app keeps writing during the backup
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 20; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				db.Create(&model.User{Username: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.org", i)})
+			}
+		}
+	}()
+	backup := filepath.Join(dir, "backups", "manual.sqlite")
+	err := database.BackupSQLite(ctx, db, backup)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("error backing up. Err: %v", err)
+	}
+
+	if count := countUsers(t, backup); count < 20 {
+		t.Errorf("expected the backup to have at least the first 20 users; got %d", count)
+	}
+	if _, err := os.Stat(backup + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the temporary file to be renamed")
+	}
+}
+
+func TestBackupNeedsSQLite(t *testing.T) {
+	db := newTestDB(t)
+	// The dialector of a Postgres connection, gorm does not connect before a query
+	postgres := db.Session(&gorm.Session{})
+	postgres.Dialector = fakeDialector{db.Dialector}
+	if err := database.BackupSQLite(context.Background(), postgres, filepath.Join(t.TempDir(), "backup.sqlite")); !errors.Is(err, database.ErrNotSQLite) {
+		t.Errorf("expected ErrNotSQLite; got %v", err)
+	}
+}
+
+type fakeDialector struct{ gorm.Dialector }
+
+func (fakeDialector) Name() string { return "postgres" }
+
+func TestScheduledBackupsKeepTheNewest(t *testing.T) {
+	dir := t.TempDir()
+	service, db := newFileDB(t, filepath.Join(dir, "app.sqlite"))
+	defer service.Close()
+
+	backups := database.NewBackups(db, database.BackupOptions{Dir: filepath.Join(dir, "backups"), Keep: 3})
+	start := time.Date(2024, 8, 1, 3, 0, 0, 0, time.UTC)
+	for day := 0; day < 5; day++ {
+		if _, err := backups.Create(context.Background(), start.AddDate(0, 0, day)); err != nil {
+			t.Fatalf("error backing up. Err: %v", err)
+		}
+	}
+	// Files of other tools are left alone
+	os.WriteFile(filepath.Join(dir, "backups", "notes.txt"), []byte("manual"), 0644)
+
+	list, err := backups.List()
+	if err != nil || len(list) != 3 {
+		t.Fatalf("expected 3 backups to be kept; got %v, %v", list, err)
+	}
+	if !list[0].CreatedAt.Equal(start.AddDate(0, 0, 4)) || !list[2].CreatedAt.Equal(start.AddDate(0, 0, 2)) {
+		t.Errorf("expected the newest backups first; got %v", list)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "backups", "notes.txt")); err != nil {
+		t.Errorf("expected other files to be kept")
+	}
+	if _, ok := backups.Path("../app.sqlite"); ok {
+		t.Errorf("expected only backups to be served")
+	}
+}
+
+func TestRestoreKeepsTheReplacedDatabase(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.sqlite")
+	service, db := newFileDB(t, file)
+	db.Create(&model.User{Username: "alice", Email: "alice@example.org"})
+	backup := filepath.Join(dir, "backup.sqlite")
+	if err := database.BackupSQLite(context.Background(), db, backup); err != nil {
+		t.Fatalf("error backing up. Err: %v", err)
+	}
+	db.Create(&model.User{Username: "bob", Email: "bob@example.org"})
+	service.Close()
+
+	// Garbage is rejected before the database is touched
+	garbage := filepath.Join(dir, "garbage.sqlite")
+	os.WriteFile(garbage, []byte("not a database"), 0644)
+	if _, err := database.RestoreSQLite(context.Background(), garbage, file); err == nil {
+		t.Fatalf("expected an invalid backup to be rejected")
+	}
+	if count := countUsers(t, file); count != 2 {
+		t.Fatalf("expected the database to be unchanged; got %d users", count)
+	}
+
+	replaced, err := database.RestoreSQLite(context.Background(), backup, file)
+	if err != nil {
+		t.Fatalf("error restoring. Err: %v", err)
+	}
+	if count := countUsers(t, file); count != 1 {
+		t.Errorf("expected the user of the backup; got %d users", count)
+	}
+	if count := countUsers(t, replaced); count != 2 {
+		t.Errorf("expected the replaced database to be kept; got %d users", count)
+	}
+}
+
+func TestFailedRestoreKeepsTheDatabase(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.sqlite")
+	service, db := newFileDB(t, file)
+	db.Create(&model.User{Username: "alice", Email: "alice@example.org"})
+	backup := filepath.Join(dir, "backup.sqlite")
+	if err := database.BackupSQLite(context.Background(), db, backup); err != nil {
+		t.Fatalf("error backing up. Err: %v", err)
+	}
+	db.Create(&model.User{Username: "bob", Email: "bob@example.org"})
+	service.Close()
+
+	// The copy of the backup can't be written
+	if err := os.Mkdir(file+".tmp", 0755); err != nil {
+		t.Fatalf("error creating directory. Err: %v", err)
+	}
+	if _, err := database.RestoreSQLite(context.Background(), backup, file); err == nil {
+		t.Fatalf("expected the restore to fail")
+	}
+	if count := countUsers(t, file); count != 2 {
+		t.Errorf("expected the database to be unchanged; got %d users", count)
+	}
+	if moved, _ := filepath.Glob(file + ".before-restore-*"); len(moved) != 0 {
+		t.Errorf("expected no file to be left renamed; got %v", moved)
+	}
+}
+
+func TestDownloadDatabaseSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	service, db := newFileDB(t, filepath.Join(dir, "app.sqlite"))
+	defer service.Close()
+	db.Create(&model.User{Username: "alice", Email: "alice@example.org"})
+
+	backups := database.NewBackups(db, database.BackupOptions{Dir: filepath.Join(dir, "backups")})
+	scheduled, err := backups.Create(context.Background(), time.Now())
+	if err != nil {
+		t.Fatalf("error backing up. Err: %v", err)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/admin/database-backup/download", database_backup.New(db, &config.Config{}, backups).Download)
+	router.Get("/admin/database-backup/{name}", database_backup.New(db, &config.Config{}, backups).File)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/database-backup/download", nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/vnd.sqlite3" {
+		t.Fatalf("expected a database file; got %d %s", recorder.Code, recorder.Body.String())
+	}
+	download := filepath.Join(dir, "download.sqlite")
+	os.WriteFile(download, recorder.Body.Bytes(), 0644)
+	if count := countUsers(t, download); count != 1 {
+		t.Errorf("expected the snapshot to have the user; got %d", count)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/database-backup/"+filepath.Base(scheduled), nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected the scheduled backup; got %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/database-backup/app.sqlite", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected other files to be hidden; got %d", recorder.Code)
+	}
+}
+
+func TestRestoreRejectsTheDatabaseFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.sqlite")
+	service, db := newFileDB(t, file)
+	db.Create(&model.User{Username: "alice", Email: "alice@example.org"})
+	service.Close()
+	link := filepath.Join(dir, "link.sqlite")
+	if err := os.Symlink(file, link); err != nil {
+		t.Fatalf("error linking. Err: %v", err)
+	}
+
+	for _, backup := range []string{file, filepath.Join(dir, ".", "app.sqlite"), link} {
+		if _, err := database.RestoreSQLite(context.Background(), backup, file); !errors.Is(err, database.ErrRestoreSameFile) {
+			t.Errorf("expected restoring %s to be rejected; got %v", backup, err)
+		}
+	}
+	if count := countUsers(t, file); count != 1 {
+		t.Errorf("expected the database to be unchanged; got %d users", count)
+	}
+}
@@ -0,0 +1,218 @@
+package tests
+
+import (
+	"atomic-go-template/internal/broadcast"
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/mail"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/notification"
+	"atomic-go-template/web/emails"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"gorm.io/gorm"
+)
+
+func newBroadcastWorker(t *testing.T, db *gorm.DB, provider mail.Service) *broadcast.Worker {
+	c := &config.Config{App: config.App{Name: "Test App", Url: "https://app.example.org"}}
+	return broadcast.NewWorker(db, provider, emails.New(c, provider).RenderBroadcast, broadcast.Options{BatchSize: 2})
+}
+
+// processBroadcasts runs batches until nothing is left to send
+func processBroadcasts(t *testing.T, worker *broadcast.Worker) int {
+	batches := 0
+	for {
+		processed, err := worker.ProcessBatch(context.Background())
+		if err != nil {
+			t.Fatalf("error processing broadcast. Err: %v", err)
+		}
+		if !processed {
+			return batches
+		}
+		batches++
+	}
+}
+
+func loadBroadcast(t *testing.T, db *gorm.DB, b model.Broadcast) model.Broadcast {
+	if err := db.First(&b, "id = ?", b.ID).Error; err != nil {
+		t.Fatalf("error loading broadcast. Err: %v", err)
+	}
+	return b
+}
+
+func TestBroadcastSegments(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	old := now.Add(-60 * 24 * time.Hour)
+
+	verified := createTestUser(t, db, "verified", "verified@example.org")
+	db.Model(&verified).Updates(map[string]interface{}{"verified_at": now, "last_login_at": now})
+	dormant := createTestUser(t, db, "dormant", "dormant@acme.org")
+	db.Model(&dormant).Updates(map[string]interface{}{"created_at": old, "last_login_at": old})
+	neverLoggedIn := createTestUser(t, db, "never", "never@Acme.org")
+	db.Model(&neverLoggedIn).Update("created_at", old)
+	createTestUser(t, db, "newcomer", "newcomer@example.org")
+
+	cases := []struct {
+		broadcast model.Broadcast
+		expected  int64
+	}{
+		{model.Broadcast{Segment: model.BroadcastSegmentAll}, 4},
+		{model.Broadcast{Segment: model.BroadcastSegmentVerified}, 1},
+		{model.Broadcast{Segment: model.BroadcastSegmentInactive}, 2},
+		{model.Broadcast{Segment: model.BroadcastSegmentDomain, SegmentDomain: "@ACME.org "}, 2},
+	}
+	for _, c := range cases {
+		c.broadcast.Category = "product_updates"
+		if err := broadcast.Normalize(&c.broadcast); err != nil {
+			t.Fatalf("error normalizing %s. Err: %v", c.broadcast.Segment, err)
+		}
+		var count int64
+		if err := broadcast.Recipients(db, c.broadcast).Count(&count).Error; err != nil {
+			t.Fatalf("error counting %s. Err: %v", c.broadcast.Segment, err)
+		}
+		if count != c.expected {
+			t.Errorf("expected %d users in segment %s; got %d", c.expected, c.broadcast.Segment, count)
+		}
+	}
+
+	for _, invalid := range []model.Broadcast{
+		{Category: "product_updates", Segment: "admins"},
+		{Category: "product_updates", Segment: model.BroadcastSegmentDomain, SegmentDomain: "%"},
+		{Category: "unknown", Segment: model.BroadcastSegmentAll},
+	} {
+		if err := broadcast.Normalize(&invalid); err == nil {
+			t.Errorf("expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestBroadcastSkipsUnsubscribedUsers(t *testing.T) {
+	db := newTestDB(t)
+	for _, name := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		createTestUser(t, db, name, name+"@example.org")
+	}
+	var bob model.User
+	db.First(&bob, "username = ?", "bob")
+	notification.SetSubscribed(db, bob.ID, "product_updates", false)
+	mail.Suppress(db, "Carol@example.org", model.SuppressionReasonBounce, "", "test")
+
+	provider := &recordingMail{}
+	worker := newBroadcastWorker(t, db, provider)
+	b := model.Broadcast{Subject: "New features", Body: "Hello\n\nWe shipped <things>.", Category: "product_updates", Segment: model.BroadcastSegmentAll}
+	if err := worker.Queue(&b); err != nil {
+		t.Fatalf("error queueing broadcast. Err: %v", err)
+	}
+	if batches := processBroadcasts(t, worker); batches != 4 {
+		t.Errorf("expected 3 batches of 2 users and the final one; got %d", batches)
+	}
+
+	b = loadBroadcast(t, db, b)
+	if b.Status != model.BroadcastStatusSent || b.Total != 5 || b.Sent != 3 || b.Skipped != 2 || b.Failed != 0 || b.FinishedAt == nil {
+		t.Errorf("expected 3 sent and 2 skipped; got %+v", b)
+	}
+	if len(provider.messages) != 3 {
+		t.Fatalf("expected 3 messages; got %d", len(provider.messages))
+	}
+	for _, message := range provider.messages {
+		if message.To[0] == "bob@example.org" || message.To[0] == "carol@example.org" {
+			t.Errorf("expected %s to be skipped", message.To[0])
+		}
+		if message.Headers["List-Unsubscribe"] == "" || message.Tags["broadcast"] != b.ID.String() {
+			t.Errorf("expected the unsubscribe header and broadcast tag; got %v %v", message.Headers, message.Tags)
+		}
+	}
+	if html := provider.messages[0].HTML; !strings.Contains(html, "We shipped &lt;things&gt;.</p>") {
+		t.Errorf("expected the escaped paragraphs of the body; got %s", html)
+	}
+}
+
+func TestBroadcastCountsFailures(t *testing.T) {
+	db := newTestDB(t)
+	createTestUser(t, db, "alice", "alice@example.org")
+	createTestUser(t, db, "bob", "bob@example.org")
+	provider := &failingMail{errs: []error{errors.New("503 service unavailable")}}
+	worker := newBroadcastWorker(t, db, provider)
+
+	b := model.Broadcast{Subject: "News", Body: "Hello", Category: "product_updates", Segment: model.BroadcastSegmentAll}
+	if err := worker.Queue(&b); err != nil {
+		t.Fatalf("error queueing broadcast. Err: %v", err)
+	}
+	processBroadcasts(t, worker)
+
+	b = loadBroadcast(t, db, b)
+	if b.Status != model.BroadcastStatusSent || b.Sent != 1 || b.Failed != 1 || b.LastError == nil || !strings.Contains(*b.LastError, "503") {
+		t.Errorf("expected 1 sent and 1 failed; got %+v", b)
+	}
+}
+
+func TestBroadcastCountsDeadLettersOfTheOutbox(t *testing.T) {
+	provider := &failingMail{errs: []error{&smtp.SMTPError{Code: 550, Message: "No such user"}}}
+	outbox, db := newTestOutbox(t, provider, mail.OutboxOptions{})
+	createTestUser(t, db, "alice", "alice@example.org")
+	createTestUser(t, db, "bob", "bob@example.org")
+	worker := newBroadcastWorker(t, db, outbox)
+
+	b := model.Broadcast{Subject: "News", Body: "Hello", Category: "product_updates", Segment: model.BroadcastSegmentAll}
+	if err := worker.Queue(&b); err != nil {
+		t.Fatalf("error queueing broadcast. Err: %v", err)
+	}
+	processBroadcasts(t, worker)
+	if b = loadBroadcast(t, db, b); b.Sent != 0 || b.Failed != 0 {
+		t.Errorf("expected queued mails not to count yet; got %+v", b)
+	}
+
+	processDue(t, outbox)
+	b = loadBroadcast(t, db, b)
+	if b.Sent != 1 || b.Failed != 1 || b.LastError == nil || !strings.Contains(*b.LastError, "550") {
+		t.Errorf("expected 1 sent and 1 dead-lettered; got %+v", b)
+	}
+
+	// A retry of the dead letter counts it again
+	var dead model.OutboxMessage
+	if err := db.Where("status = ?", model.OutboxStatusDead).First(&dead).Error; err != nil {
+		t.Fatalf("error loading dead letter. Err: %v", err)
+	}
+	if dead.BroadcastID == nil || *dead.BroadcastID != b.ID {
+		t.Errorf("expected the dead letter to belong to the broadcast; got %v", dead.BroadcastID)
+	}
+	if err := outbox.Retry(dead.ID); err != nil {
+		t.Fatalf("error retrying message. Err: %v", err)
+	}
+	processDue(t, outbox)
+	if b = loadBroadcast(t, db, b); b.Sent != 2 || b.Failed != 0 {
+		t.Errorf("expected 2 sent after the retry; got %+v", b)
+	}
+}
+
+func TestBroadcastCancel(t *testing.T) {
+	db := newTestDB(t)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		createTestUser(t, db, name, name+"@example.org")
+	}
+	provider := &recordingMail{}
+	worker := newBroadcastWorker(t, db, provider)
+	b := model.Broadcast{Subject: "News", Body: "Hello", Category: "product_updates", Segment: model.BroadcastSegmentAll}
+	if err := worker.Queue(&b); err != nil {
+		t.Fatalf("error queueing broadcast. Err: %v", err)
+	}
+	if _, err := worker.ProcessBatch(context.Background()); err != nil {
+		t.Fatalf("error processing batch. Err: %v", err)
+	}
+	if err := worker.Cancel(b.ID); err != nil {
+		t.Fatalf("error cancelling broadcast. Err: %v", err)
+	}
+	processBroadcasts(t, worker)
+
+	b = loadBroadcast(t, db, b)
+	if b.Status != model.BroadcastStatusCancelled || b.Sent != 2 || len(provider.messages) != 2 {
+		t.Errorf("expected the broadcast to stop after the first batch; got %+v", b)
+	}
+	if err := worker.Cancel(b.ID); !errors.Is(err, broadcast.ErrBroadcastNotFound) {
+		t.Errorf("expected ErrBroadcastNotFound for a cancelled broadcast; got %v", err)
+	}
+}
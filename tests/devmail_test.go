@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/mail"
+	mw "atomic-go-template/internal/middleware"
+	dev_mail "atomic-go-template/web/routes/dev/mail"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func newDevService(t *testing.T, directory string) *mail.DevService {
+	service, err := mail.NewDevService(mail.DevOptions{
+		From:      netmail.Address{Name: "Test App", Address: "noreply@example.org"},
+		Directory: directory,
+	})
+	if err != nil {
+		t.Fatalf("error creating dev mail service. Err: %v", err)
+	}
+	return service
+}
+
+func TestDevServiceKeepsMessages(t *testing.T) {
+	dev := newDevService(t, "")
+	err := dev.SendMessage(mail.Message{
+		To:      []string{"Alice <alice@example.org>"},
+		Cc:      []string{"carol@example.org"},
+		Bcc:     []string{"audit@example.org"},
+		Subject: "Verify your email – now",
+		HTML:    `<p>Click <a href="https://app.example.org/verify?token=abc">here</a></p><img src="cid:logo">`,
+		Attachments: []mail.Attachment{
+			{Filename: "logo.png", Content: []byte("png"), ContentID: "logo"},
+			{Filename: "terms.pdf", Content: []byte("%PDF")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error sending message. Err: %v", err)
+	}
+	if err := dev.Send("bob@example.org", "Second", "<p>Second</p>"); err != nil {
+		t.Fatalf("error sending message. Err: %v", err)
+	}
+
+	messages := dev.Messages()
+	if len(messages) != 2 || messages[0].Subject != "Second" {
+		t.Fatalf("expected 2 messages, newest first; got %d", len(messages))
+	}
+	message := messages[1]
+	if message.Subject != "Verify your email – now" || message.From != "Test App <noreply@example.org>" {
+		t.Errorf("unexpected subject or sender: %q, %q", message.Subject, message.From)
+	}
+	if strings.Join(message.To, ",") != "Alice <alice@example.org>" || strings.Join(message.Cc, ",") != "carol@example.org" || strings.Join(message.Bcc, ",") != "audit@example.org" {
+		t.Errorf("unexpected recipients: %v %v %v", message.To, message.Cc, message.Bcc)
+	}
+	if !strings.Contains(message.HTML, `href="https://app.example.org/verify?token=abc"`) {
+		t.Errorf("expected the HTML body; got %q", message.HTML)
+	}
+	if !strings.Contains(message.Text, "here (https://app.example.org/verify?token=abc)") {
+		t.Errorf("expected a generated text body; got %q", message.Text)
+	}
+	if len(message.Attachments) != 2 || message.Attachments[0].ContentID != "logo" || string(message.Attachments[1].Content) != "%PDF" {
+		t.Errorf("unexpected attachments: %+v", message.Attachments)
+	}
+	if !bytes.Contains(message.Raw, []byte("Subject: =?utf-8?q?")) {
+		t.Errorf("expected the raw message with encoded headers")
+	}
+
+	if found, err := dev.Message(message.ID); err != nil || found.Subject != message.Subject {
+		t.Errorf("expected to find the message by ID; got %v", err)
+	}
+	if _, err := dev.Message("unknown"); err != mail.ErrDevMessageNotFound {
+		t.Errorf("expected ErrDevMessageNotFound; got %v", err)
+	}
+}
+
+func TestDevServiceWritesEMLFiles(t *testing.T) {
+	directory := t.TempDir()
+	dev := newDevService(t, directory)
+	if err := dev.Send("alice@example.org", "Welcome", "<p>Welcome</p>"); err != nil {
+		t.Fatalf("error sending message. Err: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(directory, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected one .eml file; got %d", len(files))
+	}
+	raw, err := os.ReadFile(files[0])
+	if err != nil || !bytes.Contains(raw, []byte("Subject: Welcome")) {
+		t.Fatalf("expected the raw message in the file. Err: %v", err)
+	}
+
+	// The mails survive a restart
+	restarted := newDevService(t, directory)
+	if messages := restarted.Messages(); len(messages) != 1 || messages[0].Subject != "Welcome" {
+		t.Fatalf("expected the mail to be loaded from the directory; got %d messages", len(messages))
+	}
+
+	if err := restarted.Clear(); err != nil {
+		t.Fatalf("error clearing mails. Err: %v", err)
+	}
+	files, _ = filepath.Glob(filepath.Join(directory, "*.eml"))
+	if len(files) != 0 || len(restarted.Messages()) != 0 {
+		t.Errorf("expected clear to remove the mails and files; got %d files", len(files))
+	}
+}
+
+func TestDevMailInbox(t *testing.T) {
+	dev := newDevService(t, "")
+	err := dev.SendMessage(mail.Message{
+		To:          []string{"alice@example.org"},
+		Subject:     "Verify your email",
+		HTML:        `<p><a href="https://app.example.org/verify?token=abc">Verify</a></p><img src="cid:logo">`,
+		Text:        "Open https://app.example.org/verify?token=abc.",
+		Attachments: []mail.Attachment{{Filename: "logo.png", Content: []byte("png"), ContentID: "logo"}},
+	})
+	if err != nil {
+		t.Fatalf("error sending message. Err: %v", err)
+	}
+	id := dev.Messages()[0].ID
+
+	router := chi.NewRouter()
+	router.Get("/dev/mail", dev_mail.New(dev).GET)
+	router.Get("/dev/mail/{id}/html", dev_mail.New(dev).HTML)
+	router.Get("/dev/mail/{id}/raw", dev_mail.New(dev).Raw)
+	router.Post("/dev/mail/clear", dev_mail.New(dev).Clear)
+
+	get := func(path string) (*httptest.ResponseRecorder, string) {
+		recorder := httptest.NewRecorder()
+		// The layout reads the config from the context, normally set by the ConfigMiddleware
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request = request.WithContext(context.WithValue(request.Context(), mw.ConfigKey, &config.Config{}))
+		router.ServeHTTP(recorder, request)
+		body, _ := io.ReadAll(recorder.Body)
+		return recorder, string(body)
+	}
+
+	_, body := get("/dev/mail?id=" + id + "&view=text")
+	if !strings.Contains(body, `href="https://app.example.org/verify?token=abc"`) {
+		t.Errorf("expected the link in the text view to be clickable; got %s", body)
+	}
+
+	recorder, body := get("/dev/mail/" + id + "/html")
+	if !strings.Contains(recorder.Header().Get("Content-Security-Policy"), "script-src 'none'") {
+		t.Errorf("expected scripts to be blocked in the HTML view")
+	}
+	if !strings.HasPrefix(body, `<base target="_top">`) || !strings.Contains(body, `src="/dev/mail/`+id+`/attachments/0"`) {
+		t.Errorf("expected links to open in the main window and inline images to be served; got %s", body)
+	}
+
+	recorder, body = get("/dev/mail/" + id + "/raw")
+	if recorder.Header().Get("Content-Type") != "message/rfc822" || !strings.Contains(body, "Subject: Verify your email") {
+		t.Errorf("expected the raw message; got %s", body)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dev/mail/clear", nil))
+	if recorder.Header().Get("HX-Redirect") != "/dev/mail" || len(dev.Messages()) != 0 {
+		t.Errorf("expected clear all to empty the inbox")
+	}
+	if recorder, _ := get("/dev/mail/" + id + "/html"); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected cleared mails to be gone; got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/mail"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// dkimRecord returns the DNS TXT record publishing the public key
+func dkimRecord(t *testing.T, key crypto.Signer) string {
+	switch public := key.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			t.Fatalf("error marshaling public key. Err: %v", err)
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(public)
+	}
+	t.Fatalf("unsupported key %T", key)
+	return ""
+}
+
+// writeDKIMKey writes the key as PKCS#8 PEM file and returns the path
+func writeDKIMKey(t *testing.T, key crypto.Signer) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("error marshaling key. Err: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("error writing key. Err: %v", err)
+	}
+	return path
+}
+
+func verifyDKIM(t *testing.T, message []byte, record string) []*dkim.Verification {
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(message), &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			if domain != "test._domainkey.example.org" {
+				t.Errorf("unexpected lookup of %s", domain)
+			}
+			return []string{record}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("error verifying message. Err: %v", err)
+	}
+	if len(verifications) != 1 {
+		t.Fatalf("expected 1 signature; got %d", len(verifications))
+	}
+	return verifications
+}
+
+func TestDKIMSignsSMTPMails(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key. Err: %v", err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key. Err: %v", err)
+	}
+
+	for name, key := range map[string]crypto.Signer{"rsa": rsaKey, "ed25519": ed25519Key} {
+		t.Run(name, func(t *testing.T) {
+			// The key is loaded from the file like in production, the domain comes from the sender
+			t.Setenv("DKIM_PRIVATE_KEY", "")
+			t.Setenv("DKIM_PRIVATE_KEY_FILE", writeDKIMKey(t, key))
+			dkimOptions, err := mail.DKIMOptionsFromConfig(config.DKIM{
+				EnableDKIM: true,
+				Selector:   "test",
+				Headers:    []string{"To", "Subject", "Date"},
+			}, "noreply@example.org")
+			if err != nil {
+				t.Fatalf("error loading dkim options. Err: %v", err)
+			}
+			signer, err := mail.NewDKIMSigner(dkimOptions)
+			if err != nil {
+				t.Fatalf("error creating signer. Err: %v", err)
+			}
+
+			backend, options := newSMTPServer(t, config.SMTPTLSModeStartTLS, config.SMTPAuthPlain)
+			options.DKIM = signer
+			if err := newSMTPService(t, options).Send("alice@example.org", "Signed", "<p>Hello</p>"); err != nil {
+				t.Fatalf("error sending mail. Err: %v", err)
+			}
+			mails, _ := backend.received()
+			if len(mails) != 1 {
+				t.Fatalf("expected 1 mail; got %d", len(mails))
+			}
+
+			verification := verifyDKIM(t, mails[0].data, dkimRecord(t, key))[0]
+			if verification.Err != nil {
+				t.Fatalf("expected a valid signature. Err: %v", verification.Err)
+			}
+			if verification.Domain != "example.org" {
+				t.Errorf("expected domain example.org; got %s", verification.Domain)
+			}
+			// From is always signed, even if missing in the configured headers
+			if strings.Join(verification.HeaderKeys, ":") != "From:To:Subject:Date" {
+				t.Errorf("unexpected signed headers %v", verification.HeaderKeys)
+			}
+
+			tampered := bytes.Replace(mails[0].data, []byte("Subject: Signed"), []byte("Subject: Tampered"), 1)
+			if verifyDKIM(t, tampered, dkimRecord(t, key))[0].Err == nil {
+				t.Error("expected the tampered mail to fail verification")
+			}
+		})
+	}
+}
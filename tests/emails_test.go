@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/mail"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/web/emails"
+	"strings"
+	"testing"
+)
+
+// recordingMail keeps the sent messages instead of sending them
+type recordingMail struct {
+	messages []mail.Message
+}
+
+func (r *recordingMail) Send(to, subject, body string) error {
+	return r.SendMessage(mail.Message{To: []string{to}, Subject: subject, HTML: body})
+}
+
+func (r *recordingMail) SendMessage(message mail.Message) error {
+	r.messages = append(r.messages, message)
+	return nil
+}
+
+func TestHTMLToText(t *testing.T) {
+	cases := map[string]string{
+		`<p>Hello <b>Alice</b>,</p><p>welcome!</p>`:                                "Hello Alice,\n\nwelcome!\n",
+		`<p>Click <a href="https://example.org/reset">here</a></p>`:                "Click here (https://example.org/reset)\n",
+		`<a href="https://example.org">https://example.org</a>`:                    "https://example.org\n",
+		`<div style="display: none">Preview</div><p>Body</p>`:                      "Body\n",
+		`<ul><li>One</li><li>Two</li></ul>`:                                        "- One\n- Two\n",
+		`<head><title>T</title><style>p{}</style></head><body>Line<br>Next</body>`: "Line\nNext\n",
+	}
+	for input, expected := range cases {
+		text, err := mail.HTMLToText(input)
+		if err != nil {
+			t.Fatalf("error converting %q. Err: %v", input, err)
+		}
+		if text != expected {
+			t.Errorf("HTMLToText(%q) = %q; expected %q", input, text, expected)
+		}
+	}
+}
+
+func TestMailerSendsPasswordReset(t *testing.T) {
+	recorder := &recordingMail{}
+	c := &config.Config{App: config.App{Name: "Test App", Url: "https://app.example.org"}}
+	user := model.User{Username: "alice", Email: "alice@example.org"}
+	link := "https://app.example.org/auth/reset-password?token=abc"
+
+	if err := emails.New(c, recorder).SendPasswordReset(user, link); err != nil {
+		t.Fatalf("error sending mail. Err: %v", err)
+	}
+	if len(recorder.messages) != 1 {
+		t.Fatalf("expected 1 message; got %d", len(recorder.messages))
+	}
+	message := recorder.messages[0]
+
+	if len(message.To) != 1 || message.To[0] != "alice@example.org" || message.Subject != "Test App - Reset your password" {
+		t.Errorf("unexpected recipient or subject: %s %s", message.To, message.Subject)
+	}
+	for _, expected := range []string{"Test App", "Hi alice", "Reset your password, the link is valid", `href="` + strings.ReplaceAll(link, "&", "&amp;")} {
+		if !strings.Contains(message.HTML, expected) {
+			t.Errorf("expected HTML to contain %q", expected)
+		}
+	}
+
+	// The text version has the link but neither markup nor the hidden preview
+	if !strings.Contains(message.Text, "Reset password ("+link+")") || !strings.Contains(message.Text, "Hi alice,") {
+		t.Errorf("unexpected text: %s", message.Text)
+	}
+	if strings.Contains(message.Text, "<") || strings.Contains(message.Text, "Reset your password, the link is valid") {
+		t.Errorf("expected no markup or preview in text: %s", message.Text)
+	}
+}
@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/mail"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/web/routes/health"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// downMail fails every mail until it is brought back up
+type downMail struct {
+	recordingMail
+	down     bool
+	attempts int
+}
+
+func (d *downMail) Send(to, subject, body string) error {
+	return d.SendMessage(mail.Message{To: []string{to}, Subject: subject, HTML: body})
+}
+
+func (d *downMail) SendMessage(message mail.Message) error {
+	d.attempts++
+	if d.down {
+		return errors.New("503 service unavailable")
+	}
+	return d.recordingMail.SendMessage(message)
+}
+
+func newFailover(t *testing.T, options mail.FailoverOptions, providers ...*downMail) *mail.FailoverService {
+	var chain []mail.FailoverProvider
+	for i, provider := range providers {
+		chain = append(chain, mail.FailoverProvider{Name: []string{"resend", "smtp", "console"}[i], Service: provider})
+	}
+	failover, err := mail.NewFailoverService(chain, options)
+	if err != nil {
+		t.Fatalf("error creating failover service. Err: %v", err)
+	}
+	return failover
+}
+
+var testMessage = mail.Message{To: []string{"alice@example.org"}, Subject: "Reset your password", HTML: "<p>Reset</p>"}
+
+func TestFailoverUsesNextProvider(t *testing.T) {
+	primary, secondary := &downMail{down: true}, &downMail{}
+	failover := newFailover(t, mail.FailoverOptions{}, primary, secondary)
+
+	provider, err := failover.Deliver(testMessage)
+	if err != nil {
+		t.Fatalf("error delivering message. Err: %v", err)
+	}
+	if provider != "smtp" || len(secondary.messages) != 1 {
+		t.Fatalf("expected smtp to deliver the message; got %q", provider)
+	}
+
+	status := failover.Status()
+	if status[0].State != "closed" || status[0].ConsecutiveFailures != 1 || status[0].LastError == "" {
+		t.Errorf("expected resend to have one failure; got %+v", status[0])
+	}
+	if status[1].Delivered != 1 || status[1].LastDeliveredAt == nil {
+		t.Errorf("expected smtp to report the delivery; got %+v", status[1])
+	}
+}
+
+func TestFailoverCircuitBreaker(t *testing.T) {
+	primary, secondary := &downMail{down: true}, &downMail{}
+	failover := newFailover(t, mail.FailoverOptions{FailureThreshold: 2, Cooldown: 50 * time.Millisecond}, primary, secondary)
+
+	for i := 0; i < 4; i++ {
+		if err := failover.SendMessage(testMessage); err != nil {
+			t.Fatalf("error sending message. Err: %v", err)
+		}
+	}
+	if primary.attempts != 2 {
+		t.Errorf("expected resend to be skipped after 2 failures; got %d attempts", primary.attempts)
+	}
+	if status := failover.Status()[0]; status.State != "open" || status.OpenUntil == nil {
+		t.Errorf("expected the circuit of resend to be open; got %+v", status)
+	}
+
+	// After the cooldown a trial mail goes to resend again
+	primary.down = false
+	time.Sleep(60 * time.Millisecond)
+	provider, err := failover.Deliver(testMessage)
+	if err != nil || provider != "resend" {
+		t.Fatalf("expected resend to deliver the trial mail; got %q, %v", provider, err)
+	}
+	if status := failover.Status()[0]; status.State != "closed" || status.ConsecutiveFailures != 0 {
+		t.Errorf("expected the circuit of resend to be closed again; got %+v", status)
+	}
+}
+
+func TestFailoverErrors(t *testing.T) {
+	failover := newFailover(t, mail.FailoverOptions{}, &downMail{down: true}, &downMail{down: true})
+	if _, err := failover.Deliver(testMessage); !errors.Is(err, mail.ErrAllProvidersFailed) {
+		t.Errorf("expected ErrAllProvidersFailed; got %v", err)
+	}
+
+	// A rejected recipient would be rejected by the next provider as well
+	rejecting := &failingMail{errs: []error{&smtp.SMTPError{Code: 550, Message: "No such user"}}}
+	secondary := &downMail{}
+	failover, err := mail.NewFailoverService([]mail.FailoverProvider{
+		{Name: "smtp", Service: rejecting},
+		{Name: "resend", Service: secondary},
+	}, mail.FailoverOptions{})
+	if err != nil {
+		t.Fatalf("error creating failover service. Err: %v", err)
+	}
+	if _, err := failover.Deliver(testMessage); !mail.IsPermanentError(err) || secondary.attempts != 0 {
+		t.Errorf("expected the rejection to be returned without failing over; got %v", err)
+	}
+	if status := failover.Status()[0]; status.ConsecutiveFailures != 0 {
+		t.Errorf("expected a rejection not to count as provider failure; got %+v", status)
+	}
+}
+
+func TestFailoverReturnsSMTPRejections(t *testing.T) {
+	// The in-process server rejects nobody@ with 550, as net/smtp reports it
+	_, options := newSMTPServer(t, config.SMTPTLSModeNone, config.SMTPAuthPlain)
+	secondary := &downMail{}
+	failover, err := mail.NewFailoverService([]mail.FailoverProvider{
+		{Name: "smtp", Service: newSMTPService(t, options)},
+		{Name: "resend", Service: secondary},
+	}, mail.FailoverOptions{})
+	if err != nil {
+		t.Fatalf("error creating failover service. Err: %v", err)
+	}
+
+	rejected := mail.Message{To: []string{"nobody@example.org"}, Subject: "Hello", HTML: "<p>Hello</p>"}
+	if _, err := failover.Deliver(rejected); !mail.IsPermanentError(err) || secondary.attempts != 0 {
+		t.Errorf("expected the rejection to be returned without failing over; got %v", err)
+	}
+	if status := failover.Status()[0]; status.State != "closed" || status.ConsecutiveFailures != 0 {
+		t.Errorf("expected a rejection not to count against the health of smtp; got %+v", status)
+	}
+
+	// A greylisting server is asked again later, the next provider takes the mail now
+	greylisted := mail.Message{To: []string{"greylisted@example.org"}, Subject: "Hello", HTML: "<p>Hello</p>"}
+	if provider, err := failover.Deliver(greylisted); err != nil || provider != "resend" {
+		t.Errorf("expected resend to deliver the greylisted mail; got %q, %v", provider, err)
+	}
+}
+
+func TestOutboxRecordsDeliveringProvider(t *testing.T) {
+	failover := newFailover(t, mail.FailoverOptions{}, &downMail{down: true}, &downMail{})
+	outbox, db := newTestOutbox(t, failover, mail.OutboxOptions{})
+
+	if err := outbox.SendMessage(testMessage); err != nil {
+		t.Fatalf("error queueing message. Err: %v", err)
+	}
+	processDue(t, outbox)
+
+	sent := outboxMessages(t, db)[0]
+	if sent.Status != model.OutboxStatusSent || sent.Provider == nil || *sent.Provider != "smtp" {
+		t.Errorf("expected the outbox to record smtp as provider; got %+v", sent)
+	}
+}
+
+func TestConfigDropsUnconfiguredProviders(t *testing.T) {
+	t.Setenv("RESEND_API_KEY", "")
+	t.Setenv("SMTP_HOST", "localhost")
+	t.Setenv("SMTP_PORT", "25")
+	t.Setenv("SMTP_FROM_EMAIL", "noreply@example.org")
+
+	c := config.New(&config.Config{Mail: config.Mail{
+		EnableMail: true,
+		Providers:  []config.MailProvider{config.MailProviderResend, config.MailProviderSMTP},
+		SMTP:       config.SMTP{Auth: config.SMTPAuthNone},
+	}})
+	if len(c.Mail.Providers) != 1 || c.Mail.Providers[0] != config.MailProviderSMTP || c.Mail.MailProvider != config.MailProviderSMTP {
+		t.Errorf("expected resend to be removed from the chain; got %v", c.Mail.Providers)
+	}
+	if !c.Mail.EnableMail {
+		t.Errorf("expected mail to stay enabled with smtp")
+	}
+}
+
+func TestHealthHidesErrorsOfMailProviders(t *testing.T) {
+	primary, secondary := &downMail{down: true}, &downMail{}
+	failover := newFailover(t, mail.FailoverOptions{FailureThreshold: 1, Cooldown: time.Minute}, primary, secondary)
+	if err := failover.SendMessage(testMessage); err != nil {
+		t.Fatalf("error sending message. Err: %v", err)
+	}
+	handler := health.New(newTestService(t), &config.Config{}, failover)
+
+	recorder := httptest.NewRecorder()
+	handler.GET(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	public := recorder.Body.String()
+	if !strings.Contains(public, `{"name":"resend","status":"unhealthy"}`) || !strings.Contains(public, `{"name":"smtp","status":"healthy"}`) {
+		t.Errorf("expected the state of the providers; got %s", public)
+	}
+	if strings.Contains(public, "503") || strings.Contains(public, "last_error") {
+		t.Errorf("expected the errors of the providers to be hidden; got %s", public)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.Admin(recorder, httptest.NewRequest(http.MethodGet, "/admin/health", nil))
+	if !strings.Contains(recorder.Body.String(), "503 service unavailable") {
+		t.Errorf("expected admins to see the errors; got %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"atomic-go-template/internal/inbound"
+	"context"
+	"errors"
+	"net"
+	netsmtp "net/smtp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestReplyAddress(t *testing.T) {
+	userID := uuid.New()
+	address := inbound.ReplyAddress("example.org", userID, "ticket:42")
+	if local, _, _ := strings.Cut(address, "@"); len(local) > 64 {
+		t.Errorf("expected the local part to fit 64 characters; got %d", len(local))
+	}
+
+	// Some servers change the case of the address
+	for _, received := range []string{address, strings.ToUpper(address), "<" + address + ">"} {
+		parsedID, replyContext, err := inbound.ParseReplyAddress(received, "Example.org")
+		if err != nil || parsedID != userID || replyContext != "ticket:42" {
+			t.Errorf("expected %q to round trip; got %v, %q, %v", received, parsedID, replyContext, err)
+		}
+	}
+
+	other := inbound.ReplyAddress("example.org", userID, "ticket:43")
+	otherToken, _, _ := strings.Cut(other, "@")
+	token, _, _ := strings.Cut(address, "@")
+	payload, _, _ := strings.Cut(token, "-")
+	_, otherSignature, _ := strings.Cut(otherToken, "-")
+	for _, forged := range []string{
+		payload + "-" + otherSignature + "@example.org",
+		token + "@other.org",
+		"support@example.org",
+		strings.TrimPrefix(address, "reply+"),
+	} {
+		if _, _, err := inbound.ParseReplyAddress(forged, "example.org"); !errors.Is(err, inbound.ErrInvalidAddress) {
+			t.Errorf("expected %q to be invalid; got %v", forged, err)
+		}
+	}
+}
+
+func TestStripQuotedReply(t *testing.T) {
+	cases := map[string]string{
+		"Thanks, that works!\n\nOn Mon, Aug 5, 2024 at 10:00 AM Test App <reply+abc@example.org> wrote:\n> Your ticket was answered\n> Bye": "Thanks, that works!",
+		"Thanks\r\n\r\nOn Mon, Aug 5, 2024 at 10:00 AM Test App <\r\nreply+abc@example.org> wrote:\r\n> Quoted":                             "Thanks",
+		"Sounds good.\n\n-----Original Message-----\nFrom: Test App\nSent: Monday\n\nQuoted":                                                "Sounds good.",
+		"Yes\n\n________________________________\nFrom: Test App <noreply@example.org>\nSent: Monday":                                       "Yes",
+		"Yes\n\nFrom: Test App <noreply@example.org>\nDate: Monday\nQuoted":                                                                 "Yes",
+		"> Do you want A?\nYes\n> And B?\nNo":                            "Yes\nNo",
+		"See you\n-- \nAlice\nCEO":                                       "See you",
+		"Ok\n\nSent from my iPhone":                                      "Ok",
+		"Am 05.08.2024 um 10:00 schrieb Test App <noreply@example.org>:": "",
+		"On second thought, no.":                                         "On second thought, no.",
+	}
+	for input, expected := range cases {
+		if stripped := inbound.StripQuotedReply(input); stripped != expected {
+			t.Errorf("StripQuotedReply(%q) = %q; expected %q", input, stripped, expected)
+		}
+	}
+}
+
+// newReceiver starts a receiver for example.org that records the replies to tickets
+func newReceiver(t *testing.T) (*inbound.Receiver, string, func() []inbound.Reply) {
+	receiver := inbound.NewReceiver(inbound.Options{Domain: "example.org"})
+	var mu sync.Mutex
+	var replies []inbound.Reply
+	receiver.Handle("ticket", func(ctx context.Context, reply inbound.Reply) error {
+		mu.Lock()
+		defer mu.Unlock()
+		replies = append(replies, reply)
+		return nil
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening. Err: %v", err)
+	}
+	go receiver.Serve(listener)
+	t.Cleanup(func() { receiver.Close() })
+	return receiver, listener.Addr().String(), func() []inbound.Reply {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]inbound.Reply{}, replies...)
+	}
+}
+
+func TestReceiverDispatchesReplies(t *testing.T) {
+	_, addr, replies := newReceiver(t)
+	userID := uuid.New()
+	to := inbound.ReplyAddress("example.org", userID, "ticket:42")
+
+	message := "From: Alice <alice@example.org>\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: Re: Your ticket\r\n" +
+		"Message-ID: <reply-1@example.org>\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"Thanks, that works!\r\n\r\nOn Mon, Aug 5, 2024 at 10:00 AM Test App wrote:\r\n> Your ticket was answered\r\n"
+	if err := netsmtp.SendMail(addr, nil, "alice@example.org", []string{to}, []byte(message)); err != nil {
+		t.Fatalf("error sending reply. Err: %v", err)
+	}
+
+	received := replies()
+	if len(received) != 1 {
+		t.Fatalf("expected 1 reply; got %d", len(received))
+	}
+	reply := received[0]
+	if reply.UserID != userID || reply.Context != "ticket:42" || reply.From != "Alice <alice@example.org>" {
+		t.Errorf("expected the reply of alice to ticket 42; got %+v", reply)
+	}
+	if reply.Text != "Thanks, that works!" || reply.Message.MessageID != "reply-1@example.org" {
+		t.Errorf("expected the text without the quote; got %q", reply.Text)
+	}
+
+	// Out of office notices are accepted but not dispatched
+	autoReply := strings.Replace(message, "Subject:", "Auto-Submitted: auto-replied\r\nSubject:", 1)
+	if err := netsmtp.SendMail(addr, nil, "alice@example.org", []string{to}, []byte(autoReply)); err != nil {
+		t.Fatalf("error sending automatic reply. Err: %v", err)
+	}
+	if len(replies()) != 1 {
+		t.Errorf("expected automatic replies to be ignored")
+	}
+}
+
+func TestReceiverRejectsUnsignedRecipients(t *testing.T) {
+	receiver, addr, replies := newReceiver(t)
+	message := []byte("From: spam@example.net\r\nSubject: Hello\r\n\r\nHello\r\n")
+
+	for _, to := range []string{
+		"support@example.org",
+		inbound.ReplyAddress("other.org", uuid.New(), "ticket:42"),
+		// No handler for invoices
+		inbound.ReplyAddress("example.org", uuid.New(), "invoice:7"),
+	} {
+		err := netsmtp.SendMail(addr, nil, "spam@example.net", []string{to}, message)
+		if err == nil || !strings.Contains(err.Error(), "550") {
+			t.Errorf("expected %q to be rejected with 550; got %v", to, err)
+		}
+	}
+	if len(replies()) != 0 {
+		t.Errorf("expected no replies to be dispatched")
+	}
+
+	err := receiver.Receive(context.Background(), []string{inbound.ReplyAddress("example.org", uuid.New(), "invoice:7")}, strings.NewReader(string(message)))
+	if !errors.Is(err, inbound.ErrNoHandler) {
+		t.Errorf("expected ErrNoHandler; got %v", err)
+	}
+}
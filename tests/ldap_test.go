@@ -0,0 +1,216 @@
+package tests
+
+import (
+	"atomic-go-template/internal/auth"
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/database"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/store"
+	"atomic-go-template/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jimlambrt/gldap"
+	"github.com/jimlambrt/gldap/testdirectory"
+	"gorm.io/gorm"
+)
+
+const adminGroupDN = "cn=admins,ou=groups,dc=example,dc=org"
+
+// newTestDB returns an empty in-memory database with the schema migrated
+func newTestDB(t *testing.T) *gorm.DB {
+	return newTestService(t).GetDB()
+}
+
+// newTestService is newTestDB for the middleware and server, they take the service
+func newTestService(t *testing.T) database.Service {
+	// The driver of the build, see database.SQLiteDriver
+	service, err := database.NewSQLiteService(context.Background(), config.Database{DSN: fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())})
+	if err != nil {
+		t.Fatalf("error opening database. Err: %v", err)
+	}
+	t.Cleanup(func() { service.Close() })
+	if err := database.Migrate(context.Background(), service.GetDB()); err != nil {
+		t.Fatalf("error migrating database. Err: %v", err)
+	}
+	return service
+}
+
+// newTestDirectory starts an in-process LDAP server with alice (admin) and bob (user)
+func newTestDirectory(t *testing.T) *testdirectory.Directory {
+	// The test directory matches search filters against the DN, so the mail is part of it
+	users := []*gldap.Entry{
+		gldap.NewEntry("mail=alice@example.org,ou=people,dc=example,dc=org", map[string][]string{
+			"uid":      {"alice"},
+			"mail":     {"alice@example.org"},
+			"memberOf": {adminGroupDN},
+			"password": {"alice-password"},
+		}),
+		gldap.NewEntry("mail=bob@example.org,ou=people,dc=example,dc=org", map[string][]string{
+			"uid":      {"bob"},
+			"mail":     {"bob@example.org"},
+			"password": {"bob-password"},
+		}),
+		gldap.NewEntry("cn=service,ou=people,dc=example,dc=org", map[string][]string{
+			"password": {"service-password"},
+		}),
+	}
+	return testdirectory.Start(t,
+		testdirectory.WithNoTLS(t),
+		testdirectory.WithDefaults(t, &testdirectory.Defaults{Users: users}),
+	)
+}
+
+func newLDAPAuthenticator(t *testing.T, db *gorm.DB, d *testdirectory.Directory) *auth.LDAPAuthenticator {
+	a, err := auth.NewLDAPAuthenticator(db, auth.LDAPOptions{
+		URL:               fmt.Sprintf("ldap://%s:%d", d.Host(), d.Port()),
+		BindDN:            "cn=service,ou=people,dc=example,dc=org",
+		BindPassword:      "service-password",
+		BaseDN:            "ou=people,dc=example,dc=org",
+		UserFilter:        "(mail=%s)",
+		UsernameAttribute: "uid",
+		EmailAttribute:    "mail",
+		GroupAttribute:    "memberOf",
+		GroupRoles:        map[string]model.Role{adminGroupDN: model.RoleAdmin},
+	})
+	if err != nil {
+		t.Fatalf("error creating authenticator. Err: %v", err)
+	}
+	return a
+}
+
+func TestLDAPAuthenticatorProvisionsUser(t *testing.T) {
+	db := newTestDB(t)
+	a := newLDAPAuthenticator(t, db, newTestDirectory(t))
+
+	user, err := a.Authenticate(context.Background(), "alice@example.org", "alice-password")
+	if err != nil {
+		t.Fatalf("expected login to succeed; got %v", err)
+	}
+	if user.Username != "alice" || user.Email != "alice@example.org" {
+		t.Errorf("expected alice to be provisioned; got %s <%s>", user.Username, user.Email)
+	}
+	if !user.IsAdmin() {
+		t.Errorf("expected alice to be admin by group mapping; got role %q", user.Role)
+	}
+	if user.VerifiedAt == nil || user.Password != nil {
+		t.Errorf("expected a verified user without local password")
+	}
+
+	// The second login reuses the user
+	again, err := a.Authenticate(context.Background(), "alice@example.org", "alice-password")
+	if err != nil || again.ID != user.ID {
+		t.Errorf("expected the same user on the second login; got %v, %v", again.ID, err)
+	}
+
+	bob, err := a.Authenticate(context.Background(), "bob@example.org", "bob-password")
+	if err != nil {
+		t.Fatalf("expected login to succeed; got %v", err)
+	}
+	if bob.IsAdmin() {
+		t.Errorf("expected bob to be a user; got role %q", bob.Role)
+	}
+}
+
+func TestLDAPAuthenticatorRejectsInvalidCredentials(t *testing.T) {
+	db := newTestDB(t)
+	a := newLDAPAuthenticator(t, db, newTestDirectory(t))
+
+	tests := map[string][2]string{
+		"wrong password": {"alice@example.org", "wrong"},
+		"empty password": {"alice@example.org", ""},
+		"unknown user":   {"carol@example.org", "alice-password"},
+	}
+	for name, credentials := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := a.Authenticate(context.Background(), credentials[0], credentials[1])
+			if !errors.Is(err, auth.ErrInvalidCredentials) {
+				t.Errorf("expected ErrInvalidCredentials; got %v", err)
+			}
+		})
+	}
+
+	var count int64
+	db.Model(&model.User{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no users to be provisioned; got %d", count)
+	}
+}
+
+func TestChainFallsBackToLocalUsers(t *testing.T) {
+	db := newTestDB(t)
+	hashedPassword, _ := utils.HashPassword("local-password")
+	local := model.User{Username: "local", Email: "local@example.org", Password: &hashedPassword}
+	db.Create(&local)
+
+	chain := auth.NewChain(newLDAPAuthenticator(t, db, newTestDirectory(t)), auth.NewLocalAuthenticator(store.NewGormUserStore(db)))
+
+	user, err := chain.Authenticate(context.Background(), "local@example.org", "local-password")
+	if err != nil || user.ID != local.ID {
+		t.Errorf("expected the local user; got %v, %v", user.ID, err)
+	}
+	if _, err := chain.Authenticate(context.Background(), "bob@example.org", "bob-password"); err != nil {
+		t.Errorf("expected the directory user; got %v", err)
+	}
+	if _, err := chain.Authenticate(context.Background(), "local@example.org", "wrong"); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials; got %v", err)
+	}
+}
+
+func TestLDAPDoesNotLinkUnverifiedAccounts(t *testing.T) {
+	db := newTestDB(t)
+	// Someone registered the address of alice before her first login with the directory
+	hashedPassword, _ := utils.HashPassword("squatter-password")
+	squatter := model.User{Username: "squatter", Email: "alice@example.org", Password: &hashedPassword}
+	db.Create(&squatter)
+	// bob verified his account, it is linked
+	now := time.Now()
+	bob := model.User{Username: "bob-local", Email: "bob@example.org", VerifiedAt: &now}
+	db.Create(&bob)
+	a := newLDAPAuthenticator(t, db, newTestDirectory(t))
+
+	if _, err := a.Authenticate(context.Background(), "alice@example.org", "alice-password"); !errors.Is(err, auth.ErrUnverifiedAccount) {
+		t.Errorf("expected ErrUnverifiedAccount; got %v", err)
+	}
+	var account model.User
+	db.First(&account, "id = ?", squatter.ID)
+	if account.VerifiedAt != nil || account.Role == model.RoleAdmin {
+		t.Errorf("expected the account to stay unverified and without the admin role; got %+v", account)
+	}
+
+	linked, err := a.Authenticate(context.Background(), "bob@example.org", "bob-password")
+	if err != nil || linked.ID != bob.ID {
+		t.Errorf("expected the verified account to be linked; got %v, %v", linked.ID, err)
+	}
+	db.First(&bob, "id = ?", bob.ID)
+	if bob.OAuthProvider == nil || *bob.OAuthProvider != "ldap" || bob.OAuthID == nil || *bob.OAuthID != "mail=bob@example.org,ou=people,dc=example,dc=org" {
+		t.Errorf("expected the linked account to keep the ID of the directory; got %v %v", bob.OAuthProvider, bob.OAuthID)
+	}
+}
+
+func TestProvisionUpdatesTheEmailOfLinkedUsers(t *testing.T) {
+	db := newTestDB(t)
+	external := auth.ExternalUser{Provider: "ldap", ID: "uid=alice,ou=people,dc=example,dc=org", Email: "alice@example.org"}
+	alice, err := auth.Provision(context.Background(), db, external)
+	if err != nil {
+		t.Fatalf("error provisioning. Err: %v", err)
+	}
+
+	// The directory changed the email of alice
+	external.Email = "alice.smith@example.org"
+	renamed, err := auth.Provision(context.Background(), db, external)
+	if err != nil || renamed.ID != alice.ID || renamed.Email != "alice.smith@example.org" {
+		t.Errorf("expected alice to get the new email; got %v %q, %v", renamed.ID, renamed.Email, err)
+	}
+
+	// Another account has the new email, the old one is kept
+	createTestUser(t, db, "smith", "smith@example.org")
+	external.Email = "smith@example.org"
+	kept, err := auth.Provision(context.Background(), db, external)
+	if err != nil || kept.ID != alice.ID || kept.Email != "alice.smith@example.org" {
+		t.Errorf("expected alice to keep her email; got %v %q, %v", kept.ID, kept.Email, err)
+	}
+}
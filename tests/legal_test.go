@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/legal"
+	mw "atomic-go-template/internal/middleware"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/store"
+	"atomic-go-template/web/routes/auth/signup"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-playground/form/v4"
+	"github.com/go-playground/validator/v10"
+)
+
+func TestRequireLegalAcceptance(t *testing.T) {
+	service := newTestService(t)
+	users := store.NewGormUserStore(service.GetDB())
+	alice := createTestUser(t, service.GetDB(), "alice", "alice@example.org")
+	c := &config.Config{Legal: config.Legal{EnableLegal: true, TermsVersion: "v1", PrivacyVersion: "v1"}}
+	handler := mw.NewMiddleware(service, nil, nil, c).RequireLegalAcceptance(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(method, target string, user *model.User, htmx bool) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, nil)
+		if user != nil {
+			request = request.WithContext(context.WithValue(request.Context(), mw.UserKey, *user))
+		}
+		if htmx {
+			request.Header.Set("HX-Request", "true")
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := serve(http.MethodGet, "/user/profile", &alice, false)
+	if location := recorder.Header().Get("Location"); recorder.Code != http.StatusSeeOther || location != "/legal/accept?next=%2Fuser%2Fprofile" {
+		t.Errorf("expected a redirect to the acceptance page; got %d %s", recorder.Code, location)
+	}
+	// Forms can't be sent again, the user returns to the home page
+	recorder = serve(http.MethodPost, "/user/profile", &alice, true)
+	if redirect := recorder.Header().Get("HX-Redirect"); redirect != "/legal/accept?next=%2F" {
+		t.Errorf("expected a client side redirect; got %q", redirect)
+	}
+	if recorder := serve(http.MethodGet, "/legal/terms", &alice, false); recorder.Code != http.StatusNoContent {
+		t.Errorf("expected the documents to stay reachable; got %d", recorder.Code)
+	}
+	// One click unsubscribes of mails and the re-authentication don't wait for the acceptance
+	for _, target := range []string{"/unsubscribe?token=signed", "/auth/reauthenticate?next=%2Fuser%2Fprofile"} {
+		if recorder := serve(http.MethodGet, target, &alice, false); recorder.Code != http.StatusNoContent {
+			t.Errorf("expected %s to stay reachable; got %d", target, recorder.Code)
+		}
+	}
+	if recorder := serve(http.MethodPost, "/unsubscribe?token=signed", &alice, true); recorder.Code != http.StatusNoContent {
+		t.Errorf("expected the unsubscribe form to stay reachable; got %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodGet, "/user/profile", nil, false); recorder.Code != http.StatusNoContent {
+		t.Errorf("expected anonymous users to pass; got %d", recorder.Code)
+	}
+
+	if err := legal.AcceptCurrent(context.Background(), users, alice.ID, "127.0.0.1", c.Legal); err != nil {
+		t.Fatalf("error accepting. Err: %v", err)
+	}
+	if recorder := serve(http.MethodGet, "/user/profile", &alice, false); recorder.Code != http.StatusNoContent {
+		t.Errorf("expected the user to pass after accepting; got %d", recorder.Code)
+	}
+
+	// A new version has to be accepted again
+	c.Legal.TermsVersion = "v2"
+	if recorder := serve(http.MethodGet, "/user/profile", &alice, false); recorder.Code != http.StatusSeeOther {
+		t.Errorf("expected a redirect for the new terms; got %d", recorder.Code)
+	}
+	c.Legal.EnableLegal = false
+	if recorder := serve(http.MethodGet, "/user/profile", &alice, false); recorder.Code != http.StatusNoContent {
+		t.Errorf("expected the user to pass with legal disabled; got %d", recorder.Code)
+	}
+}
+
+func TestLegalAcceptancesAreUnique(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice", "alice@example.org")
+	acceptance := model.LegalAcceptance{UserID: alice.ID, Document: model.LegalDocumentTerms, Version: "v1", AcceptedAt: time.Now()}
+
+	// Two submits of the form that both found the document pending
+	users := store.NewGormUserStore(db)
+	for i := 0; i < 2; i++ {
+		if err := users.AcceptLegal(context.Background(), []model.LegalAcceptance{acceptance}); err != nil {
+			t.Fatalf("expected accepting again to succeed; got %v", err)
+		}
+	}
+	var count int64
+	db.Model(&model.LegalAcceptance{}).Where("user_id = ?", alice.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("expected one acceptance; got %d", count)
+	}
+	if err := db.Create(&model.LegalAcceptance{UserID: alice.ID, Document: model.LegalDocumentTerms, Version: "v1", AcceptedAt: time.Now()}).Error; err == nil {
+		t.Errorf("expected the unique index to reject the duplicate")
+	}
+}
+
+func TestSignupFailsWithoutLegalAcceptance(t *testing.T) {
+	db := newTestDB(t)
+	// Recording the acceptance fails
+	if err := db.Migrator().DropTable(&model.LegalAcceptance{}); err != nil {
+		t.Fatalf("error dropping table. Err: %v", err)
+	}
+	users := store.NewGormUserStore(db)
+	c := &config.Config{Legal: config.Legal{EnableLegal: true, TermsVersion: "v1", PrivacyVersion: "v1"}}
+
+	postForm(signup.New(users, c, validator.New(validator.WithRequiredStructEnabled()), form.NewDecoder(), &recordingMail{}).POST, c, url.Values{
+		"username":         {"alice"},
+		"email":            {"alice@example.org"},
+		"password":         {"alice-password"},
+		"confirm_password": {"alice-password"},
+		"accept_terms":     {"true"},
+	})
+	if _, err := users.ByEmail(context.Background(), "alice@example.org"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected the user not to be created without the acceptance; got %v", err)
+	}
+}
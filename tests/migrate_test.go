@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/database"
+	"atomic-go-template/internal/model"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestMigrationsCreateModelColumns(t *testing.T) {
+	checkModelColumns(t, newTestDB(t))
+}
+
+// checkModelColumns checks that every field of the models has a column after migrating
+func checkModelColumns(t *testing.T, db *gorm.DB) {
+	for _, m := range database.Models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			t.Fatalf("error parsing %T. Err: %v", m, err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(m, field.DBName) {
+				t.Errorf("expected a migration to create %s.%s", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+}
+
+func TestMigrationsExistForEveryDialect(t *testing.T) {
+	dir := os.DirFS("../internal/database/migrations")
+	sqlite, err := database.LoadMigrations(dir, "sqlite")
+	if err != nil {
+		t.Fatalf("error loading SQLite migrations. Err: %v", err)
+	}
+	postgres, err := database.LoadMigrations(dir, "postgres")
+	if err != nil {
+		t.Fatalf("error loading Postgres migrations. Err: %v", err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("expected the same migrations for both dialects; got %d and %d", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("expected %d_%s for Postgres; got %d_%s", sqlite[i].Version, sqlite[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db := newTestDB(t)
+	createTestUser(t, db, "alice", "alice@example.org")
+	migrator, err := database.NewMigrator(db, database.MigratorOptions{})
+	if err != nil {
+		t.Fatalf("error creating migrator. Err: %v", err)
+	}
+	ctx := context.Background()
+
+	if pending, err := migrator.Pending(ctx); err != nil || pending != 0 {
+		t.Fatalf("expected no pending migrations; got %d, %v", pending, err)
+	}
+	if applied, err := migrator.Up(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("expected up to be a no-op; got %v, %v", applied, err)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("error loading status. Err: %v", err)
+	}
+	reverted, err := migrator.Down(ctx, len(statuses))
+	if err != nil || len(reverted) != len(statuses) {
+		t.Fatalf("expected all migrations to be reverted; got %v, %v", reverted, err)
+	}
+	if db.Migrator().HasTable("users") || db.Migrator().HasTable("outbox_messages") {
+		t.Errorf("expected the down migrations to drop the tables")
+	}
+	if statuses, err = migrator.Status(ctx); err != nil || statuses[0].AppliedAt != nil {
+		t.Errorf("expected the baseline to be pending; got %+v, %v", statuses, err)
+	}
+
+	if applied, err := migrator.Up(ctx); err != nil || len(applied) != len(statuses) {
+		t.Fatalf("expected the migrations to be applied again; got %v, %v", applied, err)
+	}
+	createTestUser(t, db, "alice", "alice@example.org")
+}
+
+// baselineUser is the user of the first release, its database was created by gorm's AutoMigrate
+type baselineUser struct {
+	model.BaseModel
+	Username                 string `gorm:"unique;not null"`
+	Email                    string `gorm:"unique;not null"`
+	Password                 *string
+	PasswordResetToken       *string
+	PasswordResetRequestedAt *time.Time
+	VerifiedAt               *time.Time
+	VerifyMailAddress        *string
+	VerifyMailToken          *string
+	AvatarURL                *string
+	OAuthProvider            *string
+	OAuthID                  *string
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+func TestMigrateUpgradesBaselineDatabase(t *testing.T) {
+	service, err := database.NewSQLiteService(context.Background(), config.Database{File: filepath.Join(t.TempDir(), "baseline.sqlite")})
+	if err != nil {
+		t.Fatalf("error opening database. Err: %v", err)
+	}
+	defer service.Close()
+	db := service.GetDB()
+	if err := db.AutoMigrate(&baselineUser{}); err != nil {
+		t.Fatalf("error creating the baseline schema. Err: %v", err)
+	}
+	alice := baselineUser{Username: "alice", Email: "alice@example.org"}
+	db.Create(&alice)
+
+	if err := database.Migrate(context.Background(), db); err != nil {
+		t.Fatalf("error migrating the baseline database. Err: %v", err)
+	}
+	checkModelColumns(t, db)
+	var user model.User
+	if err := db.First(&user, "id = ?", alice.ID).Error; err != nil || user.Role != model.RoleUser {
+		t.Errorf("expected alice to be kept with the user role; got %+v, %v", user, err)
+	}
+}
+
+func TestMigrateWaitsForLock(t *testing.T) {
+	db := newTestDB(t)
+	migrator, err := database.NewMigrator(db, database.MigratorOptions{LockRetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("error creating migrator. Err: %v", err)
+	}
+
+	// Another instance is migrating
+	db.Exec("INSERT INTO schema_migrations_lock (id, owner, expires_at) VALUES (1, 'other', ?)", time.Now().Add(time.Minute).Unix())
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := migrator.Up(ctx); !errors.Is(err, database.ErrLocked) {
+		t.Errorf("expected ErrLocked; got %v", err)
+	}
+
+	// The other instance crashed, its lock expires
+	db.Exec("UPDATE schema_migrations_lock SET expires_at = ?", time.Now().Add(-time.Second).Unix())
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("expected the expired lock to be taken over; got %v", err)
+	}
+	var locks int64
+	db.Table("schema_migrations_lock").Count(&locks)
+	if locks != 0 {
+		t.Errorf("expected the lock to be released; got %d rows", locks)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := database.LoadMigrations(fstest.MapFS{
+		"sqlite/20240902000000_add_bio.up.sql":    {Data: []byte("ALTER TABLE users ADD COLUMN bio text;")},
+		"sqlite/20240902000000_add_bio.down.sql":  {Data: []byte("ALTER TABLE users DROP COLUMN bio;")},
+		"sqlite/20240801000000_baseline.up.sql":   {Data: []byte("CREATE TABLE users (id uuid);")},
+		"sqlite/20240801000000_baseline.down.sql": {Data: []byte("DROP TABLE users;")},
+		"sqlite/README.md":                        {Data: []byte("ignored")},
+	}, "sqlite")
+	if err != nil {
+		t.Fatalf("error loading migrations. Err: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].Name != "baseline" || migrations[1].Version != 20240902000000 {
+		t.Errorf("expected the migrations ordered by version; got %+v", migrations)
+	}
+
+	if _, err := database.LoadMigrations(fstest.MapFS{
+		"sqlite/20240902000000_add_bio.up.sql": {Data: []byte("ALTER TABLE users ADD COLUMN bio text;")},
+	}, "sqlite"); err == nil {
+		t.Errorf("expected a migration without down file to be rejected")
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+	for _, dialect := range []string{"sqlite", "postgres"} {
+		os.Mkdir(filepath.Join(dir, dialect), 0755)
+	}
+	paths, err := database.CreateMigration(dir, "Add user bio", time.Date(2024, 9, 2, 12, 0, 0, 0, time.UTC))
+	if err != nil || len(paths) != 4 {
+		t.Fatalf("expected up and down files for both dialects; got %v, %v", paths, err)
+	}
+	migrations, err := database.LoadMigrations(os.DirFS(dir), "postgres")
+	if err != nil || len(migrations) != 1 || migrations[0].Version != 20240902120000 || migrations[0].Name != "add_user_bio" {
+		t.Errorf("expected the created migration to load; got %+v, %v", migrations, err)
+	}
+}
@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	mw "atomic-go-template/internal/middleware"
+	"atomic-go-template/internal/notification"
+	"atomic-go-template/web/emails"
+	"atomic-go-template/web/routes/unsubscribe"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/a-h/templ"
+	"github.com/google/uuid"
+)
+
+// newsletter renders a mail with the Layout, like the mails of the app
+func newsletter(c *config.Config) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) error {
+		return emails.Layout(c, "News").Render(templ.WithChildren(ctx, templ.Raw("<p>News</p>")), w)
+	})
+}
+
+func TestUnsubscribeToken(t *testing.T) {
+	userID := uuid.New()
+	token := notification.UnsubscribeToken(userID, "newsletter")
+
+	parsedID, category, err := notification.ParseUnsubscribeToken(token)
+	if err != nil || parsedID != userID || category != "newsletter" {
+		t.Fatalf("expected the token to round trip; got %v, %q, %v", parsedID, category, err)
+	}
+
+	payload, signature, _ := strings.Cut(token, ".")
+	other := notification.UnsubscribeToken(uuid.New(), "newsletter")
+	_, otherSignature, _ := strings.Cut(other, ".")
+	for _, tampered := range []string{payload + "." + otherSignature, payload, signature, "", payload + ".!!"} {
+		if _, _, err := notification.ParseUnsubscribeToken(tampered); !errors.Is(err, notification.ErrInvalidToken) {
+			t.Errorf("expected %q to be invalid; got %v", tampered, err)
+		}
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice", "alice@example.org")
+
+	preferences, err := notification.Preferences(db, alice.ID)
+	if err != nil || !preferences["product_updates"] || preferences["newsletter"] {
+		t.Fatalf("expected the defaults of the categories; got %v, %v", preferences, err)
+	}
+
+	if err := notification.SetSubscribed(db, alice.ID, "newsletter", true); err != nil {
+		t.Fatalf("error subscribing. Err: %v", err)
+	}
+	if err := notification.SetSubscribed(db, alice.ID, "product_updates", false); err != nil {
+		t.Fatalf("error unsubscribing. Err: %v", err)
+	}
+	// Saving again updates the preference
+	if err := notification.SetSubscribed(db, alice.ID, "product_updates", false); err != nil {
+		t.Fatalf("error unsubscribing again. Err: %v", err)
+	}
+	preferences, _ = notification.Preferences(db, alice.ID)
+	if preferences["product_updates"] || !preferences["newsletter"] {
+		t.Errorf("expected the stored preferences; got %v", preferences)
+	}
+	if err := notification.SetSubscribed(db, alice.ID, "unknown", true); !errors.Is(err, notification.ErrUnknownCategory) {
+		t.Errorf("expected ErrUnknownCategory; got %v", err)
+	}
+}
+
+func TestSendNotification(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice", "alice@example.org")
+	recorder := &recordingMail{}
+	c := &config.Config{App: config.App{Name: "Test App", Url: "https://app.example.org"}}
+	mailer := emails.New(c, recorder)
+
+	if err := mailer.SendNotification(db, alice, "product_updates", "What's new", newsletter(c)); err != nil {
+		t.Fatalf("error sending notification. Err: %v", err)
+	}
+	if len(recorder.messages) != 1 {
+		t.Fatalf("expected 1 message; got %d", len(recorder.messages))
+	}
+	message := recorder.messages[0]
+	link := "https://app.example.org/unsubscribe?token=" + notification.UnsubscribeToken(alice.ID, "product_updates")
+	if message.Headers["List-Unsubscribe"] != "<"+link+">" || message.Headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+		t.Errorf("expected the one-click unsubscribe headers; got %v", message.Headers)
+	}
+	if message.Tags["category"] != "product_updates" {
+		t.Errorf("expected the category tag; got %v", message.Tags)
+	}
+	if !strings.Contains(message.HTML, templ.EscapeString(link)) || !strings.Contains(message.HTML, "/user/profile/notifications") {
+		t.Errorf("expected the unsubscribe link in the footer; got %s", message.HTML)
+	}
+
+	// Newsletters are opt-in
+	if err := mailer.SendNotification(db, alice, "newsletter", "News", newsletter(c)); !errors.Is(err, notification.ErrUnsubscribed) {
+		t.Errorf("expected ErrUnsubscribed; got %v", err)
+	}
+	notification.SetSubscribed(db, alice.ID, "product_updates", false)
+	if err := mailer.SendNotification(db, alice, "product_updates", "What's new", newsletter(c)); !errors.Is(err, notification.ErrUnsubscribed) {
+		t.Errorf("expected ErrUnsubscribed after unsubscribing; got %v", err)
+	}
+
+	// Transactional mails are always sent and have no unsubscribe link
+	if err := mailer.SendPasswordReset(alice, "https://app.example.org/auth/reset-password?token=abc"); err != nil {
+		t.Fatalf("error sending password reset. Err: %v", err)
+	}
+	reset := recorder.messages[len(recorder.messages)-1]
+	if len(recorder.messages) != 2 || reset.Headers["List-Unsubscribe"] != "" || strings.Contains(reset.HTML, "/unsubscribe") {
+		t.Errorf("expected the password reset without unsubscribe; got %+v", reset)
+	}
+}
+
+func TestOneClickUnsubscribe(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice", "alice@example.org")
+	token := notification.UnsubscribeToken(alice.ID, "product_updates")
+	handler := unsubscribe.New(db)
+
+	// Opening the link only asks to confirm
+	request := httptest.NewRequest(http.MethodGet, "/unsubscribe?token="+url.QueryEscape(token), nil)
+	request = request.WithContext(context.WithValue(request.Context(), mw.ConfigKey, &config.Config{}))
+	recorder := httptest.NewRecorder()
+	handler.GET(recorder, request)
+	if !strings.Contains(recorder.Body.String(), "Product updates") {
+		t.Errorf("expected a confirm page; got %s", recorder.Body.String())
+	}
+	if subscribed, _ := notification.IsSubscribed(db, alice.ID, "product_updates"); !subscribed {
+		t.Fatalf("expected opening the link not to unsubscribe")
+	}
+
+	// The mail client posts to the link of the header
+	request = httptest.NewRequest(http.MethodPost, "/unsubscribe?token="+url.QueryEscape(token), strings.NewReader("List-Unsubscribe=One-Click"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	handler.POST(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected the one-click unsubscribe to succeed; got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if subscribed, _ := notification.IsSubscribed(db, alice.ID, "product_updates"); subscribed {
+		t.Errorf("expected alice to be unsubscribed")
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/unsubscribe?token=invalid", strings.NewReader("List-Unsubscribe=One-Click"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	handler.POST(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid token to be rejected; got %d", recorder.Code)
+	}
+}
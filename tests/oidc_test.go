@@ -0,0 +1,330 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	mw "atomic-go-template/internal/middleware"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/oidc"
+	"atomic-go-template/internal/store"
+	"atomic-go-template/web/routes/oidc/authorize"
+	"atomic-go-template/web/routes/oidc/jwks"
+	"atomic-go-template/web/routes/oidc/token"
+	"atomic-go-template/web/routes/oidc/userinfo"
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const oidcRedirectURI = "https://client.example.org/callback"
+
+type oidcTest struct {
+	server   *httptest.Server
+	provider *oidc.Provider
+	user     model.User
+	// Does not follow redirects, the redirects to the client are checked instead
+	client *http.Client
+}
+
+// newOIDCTest serves the provider routes with a logged in user, the login itself is tested elsewhere
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Setenv("OIDC_SIGNING_KEY_FILE", "")
+	db := newTestDB(t)
+	now := time.Now()
+	alice := model.User{Username: "alice", Email: "alice@example.org", VerifiedAt: &now}
+	if err := db.Create(&alice).Error; err != nil {
+		t.Fatalf("error creating user. Err: %v", err)
+	}
+
+	c := config.New(nil)
+	router := chi.NewRouter()
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	c.App.Url = server.URL
+	provider, err := oidc.NewProvider(db, c)
+	if err != nil {
+		t.Fatalf("error creating provider. Err: %v", err)
+	}
+
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), mw.ConfigKey, c)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, mw.UserKey, alice)))
+		})
+	})
+	router.Get("/oidc/jwks", jwks.New(provider).GET)
+	router.Get("/oidc/authorize", authorize.New(c, provider).GET)
+	router.Post("/oidc/token", token.New(provider).POST)
+	router.Get("/oidc/userinfo", userinfo.New(store.NewGormUserStore(db), provider).GET)
+
+	return &oidcTest{
+		server:   server,
+		provider: provider,
+		user:     alice,
+		client: &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}},
+	}
+}
+
+// newClient registers a client of the provider, the secret is empty for public clients
+func (o *oidcTest) newClient(t *testing.T, public bool) (string, string) {
+	client, secret, err := o.provider.CreateClient(model.CreateOIDCClientInput{
+		Name:         "Client",
+		RedirectURIs: oidcRedirectURI,
+		Public:       public,
+		SkipConsent:  true,
+	})
+	if err != nil {
+		t.Fatalf("error creating client. Err: %v", err)
+	}
+	return client.ClientID, secret
+}
+
+// authorize sends the authorization request and returns the redirect, the response if there is none
+func (o *oidcTest) authorize(t *testing.T, values url.Values) (*url.URL, *http.Response) {
+	response, err := o.client.Get(o.server.URL + "/oidc/authorize?" + values.Encode())
+	if err != nil {
+		t.Fatalf("error sending authorization request. Err: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusSeeOther {
+		return nil, response
+	}
+	location, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("error parsing redirect. Err: %v", err)
+	}
+	return location, response
+}
+
+func (o *oidcTest) code(t *testing.T, values url.Values) string {
+	location, response := o.authorize(t, values)
+	if location == nil || !strings.HasPrefix(location.String(), oidcRedirectURI+"?") || location.Query().Get("code") == "" {
+		t.Fatalf("expected a redirect with a code; got %d %v", response.StatusCode, location)
+	}
+	return location.Query().Get("code")
+}
+
+// exchange sends the token request, the client authenticates with basic auth if clientSecret is set
+func (o *oidcTest) exchange(t *testing.T, clientID, clientSecret string, values url.Values) (int, map[string]interface{}) {
+	values.Set("grant_type", "authorization_code")
+	request, _ := http.NewRequest(http.MethodPost, o.server.URL+"/oidc/token", strings.NewReader(values.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientSecret != "" {
+		request.SetBasicAuth(clientID, clientSecret)
+	}
+	response, err := o.client.Do(request)
+	if err != nil {
+		t.Fatalf("error sending token request. Err: %v", err)
+	}
+	defer response.Body.Close()
+	body := map[string]interface{}{}
+	json.NewDecoder(response.Body).Decode(&body)
+	return response.StatusCode, body
+}
+
+// publicKey reads the signing key from the JWKS endpoint, as clients do
+func (o *oidcTest) publicKey(t *testing.T) *rsa.PublicKey {
+	response, err := http.Get(o.server.URL + "/oidc/jwks")
+	if err != nil {
+		t.Fatalf("error fetching jwks. Err: %v", err)
+	}
+	defer response.Body.Close()
+	var keys struct {
+		Keys []struct {
+			N string `json:"n"`
+			E string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&keys); err != nil || len(keys.Keys) != 1 {
+		t.Fatalf("expected one key; got %+v, %v", keys, err)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(keys.Keys[0].N)
+	e, _ := base64.RawURLEncoding.DecodeString(keys.Keys[0].E)
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+}
+
+func (o *oidcTest) userinfo(t *testing.T, accessToken string) (int, map[string]interface{}) {
+	request, _ := http.NewRequest(http.MethodGet, o.server.URL+"/oidc/userinfo", nil)
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	response, err := o.client.Do(request)
+	if err != nil {
+		t.Fatalf("error sending userinfo request. Err: %v", err)
+	}
+	defer response.Body.Close()
+	body := map[string]interface{}{}
+	json.NewDecoder(response.Body).Decode(&body)
+	return response.StatusCode, body
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	o := newOIDCTest(t)
+	clientID, secret := o.newClient(t, false)
+
+	location, _ := o.authorize(t, url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientID},
+		"redirect_uri":  {oidcRedirectURI},
+		"scope":         {"openid email profile"},
+		"state":         {"state-123"},
+		"nonce":         {"nonce-456"},
+	})
+	if location == nil || location.Query().Get("state") != "state-123" {
+		t.Fatalf("expected a redirect with the state; got %v", location)
+	}
+	code := location.Query().Get("code")
+
+	status, body := o.exchange(t, clientID, secret, url.Values{"code": {code}, "redirect_uri": {oidcRedirectURI}})
+	if status != http.StatusOK || body["token_type"] != "Bearer" {
+		t.Fatalf("expected tokens; got %d %v", status, body)
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(body["id_token"].(string), claims, func(token *jwt.Token) (interface{}, error) {
+		return o.publicKey(t), nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(o.server.URL), jwt.WithAudience(clientID))
+	if err != nil {
+		t.Fatalf("expected a valid id token; got %v", err)
+	}
+	if claims["sub"] != o.user.ID.String() || claims["nonce"] != "nonce-456" || claims["email"] != "alice@example.org" || claims["email_verified"] != true {
+		t.Errorf("expected the claims of alice; got %v", claims)
+	}
+
+	status, info := o.userinfo(t, body["access_token"].(string))
+	if status != http.StatusOK || info["sub"] != o.user.ID.String() || info["email"] != "alice@example.org" || info["preferred_username"] != "alice" {
+		t.Errorf("expected the userinfo of alice; got %d %v", status, info)
+	}
+	// The id token is no access token
+	if status, _ := o.userinfo(t, body["id_token"].(string)); status != http.StatusUnauthorized {
+		t.Errorf("expected the id token to be rejected; got %d", status)
+	}
+	if status, _ := o.userinfo(t, "invalid"); status != http.StatusUnauthorized {
+		t.Errorf("expected an invalid token to be rejected; got %d", status)
+	}
+
+	// Codes can only be used once
+	status, body = o.exchange(t, clientID, secret, url.Values{"code": {code}, "redirect_uri": {oidcRedirectURI}})
+	if status != http.StatusBadRequest || body["error"] != oidc.ErrorInvalidGrant {
+		t.Errorf("expected a reused code to be rejected; got %d %v", status, body)
+	}
+}
+
+func TestOIDCPKCE(t *testing.T) {
+	o := newOIDCTest(t)
+	clientID, _ := o.newClient(t, true)
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	request := url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientID},
+		"redirect_uri":  {oidcRedirectURI},
+		"scope":         {"openid"},
+	}
+
+	// Public clients must use PKCE with S256, the error is sent back to the client
+	location, _ := o.authorize(t, request)
+	if location == nil || location.Query().Get("error") != oidc.ErrorInvalidRequest {
+		t.Errorf("expected public clients without PKCE to be rejected; got %v", location)
+	}
+	plain := url.Values{"code_challenge": {verifier}, "code_challenge_method": {"plain"}}
+	for key, value := range request {
+		plain[key] = value
+	}
+	location, _ = o.authorize(t, plain)
+	if location == nil || location.Query().Get("error") != oidc.ErrorInvalidRequest {
+		t.Errorf("expected the plain method to be rejected; got %v", location)
+	}
+
+	request.Set("code_challenge", challenge)
+	request.Set("code_challenge_method", "S256")
+	code := o.code(t, request)
+
+	tests := map[string]string{
+		"missing verifier": "",
+		"wrong verifier":   "wrong-verifier-wrong-verifier-wrong-verifier",
+	}
+	for name, codeVerifier := range tests {
+		t.Run(name, func(t *testing.T) {
+			status, body := o.exchange(t, clientID, "", url.Values{"code": {code}, "client_id": {clientID}, "redirect_uri": {oidcRedirectURI}, "code_verifier": {codeVerifier}})
+			if status != http.StatusBadRequest || body["error"] != oidc.ErrorInvalidGrant {
+				t.Errorf("expected invalid_grant; got %d %v", status, body)
+			}
+		})
+	}
+
+	status, body := o.exchange(t, clientID, "", url.Values{"code": {code}, "client_id": {clientID}, "redirect_uri": {oidcRedirectURI}, "code_verifier": {verifier}})
+	if status != http.StatusOK || body["access_token"] == nil {
+		t.Errorf("expected tokens for the right verifier; got %d %v", status, body)
+	}
+}
+
+func TestOIDCRejectsInvalidClients(t *testing.T) {
+	o := newOIDCTest(t)
+	clientID, secret := o.newClient(t, false)
+	request := url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientID},
+		"redirect_uri":  {oidcRedirectURI},
+		"scope":         {"openid"},
+	}
+
+	// Invalid clients and redirect URIs get an error page, we must not redirect to them
+	for name, values := range map[string]url.Values{
+		"unknown client":        {"client_id": {"unknown"}},
+		"unregistered redirect": {"redirect_uri": {"https://attacker.example.org/callback"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			invalid := url.Values{}
+			for key, value := range request {
+				invalid[key] = value
+			}
+			for key, value := range values {
+				invalid[key] = value
+			}
+			if location, response := o.authorize(t, invalid); location != nil || response.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected an error page; got %d %v", response.StatusCode, location)
+			}
+		})
+	}
+
+	code := o.code(t, request)
+	for name, test := range map[string]struct {
+		secret      string
+		redirectURI string
+		status      int
+		error       string
+	}{
+		"wrong secret":          {"wrong", oidcRedirectURI, http.StatusUnauthorized, oidc.ErrorInvalidClient},
+		"missing secret":        {"", oidcRedirectURI, http.StatusUnauthorized, oidc.ErrorInvalidClient},
+		"redirect_uri mismatch": {secret, "https://client.example.org/other", http.StatusBadRequest, oidc.ErrorInvalidGrant},
+	} {
+		t.Run(name, func(t *testing.T) {
+			values := url.Values{"code": {code}, "redirect_uri": {test.redirectURI}}
+			if test.secret == "" {
+				values.Set("client_id", clientID)
+			}
+			status, body := o.exchange(t, clientID, test.secret, values)
+			if status != test.status || body["error"] != test.error {
+				t.Errorf("expected %d %s; got %d %v", test.status, test.error, status, body)
+			}
+		})
+	}
+
+	// Rejected requests don't use up the code
+	if status, body := o.exchange(t, clientID, secret, url.Values{"code": {code}, "redirect_uri": {oidcRedirectURI}}); status != http.StatusOK {
+		t.Errorf("expected the code to be redeemed; got %d %v", status, body)
+	}
+}
@@ -0,0 +1,288 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/mail"
+	"atomic-go-template/internal/model"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"gorm.io/gorm"
+)
+
+// failingMail returns the queued errors one by one before it starts recording messages
+type failingMail struct {
+	recordingMail
+	errs     []error
+	attempts int
+}
+
+func (f *failingMail) Send(to, subject, body string) error {
+	return f.SendMessage(mail.Message{To: []string{to}, Subject: subject, HTML: body})
+}
+
+func (f *failingMail) SendMessage(message mail.Message) error {
+	f.attempts++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return err
+	}
+	return f.recordingMail.SendMessage(message)
+}
+
+func newTestOutbox(t *testing.T, provider mail.Service, options mail.OutboxOptions) (*mail.Outbox, *gorm.DB) {
+	db := newTestDB(t)
+	if options.MaxAttempts == 0 {
+		options.MaxAttempts = 3
+	}
+	if options.RetryDelay == 0 {
+		options.RetryDelay = time.Minute
+	}
+	return mail.NewOutbox(db, provider, options), db
+}
+
+func outboxMessages(t *testing.T, db *gorm.DB) []model.OutboxMessage {
+	var messages []model.OutboxMessage
+	if err := db.Order("created_at").Find(&messages).Error; err != nil {
+		t.Fatalf("error loading outbox. Err: %v", err)
+	}
+	return messages
+}
+
+// makeDue moves the next attempt of all pending messages into the past
+func makeDue(t *testing.T, db *gorm.DB) {
+	err := db.Model(&model.OutboxMessage{}).Where("status = ?", model.OutboxStatusPending).
+		Update("next_attempt_at", time.Now().Add(-time.Second)).Error
+	if err != nil {
+		t.Fatalf("error updating outbox. Err: %v", err)
+	}
+}
+
+func processDue(t *testing.T, outbox *mail.Outbox) int {
+	delivered, err := outbox.ProcessDue(context.Background())
+	if err != nil {
+		t.Fatalf("error processing outbox. Err: %v", err)
+	}
+	return delivered
+}
+
+func TestOutboxQueuesAndDelivers(t *testing.T) {
+	provider := &failingMail{}
+	outbox, db := newTestOutbox(t, provider, mail.OutboxOptions{})
+
+	message := mail.Message{
+		To:      []string{"Alice <Alice@Example.org>"},
+		Subject: "Welcome",
+		HTML:    "<p>Hi</p>",
+		Tags:    map[string]string{"category": "welcome"},
+	}
+	if err := outbox.SendMessage(message); err != nil {
+		t.Fatalf("error queueing message. Err: %v", err)
+	}
+	if provider.attempts != 0 {
+		t.Fatalf("expected the provider not to be called while queueing")
+	}
+
+	queued := outboxMessages(t, db)
+	if len(queued) != 1 || queued[0].Status != model.OutboxStatusPending || queued[0].Recipient != "alice@example.org" {
+		t.Fatalf("expected one pending message for alice@example.org; got %+v", queued)
+	}
+
+	if delivered := processDue(t, outbox); delivered != 1 {
+		t.Fatalf("expected 1 delivered message; got %d", delivered)
+	}
+	if len(provider.messages) != 1 || provider.messages[0].Subject != "Welcome" || provider.messages[0].Tags["category"] != "welcome" {
+		t.Fatalf("expected the message to reach the provider; got %+v", provider.messages)
+	}
+
+	sent := outboxMessages(t, db)[0]
+	if sent.Status != model.OutboxStatusSent || sent.SentAt == nil || sent.Attempts != 1 {
+		t.Errorf("expected the message to be marked sent; got %+v", sent)
+	}
+	if delivered := processDue(t, outbox); delivered != 0 {
+		t.Errorf("expected sent messages not to be delivered again; got %d", delivered)
+	}
+}
+
+func TestOutboxRejectsInvalidMessages(t *testing.T) {
+	outbox, db := newTestOutbox(t, &failingMail{}, mail.OutboxOptions{})
+
+	err := outbox.SendMessage(mail.Message{Subject: "No recipients"})
+	if !errors.Is(err, mail.ErrInvalidMessage) {
+		t.Fatalf("expected ErrInvalidMessage; got %v", err)
+	}
+	if messages := outboxMessages(t, db); len(messages) != 0 {
+		t.Errorf("expected nothing to be queued; got %d messages", len(messages))
+	}
+}
+
+func TestOutboxRetriesWithBackoff(t *testing.T) {
+	provider := &failingMail{errs: []error{errors.New("connection refused"), errors.New("connection refused")}}
+	outbox, db := newTestOutbox(t, provider, mail.OutboxOptions{RetryDelay: time.Minute, MaxRetryDelay: time.Hour})
+
+	if err := outbox.Send("alice@example.org", "Reset", "<p>Reset</p>"); err != nil {
+		t.Fatalf("error queueing message. Err: %v", err)
+	}
+
+	start := time.Now()
+	processDue(t, outbox)
+	failed := outboxMessages(t, db)[0]
+	if failed.Status != model.OutboxStatusPending || failed.Attempts != 1 || failed.LastError == nil {
+		t.Fatalf("expected a pending message with one failed attempt; got %+v", failed)
+	}
+	firstDelay := failed.NextAttemptAt.Sub(start)
+	if firstDelay < time.Minute || firstDelay > 2*time.Minute {
+		t.Errorf("expected the first retry in about a minute; got %v", firstDelay)
+	}
+
+	// Not due yet
+	processDue(t, outbox)
+	if provider.attempts != 1 {
+		t.Fatalf("expected no attempt before the retry is due; got %d attempts", provider.attempts)
+	}
+
+	makeDue(t, db)
+	start = time.Now()
+	processDue(t, outbox)
+	failed = outboxMessages(t, db)[0]
+	secondDelay := failed.NextAttemptAt.Sub(start)
+	if failed.Attempts != 2 || secondDelay < 2*time.Minute || secondDelay > 3*time.Minute {
+		t.Errorf("expected the delay to double after the second attempt; got %v after %d attempts", secondDelay, failed.Attempts)
+	}
+
+	makeDue(t, db)
+	if delivered := processDue(t, outbox); delivered != 1 {
+		t.Fatalf("expected the third attempt to deliver the message; got %d", delivered)
+	}
+	if sent := outboxMessages(t, db)[0]; sent.Status != model.OutboxStatusSent || sent.LastError != nil {
+		t.Errorf("expected the message to be sent without error; got %+v", sent)
+	}
+}
+
+func TestOutboxDeadLettersAfterMaxAttempts(t *testing.T) {
+	provider := &failingMail{errs: []error{errors.New("timeout"), errors.New("timeout")}}
+	outbox, db := newTestOutbox(t, provider, mail.OutboxOptions{MaxAttempts: 2})
+
+	if err := outbox.Send("alice@example.org", "Reset", "<p>Reset</p>"); err != nil {
+		t.Fatalf("error queueing message. Err: %v", err)
+	}
+	processDue(t, outbox)
+	makeDue(t, db)
+	processDue(t, outbox)
+
+	dead := outboxMessages(t, db)[0]
+	if dead.Status != model.OutboxStatusDead || dead.Attempts != 2 {
+		t.Fatalf("expected the message to be dead-lettered after 2 attempts; got %+v", dead)
+	}
+	makeDue(t, db)
+	processDue(t, outbox)
+	if provider.attempts != 2 {
+		t.Errorf("expected no attempts for dead messages; got %d attempts", provider.attempts)
+	}
+
+	// Admins retry it once the provider works again
+	if err := outbox.Retry(dead.ID); err != nil {
+		t.Fatalf("error retrying message. Err: %v", err)
+	}
+	if delivered := processDue(t, outbox); delivered != 1 {
+		t.Fatalf("expected the retried message to be delivered; got %d", delivered)
+	}
+	if err := outbox.Retry(dead.ID); !errors.Is(err, mail.ErrOutboxMessageNotFound) {
+		t.Errorf("expected sent messages not to be retried; got %v", err)
+	}
+}
+
+func TestOutboxDeadLettersPermanentFailures(t *testing.T) {
+	// The in-process server rejects nobody@ with 550 like the configured SMTP provider would
+	_, options := newSMTPServer(t, config.SMTPTLSModeNone, config.SMTPAuthPlain)
+	service := newSMTPService(t, options)
+	outbox, db := newTestOutbox(t, service, mail.OutboxOptions{MaxAttempts: 5})
+
+	if err := outbox.Send("nobody@example.org", "Hello", "<p>Hello</p>"); err != nil {
+		t.Fatalf("error queueing message. Err: %v", err)
+	}
+	processDue(t, outbox)
+
+	dead := outboxMessages(t, db)[0]
+	if dead.Status != model.OutboxStatusDead || dead.Attempts != 1 {
+		t.Errorf("expected a rejected recipient to be dead-lettered right away; got %+v", dead)
+	}
+
+	rejected := service.Send("nobody@example.org", "Hello", "<p>Hello</p>")
+	greylisted := service.Send("greylisted@example.org", "Hello", "<p>Hello</p>")
+	wrongPassword := options
+	wrongPassword.Password = "wrong"
+	authFailed := newSMTPService(t, wrongPassword).Send("alice@example.org", "Hello", "<p>Hello</p>")
+
+	cases := map[error]bool{
+		rejected:   true,
+		greylisted: false,
+		authFailed: false,
+		errors.Join(errors.New("send"), mail.ErrInvalidMessage): true,
+		errors.New("dial tcp: connection refused"):              false,
+		// The inbound server and other libraries use the errors of emersion/go-smtp
+		&smtp.SMTPError{Code: 550, Message: "No such user"}: true,
+	}
+	for err, permanent := range cases {
+		if err == nil {
+			t.Fatalf("expected the server to reject the mail")
+		}
+		if mail.IsPermanentError(err) != permanent {
+			t.Errorf("IsPermanentError(%v) = %v; expected %v", err, !permanent, permanent)
+		}
+	}
+}
+
+func TestOutboxThrottlesPerRecipient(t *testing.T) {
+	provider := &failingMail{}
+	outbox, db := newTestOutbox(t, provider, mail.OutboxOptions{RecipientLimit: 2, RecipientWindow: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		if err := outbox.Send("alice@example.org", "Code", "<p>Code</p>"); err != nil {
+			t.Fatalf("error queueing message. Err: %v", err)
+		}
+	}
+	if err := outbox.Send("bob@example.org", "Code", "<p>Code</p>"); err != nil {
+		t.Fatalf("error queueing message. Err: %v", err)
+	}
+
+	if delivered := processDue(t, outbox); delivered != 3 {
+		t.Fatalf("expected 2 messages to alice and 1 to bob; got %d", delivered)
+	}
+
+	var throttled model.OutboxMessage
+	if err := db.Where("status = ?", model.OutboxStatusPending).First(&throttled).Error; err != nil {
+		t.Fatalf("expected a throttled message. Err: %v", err)
+	}
+	if throttled.Recipient != "alice@example.org" || throttled.Attempts != 0 {
+		t.Errorf("expected the third mail to alice to wait without using an attempt; got %+v", throttled)
+	}
+	if wait := time.Until(throttled.NextAttemptAt); wait < 59*time.Minute {
+		t.Errorf("expected the message to wait until the window passed; got %v", wait)
+	}
+}
+
+func TestOutboxTakesOverStaleClaims(t *testing.T) {
+	provider := &failingMail{}
+	outbox, db := newTestOutbox(t, provider, mail.OutboxOptions{})
+
+	if err := outbox.Send("alice@example.org", "Hello", "<p>Hello</p>"); err != nil {
+		t.Fatalf("error queueing message. Err: %v", err)
+	}
+	// A worker crashed while sending
+	err := db.Model(&model.OutboxMessage{}).Where("1 = 1").Updates(map[string]interface{}{
+		"status":       model.OutboxStatusSending,
+		"locked_until": time.Now().Add(-time.Minute),
+	}).Error
+	if err != nil {
+		t.Fatalf("error updating outbox. Err: %v", err)
+	}
+
+	if delivered := processDue(t, outbox); delivered != 1 {
+		t.Errorf("expected the stale message to be delivered; got %d", delivered)
+	}
+}
@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	mw "atomic-go-template/internal/middleware"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/store"
+	"atomic-go-template/internal/utils"
+	"atomic-go-template/web/routes/auth/reauthenticate"
+	"atomic-go-template/web/routes/user/profile"
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/form/v4"
+	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// authCookie is the cookie of a login at authTime
+func authCookie(t *testing.T, user model.User, authTime time.Time) *http.Cookie {
+	claims := &utils.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Subject:   user.ID.String(),
+		},
+		AuthTime: jwt.NewNumericDate(authTime),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("SECRET_KEY")))
+	if err != nil {
+		t.Fatalf("error signing token. Err: %v", err)
+	}
+	return &http.Cookie{Name: "auth_token", Value: token}
+}
+
+// userRequest is a request of the logged in user, as the JWT middleware passes it on
+func userRequest(r *http.Request, c *config.Config, user model.User, cookie *http.Cookie) *http.Request {
+	r.AddCookie(cookie)
+	ctx := context.WithValue(r.Context(), mw.ConfigKey, c)
+	ctx = context.WithValue(ctx, mw.UserKey, user)
+	return r.WithContext(ctx)
+}
+
+func TestRequireRecentAuth(t *testing.T) {
+	c := &config.Config{Auth: config.Auth{RecentAuthMaxAge: 15 * time.Minute}}
+	m := mw.NewMiddleware(nil, nil, nil, c)
+	alice := model.User{Username: "alice", Email: "alice@example.org"}
+	handler := m.RequireRecentAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, userRequest(httptest.NewRequest(http.MethodGet, "/admin/database-backup/download", nil), c, alice, authCookie(t, alice, time.Now())))
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("expected a recent login to pass; got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler(recorder, userRequest(httptest.NewRequest(http.MethodGet, "/admin/database-backup/download", nil), c, alice, authCookie(t, alice, time.Now().Add(-time.Hour))))
+	if location := recorder.Header().Get("Location"); recorder.Code != http.StatusSeeOther || location != "/auth/reauthenticate?next=%2Fadmin%2Fdatabase-backup%2Fdownload" {
+		t.Errorf("expected a redirect to the re-authentication; got %d %s", recorder.Code, location)
+	}
+
+	// Forms are sent again from the page holding them
+	request := userRequest(httptest.NewRequest(http.MethodPost, "/user/profile", nil), c, alice, authCookie(t, alice, time.Now().Add(-time.Hour)))
+	request.Header.Set("HX-Request", "true")
+	request.Header.Set("Referer", "https://app.example.org/user/profile?tab=security")
+	recorder = httptest.NewRecorder()
+	handler(recorder, request)
+	if redirect := recorder.Header().Get("HX-Redirect"); redirect != "/auth/reauthenticate?next=%2Fuser%2Fprofile%3Ftab%3Dsecurity" {
+		t.Errorf("expected a client side redirect back to the form; got %q", redirect)
+	}
+}
+
+// postProfile sends the profile form as the browser does, with a login at authTime
+func postProfile(t *testing.T, users store.UserStore, c *config.Config, user model.User, authTime time.Time, fields map[string]string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	writer.Close()
+	request := httptest.NewRequest(http.MethodPost, "/user/profile", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	request.Header.Set("HX-Request", "true")
+	request.Header.Set("Referer", "https://app.example.org/user/profile")
+	recorder := httptest.NewRecorder()
+	handler := profile.New(users, c, validator.New(validator.WithRequiredStructEnabled()), form.NewDecoder(), &recordingMail{})
+	handler.POST(recorder, userRequest(request, c, user, authCookie(t, user, authTime)))
+	return recorder
+}
+
+func TestProfileRequiresRecentAuthForEmailAndPassword(t *testing.T) {
+	c := &config.Config{Auth: config.Auth{RecentAuthMaxAge: 15 * time.Minute}}
+	users := store.NewMemoryUserStore(model.User{Username: "alice", Email: "alice@example.org"})
+	alice, _ := users.ByEmail(context.Background(), "alice@example.org")
+	stale := time.Now().Add(-time.Hour)
+
+	// The username and avatar don't need a recent login
+	recorder := postProfile(t, users, c, alice, stale, map[string]string{"username": "alice2", "email": "alice@example.org"})
+	if changed, _ := users.ByID(context.Background(), alice.ID); changed.Username != "alice2" || recorder.Header().Get("HX-Redirect") != "" {
+		t.Errorf("expected the username to change without a recent login; got %q, %s", changed.Username, recorder.Body.String())
+	}
+
+	tests := map[string]map[string]string{
+		"email":    {"username": "alice2", "email": "new@example.org"},
+		"password": {"username": "alice2", "email": "alice@example.org", "password": "new-password", "confirm_password": "new-password"},
+	}
+	for name, fields := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := postProfile(t, users, c, alice, stale, fields)
+			if redirect := recorder.Header().Get("HX-Redirect"); redirect != "/auth/reauthenticate?next=%2Fuser%2Fprofile" {
+				t.Errorf("expected a redirect to the re-authentication; got %q", redirect)
+			}
+			if unchanged, _ := users.ByID(context.Background(), alice.ID); unchanged.Email != "alice@example.org" || unchanged.Password != nil {
+				t.Errorf("expected the user to be unchanged; got %+v", unchanged)
+			}
+		})
+	}
+
+	postProfile(t, users, c, alice, time.Now(), map[string]string{"username": "alice2", "email": "new@example.org"})
+	if changed, _ := users.ByID(context.Background(), alice.ID); changed.Email != "new@example.org" {
+		t.Errorf("expected the email to change after a recent login; got %q", changed.Email)
+	}
+}
+
+func TestReauthenticateOffersSingleSignOn(t *testing.T) {
+	s := newSAMLTest(t)
+	c := &config.Config{}
+	provider := "saml:corp"
+	hashedPassword, _ := utils.HashPassword("local-password")
+	users := store.NewMemoryUserStore(
+		model.User{Username: "carol", Email: "carol@corp.example.org", OAuthProvider: &provider},
+		model.User{Username: "alice", Email: "alice@example.org", Password: &hashedPassword},
+	)
+	handler := reauthenticate.New(users, c, validator.New(), form.NewDecoder(), nil, s.sso)
+
+	get := func(email string) string {
+		user, _ := users.ByEmail(context.Background(), email)
+		// The context user has no password, see the JWT middleware
+		user.Password = nil
+		recorder := httptest.NewRecorder()
+		handler.GET(recorder, userRequest(httptest.NewRequest(http.MethodGet, "/auth/reauthenticate?next=/user/profile", nil), c, user, authCookie(t, user, time.Now())))
+		return recorder.Body.String()
+	}
+
+	carol := get("carol@corp.example.org")
+	if !strings.Contains(carol, `href="/saml/corp/login?next=%2Fuser%2Fprofile"`) || strings.Contains(carol, `name="password"`) {
+		t.Errorf("expected users of single sign-on to log in at their identity provider; got %s", carol)
+	}
+	alice := get("alice@example.org")
+	if strings.Contains(alice, "/saml/") || !strings.Contains(alice, `name="password"`) {
+		t.Errorf("expected local users to enter their password; got %s", alice)
+	}
+}
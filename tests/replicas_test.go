@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/database"
+	mw "atomic-go-template/internal/middleware"
+	"atomic-go-template/internal/model"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// newReplicaDB returns a primary with alice and a replica with bob, so the user found tells where a query went
+func newReplicaDB(t *testing.T, options database.ReplicaOptions) (*gorm.DB, *database.ReplicaRouter, *sql.DB) {
+	primary := newTestDB(t)
+	createTestUser(t, primary, "alice", "alice@example.org")
+
+	replicaService, err := database.NewSQLiteService(context.Background(), config.Database{DSN: "file:" + t.Name() + "-replica?mode=memory&cache=shared"})
+	if err != nil {
+		t.Fatalf("error opening replica. Err: %v", err)
+	}
+	replicaDB := replicaService.GetDB()
+	if err := database.Migrate(context.Background(), replicaDB); err != nil {
+		t.Fatalf("error migrating replica. Err: %v", err)
+	}
+	createTestUser(t, replicaDB, "bob", "bob@example.org")
+	replica, _ := replicaDB.DB()
+
+	router := database.NewReplicaRouter([]database.Replica{{Name: "replica", DB: replica}}, options)
+	if err := primary.Use(router); err != nil {
+		t.Fatalf("error registering router. Err: %v", err)
+	}
+	return primary, router, replica
+}
+
+func findUsername(db *gorm.DB) string {
+	var user model.User
+	db.First(&user)
+	return user.Username
+}
+
+func TestReplicaRouting(t *testing.T) {
+	db, router, _ := newReplicaDB(t, database.ReplicaOptions{})
+
+	if username := findUsername(db); username != "bob" {
+		t.Errorf("expected reads to go to the replica; got %s", username)
+	}
+	var count int64
+	db.Raw("SELECT count(*) FROM users WHERE username = 'bob'").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected raw reads to go to the replica; got %d", count)
+	}
+
+	if username := findUsername(db.WithContext(database.WithPrimary(context.Background()))); username != "alice" {
+		t.Errorf("expected WithPrimary to read from the primary; got %s", username)
+	}
+	db.Transaction(func(tx *gorm.DB) error {
+		if username := findUsername(tx); username != "alice" {
+			t.Errorf("expected transactions to read from the primary; got %s", username)
+		}
+		return nil
+	})
+
+	createTestUser(t, db, "carol", "carol@example.org")
+	db.WithContext(database.WithPrimary(context.Background())).Model(&model.User{}).Where("username = ?", "carol").Count(&count)
+	if count != 1 {
+		t.Errorf("expected writes to go to the primary; got %d", count)
+	}
+
+	if status := router.Status(); len(status) != 1 || status[0].Reads != 2 || !status[0].Healthy {
+		t.Errorf("expected two reads of the healthy replica; got %+v", status)
+	}
+}
+
+func TestUnhealthyReplicaIsSkipped(t *testing.T) {
+	db, router, _ := newReplicaDB(t, database.ReplicaOptions{
+		MaxLag: time.Second,
+		Lag: func(ctx context.Context, db *sql.DB) (time.Duration, error) {
+			return time.Minute, nil
+		},
+	})
+	router.Check(context.Background())
+
+	if username := findUsername(db); username != "alice" {
+		t.Errorf("expected the lagging replica to be skipped; got %s", username)
+	}
+	if status := router.Status(); status[0].Healthy || status[0].Error == "" || status[0].Lag != "1m0s" {
+		t.Errorf("expected the lag to be reported; got %+v", status)
+	}
+}
+
+func TestFailingReplicaIsSkipped(t *testing.T) {
+	db, router, _ := newReplicaDB(t, database.ReplicaOptions{
+		Lag: func(ctx context.Context, db *sql.DB) (time.Duration, error) {
+			return 0, errors.New("connection refused")
+		},
+	})
+	router.Check(context.Background())
+
+	if username := findUsername(db); username != "alice" {
+		t.Errorf("expected the failing replica to be skipped; got %s", username)
+	}
+	if status := router.Status(); status[0].Healthy || status[0].Error != "connection refused" {
+		t.Errorf("expected the error to be reported; got %+v", status)
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	c := config.New(nil)
+	c.Database.ReadYourWritesWindow = time.Minute
+	db, _, _ := newReplicaDB(t, database.ReplicaOptions{})
+	var primary bool
+	handler := mw.NewMiddleware(nil, nil, nil, c).ReadYourWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primary = findUsername(db.WithContext(r.Context())) == "alice"
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if primary {
+		t.Errorf("expected reads without mutation to go to the replica")
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/user/profile", nil))
+	cookies := recorder.Result().Cookies()
+	if !primary || len(cookies) != 1 {
+		t.Fatalf("expected a mutation to read from the primary and set the cookie; got %v, %v", primary, cookies)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/user/profile", nil)
+	request.AddCookie(cookies[0])
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if !primary {
+		t.Errorf("expected reads within the window to go to the primary")
+	}
+
+	expired := *cookies[0]
+	expired.Value = "1"
+	request = httptest.NewRequest(http.MethodGet, "/user/profile", nil)
+	request.AddCookie(&expired)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if primary {
+		t.Errorf("expected reads after the window to go to the replica")
+	}
+}
@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"atomic-go-template/internal/mail"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/resend/resend-go/v2"
+)
+
+func TestResendSendsMessage(t *testing.T) {
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/emails" || r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Errorf("error parsing request. Err: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"email-1"}`))
+	}))
+	defer server.Close()
+
+	client := resend.NewClient("test-key")
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	err := mail.NewResendServiceWithClient(client).SendMessage(mail.Message{
+		To:          []string{"alice@example.org"},
+		Cc:          []string{"bob@example.org"},
+		Bcc:         []string{"carol@example.org"},
+		ReplyTo:     "support@example.org",
+		Subject:     "Hello",
+		HTML:        `<img src="cid:logo">`,
+		Text:        "Hello",
+		Headers:     map[string]string{"X-Campaign": "spring"},
+		Tags:        map[string]string{"category": "welcome"},
+		Attachments: []mail.Attachment{{Filename: "logo.png", Content: []byte("png"), ContentID: "logo"}},
+	})
+	if err != nil {
+		t.Fatalf("error sending mail. Err: %v", err)
+	}
+
+	expected := map[string]string{
+		`to`:          `["alice@example.org"]`,
+		`cc`:          `["bob@example.org"]`,
+		`bcc`:         `["carol@example.org"]`,
+		`reply_to`:    `"support@example.org"`,
+		`text`:        `"Hello"`,
+		`headers`:     `{"X-Campaign":"spring"}`,
+		`tags`:        `[{"name":"category","value":"welcome"}]`,
+		`attachments`: `[{"content":"cG5n","content_id":"logo","content_type":"image/png","filename":"logo.png"}]`,
+	}
+	for key, value := range expected {
+		actual, _ := json.Marshal(request[key])
+		if string(actual) != value {
+			t.Errorf("expected %s to be %s; got %s", key, value, actual)
+		}
+	}
+}
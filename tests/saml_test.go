@@ -0,0 +1,352 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	mw "atomic-go-template/internal/middleware"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/sso"
+	"atomic-go-template/web/routes/saml/acs"
+	saml_login "atomic-go-template/web/routes/saml/login"
+	"atomic-go-template/web/routes/saml/metadata"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"html"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	spBaseURL = "https://app.example.org"
+	idpURL    = "https://idp.example.org"
+)
+
+// newKeyPair generates an RSA key with a self signed certificate
+func newKeyPair(t *testing.T, commonName string) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key. Err: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate. Err: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing certificate. Err: %v", err)
+	}
+	return key, certificate
+}
+
+// testSessions logs in every request at the identity provider as the same user
+type testSessions struct {
+	session *saml.Session
+}
+
+func (s testSessions) GetSession(w http.ResponseWriter, r *http.Request, req *saml.IdpAuthnRequest) *saml.Session {
+	return s.session
+}
+
+// testServiceProviders knows the metadata of the app
+type testServiceProviders struct {
+	metadata *saml.EntityDescriptor
+}
+
+func (s testServiceProviders) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	if serviceProviderID != s.metadata.EntityID {
+		return nil, os.ErrNotExist
+	}
+	return s.metadata, nil
+}
+
+// newTestIdentityProvider creates an identity provider with its own key pair, logging in alice
+func newTestIdentityProvider(t *testing.T) *saml.IdentityProvider {
+	key, certificate := newKeyPair(t, "idp.example.org")
+	metadataURL, _ := url.Parse(idpURL + "/metadata")
+	ssoURL, _ := url.Parse(idpURL + "/sso")
+	return &saml.IdentityProvider{
+		Key:         key,
+		Certificate: certificate,
+		MetadataURL: *metadataURL,
+		SSOURL:      *ssoURL,
+		SessionProvider: testSessions{session: &saml.Session{
+			ID:         "session-1",
+			CreateTime: time.Now(),
+			ExpireTime: time.Now().Add(time.Hour),
+			NameID:     "alice@corp.example.org",
+			UserName:   "alice",
+			UserEmail:  "alice@corp.example.org",
+			Groups:     []string{"Admins"},
+			CustomAttributes: []saml.Attribute{{
+				FriendlyName: "mail",
+				Name:         "urn:oid:0.9.2342.19200300.100.1.3",
+				Values:       []saml.AttributeValue{{Type: "xs:string", Value: "alice@corp.example.org"}},
+			}},
+		}},
+	}
+}
+
+type samlTest struct {
+	db     *gorm.DB
+	sso    *sso.Manager
+	router http.Handler
+	idp    *saml.IdentityProvider
+}
+
+// newSAMLTest wires the SAML routes of the app to an in-process identity provider
+func newSAMLTest(t *testing.T, configure ...func(*sso.IdentityProviderOptions)) *samlTest {
+	db := newTestDB(t)
+	idp := newTestIdentityProvider(t)
+	key, certificate := newKeyPair(t, "app.example.org")
+
+	options := sso.IdentityProviderOptions{
+		Name:              "corp",
+		Metadata:          idp.Metadata(),
+		Domains:           []string{"corp.example.org"},
+		EmailAttribute:    "mail",
+		UsernameAttribute: "uid",
+		GroupAttribute:    "eduPersonAffiliation",
+		GroupRoles:        map[string]model.Role{"admins": model.RoleAdmin},
+	}
+	for _, configure := range configure {
+		configure(&options)
+	}
+	manager, err := sso.New(db, spBaseURL, key, certificate, []sso.IdentityProviderOptions{options})
+	if err != nil {
+		t.Fatalf("error creating sso manager. Err: %v", err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(mw.NewMiddleware(nil, nil, nil, config.New(nil)).ConfigMiddleware)
+	router.Get("/saml/{idp}/metadata", metadata.New(manager).GET)
+	router.Get("/saml/{idp}/login", saml_login.New(manager).GET)
+	router.Post("/saml/{idp}/acs", acs.New(manager).POST)
+
+	test := &samlTest{db: db, sso: manager, router: router, idp: idp}
+	idp.ServiceProviderProvider = testServiceProviders{metadata: test.spMetadata(t)}
+	return test
+}
+
+func (s *samlTest) spMetadata(t *testing.T) *saml.EntityDescriptor {
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/saml/corp/metadata", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected metadata; got %v", rec.Code)
+	}
+	entity, err := samlsp.ParseMetadata(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("error parsing metadata. Err: %v", err)
+	}
+	return entity
+}
+
+var formValue = regexp.MustCompile(`name="(SAMLResponse|RelayState)" value="([^"]*)"`)
+
+// login starts the login at the app and returns the form the identity provider posts back and the request cookie
+func (s *samlTest) login(t *testing.T, idp *saml.IdentityProvider, next string) (url.Values, *http.Cookie) {
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/saml/corp/login?next="+url.QueryEscape(next), nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect to the identity provider; got %v", rec.Code)
+	}
+	location := rec.Header().Get("Location")
+	if !strings.HasPrefix(location, idpURL+"/sso?") {
+		t.Fatalf("expected redirect to %s/sso; got %s", idpURL, location)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != saml_login.RequestCookieName {
+		t.Fatalf("expected the request cookie; got %v", cookies)
+	}
+
+	idpRec := httptest.NewRecorder()
+	idp.ServeSSO(idpRec, httptest.NewRequest(http.MethodGet, location, nil))
+	if idpRec.Code != http.StatusOK {
+		t.Fatalf("identity provider rejected the request: %v %s", idpRec.Code, idpRec.Body.String())
+	}
+	form := url.Values{}
+	for _, match := range formValue.FindAllStringSubmatch(idpRec.Body.String(), -1) {
+		form.Set(match[1], html.UnescapeString(match[2]))
+	}
+	if form.Get("SAMLResponse") == "" {
+		t.Fatalf("expected a SAML response; got %s", idpRec.Body.String())
+	}
+	return form, cookies[0]
+}
+
+// postACS posts the response to the assertion consumer service
+func (s *samlTest) postACS(form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/saml/corp/acs", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func hasAuthCookie(rec *httptest.ResponseRecorder) bool {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "auth_token" && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSAMLLoginProvisionsUser(t *testing.T) {
+	s := newSAMLTest(t)
+
+	form, cookie := s.login(t, s.idp, "/protected")
+	if form.Get("RelayState") != "/protected" {
+		t.Errorf("expected relay state /protected; got %q", form.Get("RelayState"))
+	}
+	rec := s.postACS(form, cookie)
+	if rec.Code != http.StatusOK || !hasAuthCookie(rec) {
+		t.Fatalf("expected successful login; got %v %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "url=/protected") {
+		t.Errorf("expected redirect to /protected")
+	}
+
+	var user model.User
+	if err := s.db.First(&user, "email = ?", "alice@corp.example.org").Error; err != nil {
+		t.Fatalf("expected user to be created. Err: %v", err)
+	}
+	if user.Username != "alice" || user.Role != model.RoleAdmin || user.VerifiedAt == nil {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if user.OAuthProvider == nil || *user.OAuthProvider != "saml:corp" {
+		t.Errorf("expected provider saml:corp; got %v", user.OAuthProvider)
+	}
+}
+
+// assertEmail makes the identity provider assert the email for its user
+func (s *samlTest) assertEmail(email string) {
+	session := *s.idp.SessionProvider.(testSessions).session
+	session.NameID, session.UserEmail = email, email
+	session.CustomAttributes = []saml.Attribute{{
+		FriendlyName: "mail",
+		Name:         "urn:oid:0.9.2342.19200300.100.1.3",
+		Values:       []saml.AttributeValue{{Type: "xs:string", Value: email}},
+	}}
+	s.idp.SessionProvider = testSessions{session: &session}
+}
+
+func TestSAMLRejectsForeignDomain(t *testing.T) {
+	s := newSAMLTest(t, func(options *sso.IdentityProviderOptions) { options.LinkAccounts = true })
+	now := time.Now()
+	admin := model.User{Username: "admin", Email: "admin@example.org", VerifiedAt: &now, Role: model.RoleAdmin}
+	s.db.Create(&admin)
+
+	// The identity provider only serves corp.example.org
+	s.assertEmail("admin@example.org")
+	form, cookie := s.login(t, s.idp, "/")
+	rec := s.postACS(form, cookie)
+	if rec.Code != http.StatusForbidden || hasAuthCookie(rec) {
+		t.Fatalf("expected forbidden; got %v", rec.Code)
+	}
+	var count int64
+	s.db.Model(&model.User{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected no user to be provisioned; got %d users", count)
+	}
+}
+
+func TestSAMLLinksExistingAccountsOnlyIfAllowed(t *testing.T) {
+	for _, link := range []bool{false, true} {
+		t.Run(fmt.Sprintf("link %v", link), func(t *testing.T) {
+			s := newSAMLTest(t, func(options *sso.IdentityProviderOptions) { options.LinkAccounts = link })
+			now := time.Now()
+			local := model.User{Username: "alice-local", Email: "alice@corp.example.org", VerifiedAt: &now, Role: model.RoleUser}
+			s.db.Create(&local)
+
+			form, cookie := s.login(t, s.idp, "/")
+			rec := s.postACS(form, cookie)
+			if hasAuthCookie(rec) != link {
+				t.Errorf("expected login to be %v; got %v", link, rec.Code)
+			}
+			if !link && !strings.Contains(rec.Body.String(), "An account with your email exists already") {
+				t.Errorf("expected a hint to log in with the account")
+			}
+			var count int64
+			s.db.Model(&model.User{}).Count(&count)
+			if count != 1 {
+				t.Errorf("expected the account to be linked, not duplicated; got %d users", count)
+			}
+			s.db.First(&local, "id = ?", local.ID)
+			if linked := local.OAuthProvider != nil && *local.OAuthProvider == "saml:corp" && local.OAuthID != nil; linked != link {
+				t.Errorf("expected the provider and ID to be stored %v; got %v %v", link, local.OAuthProvider, local.OAuthID)
+			}
+		})
+	}
+}
+
+func TestSAMLRejectsUntrustedSignature(t *testing.T) {
+	s := newSAMLTest(t)
+
+	// Same entity ID and URLs, but signed with a key the app does not trust
+	rogue := newTestIdentityProvider(t)
+	rogue.ServiceProviderProvider = s.idp.ServiceProviderProvider
+
+	form, cookie := s.login(t, rogue, "/")
+	rec := s.postACS(form, cookie)
+	if rec.Code != http.StatusForbidden || hasAuthCookie(rec) {
+		t.Fatalf("expected forbidden; got %v", rec.Code)
+	}
+}
+
+func TestSAMLRejectsUnsolicitedResponse(t *testing.T) {
+	s := newSAMLTest(t)
+
+	form, _ := s.login(t, s.idp, "/")
+	rec := s.postACS(form, nil)
+	if rec.Code != http.StatusForbidden || hasAuthCookie(rec) {
+		t.Fatalf("expected forbidden; got %v", rec.Code)
+	}
+}
+
+func TestSAMLRejectsExpiredResponse(t *testing.T) {
+	s := newSAMLTest(t)
+
+	form, cookie := s.login(t, s.idp, "/")
+	timeNow := saml.TimeNow
+	saml.TimeNow = func() time.Time { return timeNow().Add(time.Hour) }
+	defer func() { saml.TimeNow = timeNow }()
+
+	rec := s.postACS(form, cookie)
+	if rec.Code != http.StatusForbidden || hasAuthCookie(rec) {
+		t.Fatalf("expected forbidden; got %v", rec.Code)
+	}
+}
+
+func TestSAMLProviderForEmail(t *testing.T) {
+	s := newSAMLTest(t)
+
+	if provider, ok := s.sso.ProviderForEmail("bob@Corp.Example.org"); !ok || provider.Name() != "corp" {
+		t.Errorf("expected corp for bob@Corp.Example.org")
+	}
+	if _, ok := s.sso.ProviderForEmail("bob@example.org"); ok {
+		t.Errorf("expected no identity provider for bob@example.org")
+	}
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"atomic-go-template/internal/broadcast"
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/seed"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSeedingIsIdempotent(t *testing.T) {
+	db := newTestDB(t)
+	c := &config.Config{App: config.App{Env: "local"}}
+	ctx := context.Background()
+
+	ran, err := seed.Run(ctx, db, c)
+	if err != nil || len(ran) != len(seed.Seeders) {
+		t.Fatalf("expected all seeders to run locally; got %v, %v", ran, err)
+	}
+	var users int64
+	db.Model(&model.User{}).Count(&users)
+	if users != 54 {
+		t.Errorf("expected the admin, 3 examples and 50 fake users; got %d", users)
+	}
+
+	if _, err := seed.Run(ctx, db, c); err != nil {
+		t.Fatalf("error seeding again. Err: %v", err)
+	}
+	var again int64
+	db.Model(&model.User{}).Count(&again)
+	if again != users {
+		t.Errorf("expected seeding again to skip existing users; got %d instead of %d", again, users)
+	}
+
+	// The fake users have every kind
+	var oauth, unverified, avatars int64
+	db.Model(&model.User{}).Where("o_auth_provider IS NOT NULL AND password IS NULL").Count(&oauth)
+	db.Model(&model.User{}).Where("verified_at IS NULL AND verify_mail_token IS NOT NULL").Count(&unverified)
+	db.Model(&model.User{}).Where("avatar_url IS NOT NULL").Count(&avatars)
+	if oauth < 2 || unverified < 2 || avatars < 2 {
+		t.Errorf("expected OAuth-only, unverified users and avatars; got %d, %d, %d", oauth, unverified, avatars)
+	}
+	// Unverified users never logged in, they are inactive once they joined before the window
+	var inactive int64
+	broadcast.Recipients(db, model.Broadcast{Segment: model.BroadcastSegmentInactive}).Where("last_login_at IS NULL").Count(&inactive)
+	if inactive < 2 {
+		t.Errorf("expected users who never logged in to be inactive by their signup; got %d", inactive)
+	}
+}
+
+func TestLoadFixture(t *testing.T) {
+	db := newTestDB(t)
+	c := &config.Config{App: config.App{Env: "test"}, Legal: config.Legal{EnableLegal: true, TermsVersion: "1", PrivacyVersion: "1"}}
+	ctx := context.Background()
+
+	if _, err := seed.LoadFixture(ctx, db, c, "examples"); err != nil {
+		t.Fatalf("error loading fixture. Err: %v", err)
+	}
+	var admin, bob model.User
+	db.First(&admin, "email = ?", "admin@example.org")
+	db.First(&bob, "email = ?", "bob@example.org")
+	if !admin.IsAdmin() || bob.VerifiedAt != nil || bob.VerifyMailToken == nil {
+		t.Errorf("expected the admin and unverified bob; got %+v, %+v", admin, bob)
+	}
+	var acceptances int64
+	db.Model(&model.LegalAcceptance{}).Where("user_id = ?", bob.ID).Count(&acceptances)
+	if acceptances != 2 {
+		t.Errorf("expected bob to have accepted the legal documents; got %d", acceptances)
+	}
+
+	if _, err := seed.LoadFixture(ctx, db, c, "missing"); !errors.Is(err, seed.ErrUnknownSeeder) {
+		t.Errorf("expected ErrUnknownSeeder; got %v", err)
+	}
+}
+
+func TestSeedersRespectEnvironment(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	production := &config.Config{App: config.App{Env: "production"}}
+
+	if ran, err := seed.Run(ctx, db, production); err != nil || len(ran) != 0 {
+		t.Errorf("expected no seeders in production; got %v, %v", ran, err)
+	}
+	if _, err := seed.LoadFixture(ctx, db, production, "admin"); !errors.Is(err, seed.ErrEnvironment) {
+		t.Errorf("expected ErrEnvironment; got %v", err)
+	}
+	// The seeded users share a known password
+	if ran, err := seed.Run(ctx, db, &config.Config{App: config.App{Env: "staging"}}); err != nil || len(ran) != 0 {
+		t.Errorf("expected no seeders on staging; got %v, %v", ran, err)
+	}
+}
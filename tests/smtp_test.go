@@ -0,0 +1,534 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/mail"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+const (
+	smtpUsername = "mailer"
+	smtpPassword = "mailer-password"
+)
+
+type receivedMail struct {
+	from string
+	to   []string
+	data []byte
+}
+
+// smtpBackend records the connections and mails of the in-process SMTP server
+type smtpBackend struct {
+	mu sync.Mutex
+	// STARTTLS starts a new session on the same connection, so we count the connections
+	conns map[*smtp.Conn]bool
+	mails []receivedMail
+}
+
+func (b *smtpBackend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	b.mu.Lock()
+	b.conns[c] = true
+	b.mu.Unlock()
+	return &smtpSession{backend: b}, nil
+}
+
+func (b *smtpBackend) received() ([]receivedMail, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]receivedMail{}, b.mails...), len(b.conns)
+}
+
+type smtpSession struct {
+	backend *smtpBackend
+	authed  bool
+	mail    receivedMail
+}
+
+func (s *smtpSession) AuthMechanisms() []string {
+	return []string{sasl.Plain, sasl.Login, "CRAM-MD5"}
+}
+
+func (s *smtpSession) Auth(mech string) (sasl.Server, error) {
+	check := func(username, password string) error {
+		if username != smtpUsername || password != smtpPassword {
+			return errors.New("invalid credentials")
+		}
+		s.authed = true
+		return nil
+	}
+	switch mech {
+	case sasl.Plain:
+		return sasl.NewPlainServer(func(identity, username, password string) error {
+			return check(username, password)
+		}), nil
+	case sasl.Login:
+		return &loginServer{check: check}, nil
+	case "CRAM-MD5":
+		return &cramMD5Server{check: func(username, digest, challenge string) error {
+			mac := hmac.New(md5.New, []byte(smtpPassword))
+			mac.Write([]byte(challenge))
+			if !hmac.Equal([]byte(digest), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+				return errors.New("invalid credentials")
+			}
+			return check(username, smtpPassword)
+		}}, nil
+	}
+	return nil, smtp.ErrAuthUnknownMechanism
+}
+
+func (s *smtpSession) Mail(from string, opts *smtp.MailOptions) error {
+	if !s.authed {
+		return smtp.ErrAuthRequired
+	}
+	s.mail = receivedMail{from: from}
+	return nil
+}
+
+func (s *smtpSession) Rcpt(to string, opts *smtp.RcptOptions) error {
+	// Like a real mail server, unknown users are rejected and greylisted senders asked to come back later
+	switch {
+	case strings.HasPrefix(to, "nobody@"):
+		return &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 1, 1}, Message: "No such user"}
+	case strings.HasPrefix(to, "greylisted@"):
+		return &smtp.SMTPError{Code: 451, EnhancedCode: smtp.EnhancedCode{4, 7, 1}, Message: "Try again later"}
+	}
+	s.mail.to = append(s.mail.to, to)
+	return nil
+}
+
+func (s *smtpSession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mail.data = data
+	s.backend.mu.Lock()
+	s.backend.mails = append(s.backend.mails, s.mail)
+	s.backend.mu.Unlock()
+	return nil
+}
+
+func (s *smtpSession) Reset()        { s.mail = receivedMail{} }
+func (s *smtpSession) Logout() error { return nil }
+
+// loginServer implements the server side of the LOGIN mechanism
+type loginServer struct {
+	check    func(username, password string) error
+	username *string
+}
+
+func (l *loginServer) Next(response []byte) ([]byte, bool, error) {
+	if response == nil && l.username == nil {
+		return []byte("Username:"), false, nil
+	}
+	if l.username == nil {
+		username := string(response)
+		l.username = &username
+		return []byte("Password:"), false, nil
+	}
+	return nil, true, l.check(*l.username, string(response))
+}
+
+// cramMD5Server implements the server side of the CRAM-MD5 mechanism
+type cramMD5Server struct {
+	check     func(username, digest, challenge string) error
+	challenge string
+}
+
+func (c *cramMD5Server) Next(response []byte) ([]byte, bool, error) {
+	if c.challenge == "" {
+		c.challenge = fmt.Sprintf("<%d.%d@test>", time.Now().UnixNano(), 1)
+		return []byte(c.challenge), false, nil
+	}
+	username, digest, ok := strings.Cut(string(response), " ")
+	if !ok {
+		return nil, true, errors.New("invalid response")
+	}
+	return nil, true, c.check(username, digest, c.challenge)
+}
+
+// newTLSCertificate returns a certificate for 127.0.0.1 and a pool trusting it
+func newTLSCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key. Err: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate. Err: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing certificate. Err: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// newSMTPServer starts an in-process SMTP server and returns the options to send mails to it
+func newSMTPServer(t *testing.T, tlsMode config.SMTPTLSMode, auth config.SMTPAuth) (*smtpBackend, mail.SMTPOptions) {
+	certificate, pool := newTLSCertificate(t)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{certificate}}
+
+	backend := &smtpBackend{conns: map[*smtp.Conn]bool{}}
+	server := smtp.NewServer(backend)
+	server.Domain = "127.0.0.1"
+	server.AllowInsecureAuth = tlsMode == config.SMTPTLSModeNone
+	server.ErrorLog = nopLogger{}
+	if tlsMode == config.SMTPTLSModeStartTLS {
+		server.TLSConfig = tlsConfig
+	}
+
+	var listener net.Listener
+	var err error
+	if tlsMode == config.SMTPTLSModeImplicit {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatalf("error listening. Err: %v", err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return backend, mail.SMTPOptions{
+		Host:        "127.0.0.1",
+		Port:        listener.Addr().(*net.TCPAddr).Port,
+		Username:    smtpUsername,
+		Password:    smtpPassword,
+		From:        netmail.Address{Name: "Test App", Address: "noreply@example.org"},
+		TLSMode:     tlsMode,
+		Auth:        auth,
+		Timeout:     5 * time.Second,
+		IdleTimeout: time.Minute,
+		TLSConfig:   &tls.Config{RootCAs: pool},
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...interface{}) {}
+func (nopLogger) Println(v ...interface{})               {}
+
+func newSMTPService(t *testing.T, options mail.SMTPOptions) *mail.SMTPService {
+	service, err := mail.NewSMTPService(options)
+	if err != nil {
+		t.Fatalf("error creating smtp service. Err: %v", err)
+	}
+	t.Cleanup(func() { service.Close() })
+	return service
+}
+
+func TestSMTPSendsWithTLSModesAndAuth(t *testing.T) {
+	cases := []struct {
+		tlsMode config.SMTPTLSMode
+		auth    config.SMTPAuth
+	}{
+		{config.SMTPTLSModeStartTLS, config.SMTPAuthPlain},
+		{config.SMTPTLSModeImplicit, config.SMTPAuthLogin},
+		{config.SMTPTLSModeNone, config.SMTPAuthCRAMMD5},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s-%s", c.tlsMode, c.auth), func(t *testing.T) {
+			backend, options := newSMTPServer(t, c.tlsMode, c.auth)
+			service := newSMTPService(t, options)
+
+			if err := service.Send("alice@example.org", "Grüße from the app", "<p>Hello Alice</p>"); err != nil {
+				t.Fatalf("error sending mail. Err: %v", err)
+			}
+
+			mails, _ := backend.received()
+			if len(mails) != 1 {
+				t.Fatalf("expected 1 mail; got %d", len(mails))
+			}
+			if mails[0].from != "noreply@example.org" || len(mails[0].to) != 1 || mails[0].to[0] != "alice@example.org" {
+				t.Errorf("unexpected envelope: %+v", mails[0])
+			}
+
+			message, err := netmail.ReadMessage(bytes.NewReader(mails[0].data))
+			if err != nil {
+				t.Fatalf("error parsing mail. Err: %v", err)
+			}
+			subject, _ := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+			if subject != "Grüße from the app" {
+				t.Errorf("expected decoded subject; got %q", subject)
+			}
+			body, _ := io.ReadAll(quotedprintable.NewReader(message.Body))
+			if strings.TrimSpace(string(body)) != "<p>Hello Alice</p>" {
+				t.Errorf("unexpected body: %q", body)
+			}
+		})
+	}
+}
+
+func TestSMTPReusesConnection(t *testing.T) {
+	backend, options := newSMTPServer(t, config.SMTPTLSModeStartTLS, config.SMTPAuthPlain)
+	service := newSMTPService(t, options)
+
+	for i := 0; i < 3; i++ {
+		if err := service.Send("alice@example.org", "Hello", "Hello"); err != nil {
+			t.Fatalf("error sending mail. Err: %v", err)
+		}
+	}
+	mails, conns := backend.received()
+	if len(mails) != 3 || conns != 1 {
+		t.Errorf("expected 3 mails over 1 connection; got %d mails over %d", len(mails), conns)
+	}
+
+	// A closed connection is replaced transparently
+	service.Close()
+	if err := service.Send("alice@example.org", "Hello", "Hello"); err != nil {
+		t.Fatalf("error sending mail after close. Err: %v", err)
+	}
+	if _, conns := backend.received(); conns != 2 {
+		t.Errorf("expected a new connection; got %d", conns)
+	}
+}
+
+func TestSMTPRejectsWrongPassword(t *testing.T) {
+	_, options := newSMTPServer(t, config.SMTPTLSModeStartTLS, config.SMTPAuthPlain)
+	options.Password = "wrong"
+	service := newSMTPService(t, options)
+
+	if err := service.Send("alice@example.org", "Hello", "Hello"); err == nil {
+		t.Fatal("expected an auth error")
+	}
+}
+
+func TestSMTPRequiresStartTLS(t *testing.T) {
+	// The server does not offer STARTTLS, so the client must not send the password in plain text
+	_, options := newSMTPServer(t, config.SMTPTLSModeNone, config.SMTPAuthPlain)
+	options.TLSMode = config.SMTPTLSModeStartTLS
+	service := newSMTPService(t, options)
+
+	err := service.Send("alice@example.org", "Hello", "Hello")
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("expected a STARTTLS error; got %v", err)
+	}
+}
+
+func TestSMTPTimeout(t *testing.T) {
+	// A server that accepts connections but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening. Err: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	service := newSMTPService(t, mail.SMTPOptions{
+		Host:    "127.0.0.1",
+		Port:    listener.Addr().(*net.TCPAddr).Port,
+		From:    netmail.Address{Address: "noreply@example.org"},
+		TLSMode: config.SMTPTLSModeNone,
+		Auth:    config.SMTPAuthNone,
+		Timeout: 200 * time.Millisecond,
+	})
+	start := time.Now()
+	if err := service.Send("alice@example.org", "Hello", "Hello"); err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("expected the timeout to apply; took %v", time.Since(start))
+	}
+}
+
+func TestSMTPSendsMultipartAlternative(t *testing.T) {
+	backend, options := newSMTPServer(t, config.SMTPTLSModeStartTLS, config.SMTPAuthPlain)
+	service := newSMTPService(t, options)
+
+	err := service.SendMessage(mail.Message{To: []string{"alice@example.org"}, Subject: "Hello", HTML: "<p>Hello Alice</p>", Text: "Hello Alice"})
+	if err != nil {
+		t.Fatalf("error sending mail. Err: %v", err)
+	}
+	mails, _ := backend.received()
+	message, err := netmail.ReadMessage(bytes.NewReader(mails[0].data))
+	if err != nil {
+		t.Fatalf("error parsing mail. Err: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("expected multipart/alternative; got %s", message.Header.Get("Content-Type"))
+	}
+
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for _, expected := range [][2]string{{"text/plain", "Hello Alice"}, {"text/html", "<p>Hello Alice</p>"}} {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("error reading part. Err: %v", err)
+		}
+		// The multipart reader decodes quoted-printable itself
+		body, _ := io.ReadAll(part)
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), expected[0]) || strings.TrimSpace(string(body)) != expected[1] {
+			t.Errorf("unexpected part %s: %q", part.Header.Get("Content-Type"), body)
+		}
+	}
+}
+
+func TestSMTPSendsFullMessage(t *testing.T) {
+	backend, options := newSMTPServer(t, config.SMTPTLSModeStartTLS, config.SMTPAuthPlain)
+	service := newSMTPService(t, options)
+
+	logo := []byte("\x89PNG fake image")
+	invoice := bytes.Repeat([]byte("invoice "), 40)
+	err := service.SendMessage(mail.Message{
+		To:      []string{"Alice <alice@example.org>", "bob@example.org"},
+		Cc:      []string{"carol@example.org"},
+		Bcc:     []string{"dave@example.org"},
+		ReplyTo: "Support <support@example.org>",
+		Subject: "Your invoice",
+		HTML:    `<p>Hello</p><img src="cid:logo">`,
+		Text:    "Hello",
+		Headers: map[string]string{"List-Unsubscribe": "<https://example.org/unsubscribe>"},
+		Tags:    map[string]string{"category": "invoice"},
+		Attachments: []mail.Attachment{
+			{Filename: "logo.png", Content: logo, ContentID: "logo"},
+			{Filename: "invoice.pdf", Content: invoice},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error sending mail. Err: %v", err)
+	}
+
+	mails, _ := backend.received()
+	if strings.Join(mails[0].to, ",") != "alice@example.org,bob@example.org,carol@example.org,dave@example.org" {
+		t.Errorf("unexpected envelope recipients %v", mails[0].to)
+	}
+	message, err := netmail.ReadMessage(bytes.NewReader(mails[0].data))
+	if err != nil {
+		t.Fatalf("error parsing mail. Err: %v", err)
+	}
+	expectedHeaders := map[string]string{
+		"To":               `"Alice" <alice@example.org>, <bob@example.org>`,
+		"Cc":               "<carol@example.org>",
+		"Reply-To":         `"Support" <support@example.org>`,
+		"List-Unsubscribe": "<https://example.org/unsubscribe>",
+		"X-Tags":           "category=invoice",
+		"Bcc":              "",
+	}
+	for name, expected := range expectedHeaders {
+		if message.Header.Get(name) != expected {
+			t.Errorf("expected %s header %q; got %q", name, expected, message.Header.Get(name))
+		}
+	}
+
+	// mixed > [related > [alternative, logo], invoice]
+	mixed := readParts(t, message.Header.Get("Content-Type"), message.Body, "multipart/mixed")
+	if len(mixed) != 2 {
+		t.Fatalf("expected 2 parts in multipart/mixed; got %d", len(mixed))
+	}
+	related := readParts(t, mixed[0].header.Get("Content-Type"), bytes.NewReader(mixed[0].body), "multipart/related")
+	if len(related) != 2 {
+		t.Fatalf("expected 2 parts in multipart/related; got %d", len(related))
+	}
+	alternative := readParts(t, related[0].header.Get("Content-Type"), bytes.NewReader(related[0].body), "multipart/alternative")
+	if len(alternative) != 2 || string(alternative[1].body) != `<p>Hello</p><img src="cid:logo">` {
+		t.Errorf("unexpected alternative parts %+v", alternative)
+	}
+	if related[1].header.Get("Content-ID") != "<logo>" || !bytes.Equal(related[1].body, logo) || related[1].header.Get("Content-Type") != "image/png" {
+		t.Errorf("unexpected inline image %v", related[1].header)
+	}
+	if !strings.HasPrefix(mixed[1].header.Get("Content-Disposition"), "attachment") || !bytes.Equal(mixed[1].body, invoice) {
+		t.Errorf("unexpected attachment %v", mixed[1].header)
+	}
+}
+
+func TestMessageValidation(t *testing.T) {
+	valid := mail.Message{To: []string{"alice@example.org"}, Subject: "Hello", HTML: "Hello"}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected a valid message. Err: %v", err)
+	}
+
+	invalid := map[string]func(m *mail.Message){
+		"no recipients":    func(m *mail.Message) { m.To = nil },
+		"invalid address":  func(m *mail.Message) { m.Cc = []string{"not an address"} },
+		"header injection": func(m *mail.Message) { m.Headers = map[string]string{"X-Test": "a\r\nBcc: eve@example.org"} },
+		"reserved header":  func(m *mail.Message) { m.Headers = map[string]string{"from": "eve@example.org"} },
+		"invalid tag":      func(m *mail.Message) { m.Tags = map[string]string{"category": "with space"} },
+		"no body":          func(m *mail.Message) { m.HTML = "" },
+	}
+	for name, modify := range invalid {
+		message := valid
+		modify(&message)
+		if err := message.Validate(); !errors.Is(err, mail.ErrInvalidMessage) {
+			t.Errorf("%s: expected ErrInvalidMessage; got %v", name, err)
+		}
+	}
+}
+
+type mimePart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// readParts reads the decoded parts of a multipart body
+func readParts(t *testing.T, contentType string, body io.Reader, expectedType string) []mimePart {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != expectedType {
+		t.Fatalf("expected %s; got %s", expectedType, contentType)
+	}
+	parts := []mimePart{}
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("error reading part. Err: %v", err)
+		}
+		content, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+			content, err = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(content), "\r\n", ""))
+			if err != nil {
+				t.Fatalf("error decoding part. Err: %v", err)
+			}
+		}
+		parts = append(parts, mimePart{header: part.Header, body: content})
+	}
+}
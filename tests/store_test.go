@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"atomic-go-template/internal/auth"
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/legal"
+	mw "atomic-go-template/internal/middleware"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/store"
+	"atomic-go-template/internal/utils"
+	"atomic-go-template/web/routes/auth/forget_password"
+	"atomic-go-template/web/routes/auth/login"
+	"atomic-go-template/web/routes/auth/reset_password"
+	"atomic-go-template/web/routes/auth/signup"
+	verify_mail "atomic-go-template/web/routes/auth/verify-mail"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/form/v4"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+)
+
+// Both stores have to behave the same, handler tests rely on it
+func TestUserStores(t *testing.T) {
+	t.Run("gorm", func(t *testing.T) {
+		testUserStore(t, store.NewGormUserStore(newTestDB(t)))
+	})
+	t.Run("memory", func(t *testing.T) {
+		testUserStore(t, store.NewMemoryUserStore())
+	})
+}
+
+func testUserStore(t *testing.T, users store.UserStore) {
+	ctx := context.Background()
+	token := "verify-token"
+	alice := model.User{Username: "alice", Email: "alice@example.org", VerifyMailToken: &token}
+	if err := users.Create(ctx, &alice); err != nil || alice.ID == uuid.Nil {
+		t.Fatalf("expected alice to be created with an ID; got %v, %v", alice.ID, err)
+	}
+	if err := users.Create(ctx, &model.User{Username: "alice", Email: "other@example.org"}); !errors.Is(err, store.ErrDuplicate) {
+		t.Errorf("expected a taken username to be a duplicate; got %v", err)
+	}
+	bob := model.User{Username: "bob", Email: "bob@example.org"}
+	if err := users.Create(ctx, &bob); err != nil {
+		t.Fatalf("error creating bob. Err: %v", err)
+	}
+
+	found, err := users.ByEmail(ctx, "alice@example.org")
+	if err != nil || found.ID != alice.ID || found.Role != model.RoleUser {
+		t.Errorf("expected alice with the default role; got %+v, %v", found, err)
+	}
+	if found, err := users.ByVerifyMailToken(ctx, token); err != nil || found.ID != alice.ID {
+		t.Errorf("expected alice by token; got %+v, %v", found, err)
+	}
+	if _, err := users.ByPasswordResetToken(ctx, ""); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected an empty token not to match; got %v", err)
+	}
+
+	// Update saves cleared fields as well
+	resetToken := "reset-token"
+	found.VerifyMailToken = nil
+	found.PasswordResetToken = &resetToken
+	if err := users.Update(ctx, &found); err != nil {
+		t.Fatalf("error updating alice. Err: %v", err)
+	}
+	if _, err := users.ByVerifyMailToken(ctx, token); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected the verify token to be cleared; got %v", err)
+	}
+	if found, err := users.ByPasswordResetToken(ctx, resetToken); err != nil || found.ID != alice.ID {
+		t.Errorf("expected alice by reset token; got %+v, %v", found, err)
+	}
+
+	bob.Email = "alice@example.org"
+	if err := users.Update(ctx, &bob); !errors.Is(err, store.ErrDuplicate) {
+		t.Errorf("expected a taken email to be a duplicate; got %v", err)
+	}
+	if _, err := users.ByID(ctx, uuid.New()); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown ID; got %v", err)
+	}
+	if err := users.Update(ctx, &model.User{BaseModel: model.BaseModel{ID: uuid.New()}, Username: "carol", Email: "carol@example.org"}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected updating an unknown user to fail; got %v", err)
+	}
+
+	if err := users.RecordLogin(ctx, alice.ID); err != nil {
+		t.Fatalf("error recording login. Err: %v", err)
+	}
+	if found, _ := users.ByID(ctx, alice.ID); found.LastLoginAt == nil {
+		t.Errorf("expected the last login to be set")
+	}
+	if err := users.AcceptLegal(ctx, []model.LegalAcceptance{{UserID: alice.ID, Document: model.LegalDocumentTerms, Version: "v1", AcceptedAt: time.Now()}}); err != nil {
+		t.Fatalf("error accepting legal documents. Err: %v", err)
+	}
+	if acceptances, err := users.LegalAcceptances(ctx, alice.ID); err != nil || len(acceptances) != 1 || acceptances[0].Version != "v1" {
+		t.Errorf("expected the acceptance of alice; got %+v, %v", acceptances, err)
+	}
+	if acceptances, err := users.LegalAcceptances(ctx, bob.ID); err != nil || len(acceptances) != 0 {
+		t.Errorf("expected no acceptances of bob; got %+v, %v", acceptances, err)
+	}
+}
+
+func postForm(handler http.HandlerFunc, c *config.Config, values url.Values) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(values.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request = request.WithContext(context.WithValue(request.Context(), mw.ConfigKey, c))
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder
+}
+
+func TestPasswordResetWithMemoryStore(t *testing.T) {
+	c := &config.Config{App: config.App{Name: "Test App", Url: "https://app.example.org"}}
+	users := store.NewMemoryUserStore(model.User{Username: "alice", Email: "alice@example.org"})
+	recorder := &recordingMail{}
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	postForm(forget_password.New(users, c, validate, form.NewDecoder(), recorder).POST, c, url.Values{"email": {"alice@example.org"}})
+	alice, _ := users.ByEmail(context.Background(), "alice@example.org")
+	if alice.PasswordResetToken == nil || len(recorder.messages) != 1 || !strings.Contains(recorder.messages[0].HTML, *alice.PasswordResetToken) {
+		t.Fatalf("expected a reset token to be saved and mailed; got %+v, %d mails", alice, len(recorder.messages))
+	}
+
+	response := postForm(reset_password.New(users, c, validate, form.NewDecoder(), recorder).POST, c, url.Values{
+		"token":            {*alice.PasswordResetToken},
+		"password":         {"new-password"},
+		"confirm_password": {"new-password"},
+	})
+	alice, _ = users.ByID(context.Background(), alice.ID)
+	if alice.PasswordResetToken != nil || alice.Password == nil || utils.CheckPasswordHash(*alice.Password, "new-password") != nil {
+		t.Errorf("expected the new password and the token to be cleared; got %+v, %s", alice, response.Body.String())
+	}
+}
+
+func TestVerifyMailWithMemoryStore(t *testing.T) {
+	token := "verify-token"
+	address := "new@example.org"
+	users := store.NewMemoryUserStore(model.User{Username: "alice", Email: "alice@example.org", VerifyMailAddress: &address, VerifyMailToken: &token})
+
+	request := httptest.NewRequest(http.MethodGet, "/auth/verify-email?token="+token, nil)
+	request = request.WithContext(context.WithValue(request.Context(), mw.ConfigKey, &config.Config{}))
+	verify_mail.New(users).GET(httptest.NewRecorder(), request)
+
+	alice, err := users.ByEmail(context.Background(), address)
+	if err != nil || alice.VerifiedAt == nil || alice.VerifyMailToken != nil {
+		t.Errorf("expected the new address to be verified; got %+v, %v", alice, err)
+	}
+}
+
+func TestSignupAndLoginWithMemoryStore(t *testing.T) {
+	c := &config.Config{Legal: config.Legal{EnableLegal: true, TermsVersion: "v1", PrivacyVersion: "v1"}}
+	users := store.NewMemoryUserStore()
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	response := postForm(signup.New(users, c, validate, form.NewDecoder(), &recordingMail{}).POST, c, url.Values{
+		"username":         {"alice"},
+		"email":            {"alice@example.org"},
+		"password":         {"alice-password"},
+		"confirm_password": {"alice-password"},
+		"accept_terms":     {"true"},
+	})
+	alice, err := users.ByEmail(context.Background(), "alice@example.org")
+	if err != nil {
+		t.Fatalf("expected alice to be created; got %v, %s", err, response.Body.String())
+	}
+	if pending, err := legal.PendingDocuments(context.Background(), users, alice.ID, c.Legal); err != nil || len(pending) != 0 {
+		t.Errorf("expected the legal documents to be accepted on signup; got %v, %v", pending, err)
+	}
+
+	handler := login.New(users, c, validate, form.NewDecoder(), auth.NewLocalAuthenticator(users), nil)
+	response = postForm(handler.POST, c, url.Values{"email": {"alice@example.org"}, "password": {"alice-password"}})
+	alice, _ = users.ByID(context.Background(), alice.ID)
+	if alice.LastLoginAt == nil || len(response.Result().Cookies()) == 0 {
+		t.Errorf("expected alice to be logged in; got %s", response.Body.String())
+	}
+}
@@ -0,0 +1,228 @@
+package tests
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/mail"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/web/layout"
+	mail_webhooks "atomic-go-template/web/routes/webhooks/mail"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The signing secret as shown in the Resend dashboard
+var resendWebhookSecret = "whsec_" + base64.StdEncoding.EncodeToString([]byte("resend-test-secret"))
+
+// signResend signs the body like Svix does for Resend
+func signResend(t *testing.T, body string, sent time.Time) http.Header {
+	key, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(resendWebhookSecret, "whsec_"))
+	id, timestamp := "msg_test", strconv.FormatInt(sent.Unix(), 10)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "." + body))
+	header := http.Header{}
+	header.Set("svix-id", id)
+	header.Set("svix-timestamp", timestamp)
+	header.Set("svix-signature", "v1,b2xkLXNpZ25hdHVyZQ== v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return header
+}
+
+// signGeneric signs the body with the timestamp like the relays of the generic webhook
+func signGeneric(body string, sent time.Time) http.Header {
+	timestamp := strconv.FormatInt(sent.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte("generic-secret"))
+	mac.Write([]byte(timestamp + "." + body))
+	header := http.Header{}
+	header.Set("X-Webhook-Timestamp", timestamp)
+	header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return header
+}
+
+func postWebhook(handler http.HandlerFunc, body string, header http.Header) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder
+}
+
+func createTestUser(t *testing.T, db *gorm.DB, username, email string) model.User {
+	user := model.User{Username: username, Email: email}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("error creating user. Err: %v", err)
+	}
+	return user
+}
+
+func TestVerifyResendWebhook(t *testing.T) {
+	body := `{"type":"email.bounced"}`
+	now := time.Now()
+
+	if err := mail.VerifyResendWebhook(resendWebhookSecret, signResend(t, body, now), []byte(body), now); err != nil {
+		t.Errorf("expected a valid signature; got %v", err)
+	}
+	if err := mail.VerifyResendWebhook(resendWebhookSecret, signResend(t, body, now), []byte(`{"type":"email.sent"}`), now); !errors.Is(err, mail.ErrInvalidSignature) {
+		t.Errorf("expected a changed body to fail; got %v", err)
+	}
+	old := now.Add(-10 * time.Minute)
+	if err := mail.VerifyResendWebhook(resendWebhookSecret, signResend(t, body, old), []byte(body), now); !errors.Is(err, mail.ErrInvalidSignature) {
+		t.Errorf("expected a replayed webhook to fail; got %v", err)
+	}
+	if err := mail.VerifyResendWebhook(resendWebhookSecret, http.Header{}, []byte(body), now); !errors.Is(err, mail.ErrInvalidSignature) {
+		t.Errorf("expected missing headers to fail; got %v", err)
+	}
+}
+
+func TestResendWebhookSuppressesBounces(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice", "Alice@example.org")
+	bob := createTestUser(t, db, "bob", "bob@example.org")
+	handler := mail_webhooks.New(db, resendWebhookSecret).Resend
+
+	hardBounce := `{"type":"email.bounced","data":{"to":["alice@example.org"],"bounce":{"type":"Permanent","subType":"General","message":"Mailbox does not exist"}}}`
+	if recorder := postWebhook(handler, hardBounce, signResend(t, hardBounce, time.Now())); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the webhook to be accepted; got %d %s", recorder.Code, recorder.Body.String())
+	}
+	softBounce := `{"type":"email.bounced","data":{"to":["bob@example.org"],"bounce":{"type":"Transient","subType":"MailboxFull"}}}`
+	postWebhook(handler, softBounce, signResend(t, softBounce, time.Now()))
+	delivered := `{"type":"email.delivered","data":{"to":["bob@example.org"]}}`
+	postWebhook(handler, delivered, signResend(t, delivered, time.Now()))
+
+	if suppressed, _ := mail.IsSuppressed(db, "alice@example.org"); !suppressed {
+		t.Errorf("expected alice to be suppressed after a hard bounce")
+	}
+	if suppressed, _ := mail.IsSuppressed(db, "bob@example.org"); suppressed {
+		t.Errorf("expected soft bounces and deliveries not to suppress bob")
+	}
+	db.First(&alice, "id = ?", alice.ID)
+	db.First(&bob, "id = ?", bob.ID)
+	if alice.EmailBouncedAt == nil || bob.EmailBouncedAt != nil {
+		t.Errorf("expected only alice to be flagged; got alice %v, bob %v", alice.EmailBouncedAt, bob.EmailBouncedAt)
+	}
+
+	if recorder := postWebhook(handler, hardBounce, http.Header{}); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected unsigned webhooks to be rejected; got %d", recorder.Code)
+	}
+}
+
+func TestGenericWebhookSuppressesComplaints(t *testing.T) {
+	db := newTestDB(t)
+	carol := createTestUser(t, db, "carol", "carol@example.org")
+	handler := mail_webhooks.New(db, "generic-secret").Generic
+
+	body := `{"events":[{"type":"complaint","email":"carol@example.org","reason":"abuse report"}]}`
+	header := signGeneric(body, time.Now())
+
+	recorder := postWebhook(handler, body, header)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"processed":1`) {
+		t.Fatalf("expected the webhook to be accepted; got %d %s", recorder.Code, recorder.Body.String())
+	}
+	var suppression model.MailSuppression
+	if err := db.First(&suppression, "email = ?", "carol@example.org").Error; err != nil || suppression.Reason != model.SuppressionReasonComplaint || suppression.Source != "webhook" {
+		t.Errorf("expected carol to be suppressed for the complaint; got %+v, %v", suppression, err)
+	}
+	db.First(&carol, "id = ?", carol.ID)
+	if carol.EmailBouncedAt != nil {
+		t.Errorf("expected complaints not to flag the address as bouncing")
+	}
+
+	header.Set("X-Webhook-Signature", "sha256=00")
+	if recorder := postWebhook(handler, body, header); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected a wrong signature to be rejected; got %d", recorder.Code)
+	}
+	// A captured webhook can't be sent again later
+	if recorder := postWebhook(handler, body, signGeneric(body, time.Now().Add(-10*time.Minute))); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected an old webhook to be rejected; got %d", recorder.Code)
+	}
+	// The timestamp is part of the signature
+	header = signGeneric(body, time.Now())
+	header.Set("X-Webhook-Timestamp", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+	if recorder := postWebhook(handler, body, header); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected a changed timestamp to be rejected; got %d", recorder.Code)
+	}
+	unknown := `{"events":[{"type":"opened","email":"carol@example.org"}]}`
+	if recorder := postWebhook(handler, unknown, signGeneric(unknown, time.Now())); recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown event types to be rejected; got %d", recorder.Code)
+	}
+}
+
+func TestConfigReadsWebhookSecrets(t *testing.T) {
+	t.Setenv("RESEND_WEBHOOK_SECRET", resendWebhookSecret)
+	t.Setenv("MAIL_WEBHOOK_SECRET", "generic-secret")
+	c := config.New(nil)
+	if c.Mail.Suppression.ResendWebhookSecret != resendWebhookSecret || c.Mail.Suppression.WebhookSecret != "generic-secret" {
+		t.Errorf("expected the secrets of the environment; got %+v", c.Mail.Suppression)
+	}
+}
+
+func TestSuppressionFilter(t *testing.T) {
+	db := newTestDB(t)
+	if err := mail.Suppress(db, "Bounced@example.org", model.SuppressionReasonBounce, "", "test"); err != nil {
+		t.Fatalf("error suppressing address. Err: %v", err)
+	}
+	// Suppressing again updates the entry
+	if err := mail.Suppress(db, "bounced@example.org", model.SuppressionReasonComplaint, "spam", "test"); err != nil {
+		t.Fatalf("error suppressing address again. Err: %v", err)
+	}
+	provider := &recordingMail{}
+	filter := mail.NewSuppressionFilter(db, provider)
+
+	err := filter.SendMessage(mail.Message{
+		To:      []string{"alice@example.org"},
+		Cc:      []string{"Bounced <bounced@example.org>"},
+		Subject: "Hello",
+		HTML:    "<p>Hello</p>",
+	})
+	if err != nil {
+		t.Fatalf("error sending message. Err: %v", err)
+	}
+	if len(provider.messages) != 1 || len(provider.messages[0].Cc) != 0 || provider.messages[0].To[0] != "alice@example.org" {
+		t.Errorf("expected the suppressed cc to be removed; got %+v", provider.messages)
+	}
+
+	err = filter.Send("bounced@example.org", "Hello", "<p>Hello</p>")
+	if !errors.Is(err, mail.ErrSuppressed) || !mail.IsPermanentError(err) {
+		t.Errorf("expected a permanent ErrSuppressed; got %v", err)
+	}
+
+	// The outbox dead-letters the message instead of retrying it
+	outbox, outboxDB := newTestOutbox(t, mail.NewSuppressionFilter(db, provider), mail.OutboxOptions{})
+	if err := outbox.Send("bounced@example.org", "Hello", "<p>Hello</p>"); err != nil {
+		t.Fatalf("error queueing message. Err: %v", err)
+	}
+	processDue(t, outbox)
+	if dead := outboxMessages(t, outboxDB)[0]; dead.Status != model.OutboxStatusDead {
+		t.Errorf("expected the message to be dead-lettered; got %+v", dead)
+	}
+}
+
+func TestEmailBouncedPrompt(t *testing.T) {
+	now := time.Now()
+	var buf bytes.Buffer
+	if err := layout.EmailBounced(model.User{Email: "alice@example.org", EmailBouncedAt: &now}).Render(context.Background(), &buf); err != nil {
+		t.Fatalf("error rendering prompt. Err: %v", err)
+	}
+	if !strings.Contains(buf.String(), "alice@example.org") || !strings.Contains(buf.String(), `href="/user/profile"`) {
+		t.Errorf("expected a prompt to update the email; got %s", buf.String())
+	}
+
+	buf.Reset()
+	layout.EmailBounced(model.User{Email: "bob@example.org"}).Render(context.Background(), &buf)
+	if buf.Len() != 0 {
+		t.Errorf("expected no prompt without bounces; got %s", buf.String())
+	}
+}
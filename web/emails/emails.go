@@ -0,0 +1,126 @@
+// Package emails renders the mails of the app from templ components.
+// Every mail gets the branded Layout and an automatically generated plain text version
+package emails
+
+import (
+	"atomic-go-template/internal/config"
+	"atomic-go-template/internal/mail"
+	"atomic-go-template/internal/model"
+	"atomic-go-template/internal/notification"
+	"bytes"
+	"context"
+
+	"github.com/a-h/templ"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Mailer struct {
+	config *config.Config
+	mail   mail.Service
+}
+
+func New(config *config.Config, mail mail.Service) *Mailer {
+	return &Mailer{config: config, mail: mail}
+}
+
+// Render returns the message with the HTML of the component and the text generated from it
+func (m *Mailer) Render(to, subject string, component templ.Component) (mail.Message, error) {
+	return m.render(context.Background(), to, subject, component)
+}
+
+func (m *Mailer) render(ctx context.Context, to, subject string, component templ.Component) (mail.Message, error) {
+	var html bytes.Buffer
+	if err := component.Render(ctx, &html); err != nil {
+		return mail.Message{}, err
+	}
+	text, err := mail.HTMLToText(html.String())
+	if err != nil {
+		return mail.Message{}, err
+	}
+	return mail.Message{
+		To:      []string{to},
+		Subject: m.config.App.Name + " - " + subject,
+		HTML:    html.String(),
+		Text:    text,
+	}, nil
+}
+
+// Send renders the component and sends it
+func (m *Mailer) Send(to, subject string, component templ.Component) error {
+	message, err := m.Render(to, subject, component)
+	if err != nil {
+		return err
+	}
+	return m.mail.SendMessage(message)
+}
+
+func (m *Mailer) SendPasswordReset(user model.User, link string) error {
+	return m.Send(user.Email, "Reset your password", PasswordReset(m.config, user, link))
+}
+
+func (m *Mailer) SendVerifyEmail(user model.User, link string) error {
+	return m.Send(user.Email, "Verify your email address", VerifyEmail(m.config, user, link))
+}
+
+// SendEmailChange asks to confirm the new address, user.Email has to be the new one
+func (m *Mailer) SendEmailChange(user model.User, link string) error {
+	return m.Send(user.Email, "Verify your new email address", EmailChange(m.config, user, link))
+}
+
+// RenderNotification renders a non-transactional mail of the category. It gets the List-Unsubscribe
+// headers for one-click unsubscribe (RFC 8058) and an unsubscribe link in the footer of the Layout
+func (m *Mailer) RenderNotification(user model.User, category, subject string, component templ.Component) (mail.Message, error) {
+	if _, err := notification.CategoryByKey(category); err != nil {
+		return mail.Message{}, err
+	}
+	link := m.config.App.Url + "/unsubscribe?token=" + notification.UnsubscribeToken(user.ID, category)
+	ctx := context.WithValue(context.Background(), unsubscribeLinkKey{}, link)
+	message, err := m.render(ctx, user.Email, subject, component)
+	if err != nil {
+		return mail.Message{}, err
+	}
+	message.Headers = map[string]string{
+		"List-Unsubscribe":      "<" + link + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+	message.Tags = map[string]string{"category": category}
+	return message, nil
+}
+
+// SendNotification sends a non-transactional mail of the category, unless the user unsubscribed from it.
+// Then notification.ErrUnsubscribed is returned
+func (m *Mailer) SendNotification(db *gorm.DB, user model.User, category, subject string, component templ.Component) error {
+	subscribed, err := notification.IsSubscribed(db, user.ID, category)
+	if err != nil {
+		return err
+	}
+	if !subscribed {
+		return notification.ErrUnsubscribed
+	}
+	message, err := m.RenderNotification(user, category, subject, component)
+	if err != nil {
+		return err
+	}
+	return m.mail.SendMessage(message)
+}
+
+// RenderBroadcast renders the broadcast of an admin for the user, see broadcast.Worker
+func (m *Mailer) RenderBroadcast(user model.User, broadcast model.Broadcast) (mail.Message, error) {
+	message, err := m.RenderNotification(user, broadcast.Category, broadcast.Subject, Broadcast(m.config, user, broadcast.Body))
+	if err != nil {
+		return mail.Message{}, err
+	}
+	if broadcast.ID != uuid.Nil {
+		message.Tags["broadcast"] = broadcast.ID.String()
+	}
+	return message, nil
+}
+
+type unsubscribeLinkKey struct{}
+
+// unsubscribeLink returns the link of a notification in the Layout, transactional mails have none
+func unsubscribeLink(ctx context.Context) string {
+	link, _ := ctx.Value(unsubscribeLinkKey{}).(string)
+	return link
+}
@@ -4,3 +4,8 @@ import "embed"
 
 //go:embed "assets"
 var Files embed.FS
+
+// Legal holds the versioned legal documents as Markdown, see config.Legal
+//
+//go:embed "legal"
+var Legal embed.FS
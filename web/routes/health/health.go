@@ -3,23 +3,71 @@ package health
 import (
 	"atomic-go-template/internal/config"
 	"atomic-go-template/internal/database"
+	"atomic-go-template/internal/mail"
 	"encoding/json"
-	"fmt"
 	"net/http"
 )
 
 type Health struct {
 	db     database.Service
 	config *config.Config
+	// The failover chain, nil if a single mail provider is used
+	failover *mail.FailoverService
 }
 
-func New(db database.Service, config *config.Config) *Health {
-	return &Health{db: db, config: config}
+func New(db database.Service, config *config.Config, failover *mail.FailoverService) *Health {
+	return &Health{db: db, config: config, failover: failover}
+}
+
+// providerHealth is the public state of a mail provider. The errors of the providers may name hosts
+// and accounts, admins see them at /admin/health
+type providerHealth struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
 }
 
 func (h *Health) GET(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("Config: %+v", h.config)
-	jsonResp, _ := json.Marshal(h.db.Health())
+	h.write(w, r, false)
+}
+
+// Admin shows the health with the state and last errors of the mail providers
+func (h *Health) Admin(w http.ResponseWriter, r *http.Request) {
+	h.write(w, r, true)
+}
+
+func (h *Health) write(w http.ResponseWriter, r *http.Request, detailed bool) {
+	health := map[string]interface{}{}
+	dbHealth := h.db.Health(r.Context())
+	for key, value := range dbHealth {
+		health[key] = value
+	}
+	// Shows which providers of the chain are working and how many mails each delivered
+	if h.failover != nil {
+		status := h.failover.Status()
+		if detailed {
+			health["mail_providers"] = status
+		} else {
+			providers := make([]providerHealth, 0, len(status))
+			for _, provider := range status {
+				// Open circuits are skipped, half-open ones only get a trial mail
+				healthy := "healthy"
+				if provider.State != "closed" {
+					healthy = "unhealthy"
+				}
+				providers = append(providers, providerHealth{Name: provider.Name, Status: healthy})
+			}
+			health["mail_providers"] = providers
+		}
+	}
+	// Shows the lag of the read replicas and whether they serve reads
+	if replicas := h.db.Replicas(); len(replicas) > 0 {
+		health["replicas"] = replicas
+	}
+	jsonResp, _ := json.Marshal(health)
 
+	// Load balancers take the instance out of rotation
+	if dbHealth["status"] == "down" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
 	_, _ = w.Write(jsonResp)
 }
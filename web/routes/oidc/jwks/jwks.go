@@ -0,0 +1,23 @@
+package jwks
+
+import (
+	"atomic-go-template/internal/oidc"
+	"encoding/json"
+	"net/http"
+)
+
+// Serves the public keys clients use to verify our tokens
+type Handler struct {
+	oidc *oidc.Provider
+}
+
+func New(oidc *oidc.Provider) *Handler {
+	return &Handler{oidc: oidc}
+}
+
+func (h *Handler) GET(w http.ResponseWriter, r *http.Request) {
+	jsonResp, _ := json.Marshal(h.oidc.JWKS())
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(jsonResp)
+}
@@ -0,0 +1,65 @@
+package token
+
+import (
+	"atomic-go-template/internal/oidc"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// The token endpoint exchanges authorization codes for tokens. It is called by the clients, not the browser
+type Handler struct {
+	oidc *oidc.Provider
+}
+
+func New(oidc *oidc.Provider) *Handler {
+	return &Handler{oidc: oidc}
+}
+
+func (h *Handler) POST(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, &oidc.Error{Code: oidc.ErrorInvalidRequest, Description: err.Error()})
+		return
+	}
+
+	req := oidc.TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+	}
+	// client_secret_basic
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		req.ClientID = clientID
+		req.ClientSecret = clientSecret
+	}
+
+	response, err := h.oidc.Exchange(req)
+	if err != nil {
+		var oidcErr *oidc.Error
+		if !errors.As(err, &oidcErr) {
+			fmt.Println("Error exchanging authorization code:", err)
+			writeJSON(w, http.StatusInternalServerError, &oidc.Error{Code: oidc.ErrorServerError})
+			return
+		}
+		status := http.StatusBadRequest
+		if oidcErr.Code == oidc.ErrorInvalidClient {
+			status = http.StatusUnauthorized
+		}
+		writeJSON(w, status, oidcErr)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	jsonResp, _ := json.Marshal(body)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(jsonResp)
+}
@@ -0,0 +1,60 @@
+package userinfo
+
+import (
+	"atomic-go-template/internal/oidc"
+	"atomic-go-template/internal/store"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// The userinfo endpoint returns the claims about the user of an access token
+type Handler struct {
+	users store.UserStore
+	oidc  *oidc.Provider
+}
+
+func New(users store.UserStore, oidc *oidc.Provider) *Handler {
+	return &Handler{users: users, oidc: oidc}
+}
+
+// GET and POST are both allowed by the specification
+func (h *Handler) GET(w http.ResponseWriter, r *http.Request) {
+	tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := h.oidc.VerifyAccessToken(tokenString)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, err := h.users.ByID(r.Context(), userID)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(oidc.UserClaims(user, claims.Scope))
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(jsonResp)
+}
+
+func (h *Handler) POST(w http.ResponseWriter, r *http.Request) {
+	h.GET(w, r)
+}
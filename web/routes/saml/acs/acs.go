@@ -0,0 +1,82 @@
+package acs
+
+import (
+	"atomic-go-template/internal/auth"
+	"atomic-go-template/internal/sso"
+	"atomic-go-template/internal/utils"
+	"atomic-go-template/web/components/common"
+	"atomic-go-template/web/routes/saml/login"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/a-h/templ"
+	"github.com/go-chi/chi/v5"
+)
+
+// The assertion consumer service. The identity provider posts the signed response here after the login
+type Handler struct {
+	sso *sso.Manager
+}
+
+func New(sso *sso.Manager) *Handler {
+	return &Handler{sso: sso}
+}
+
+func (h *Handler) POST(w http.ResponseWriter, r *http.Request) {
+	provider, err := h.sso.Provider(chi.URLParam(r, "idp"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Only responses to requests started in this browser are accepted
+	requestIDs := []string{}
+	if cookie, err := r.Cookie(login.RequestCookieName); err == nil {
+		requestIDs = append(requestIDs, cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     login.RequestCookieName,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+		Path:     r.URL.Path,
+	})
+
+	user, err := h.sso.Authenticate(r, provider, requestIDs)
+	if err != nil {
+		fmt.Println("Error authenticating SAML response:", err)
+		message := "Single sign-on failed. Please try again"
+		if errors.Is(err, auth.ErrAccountExists) {
+			message = "An account with your email exists already. Please log in with it"
+		}
+		w.WriteHeader(http.StatusForbidden)
+		templ.Handler(common.AlertWithLayout(r, common.AlertData{
+			AlertType: "error",
+			Message:   message,
+			ActionButton: &common.ActionButton{
+				Label: "Back to Login",
+				Url:   "/auth/login",
+			},
+		})).ServeHTTP(w, r)
+		return
+	}
+
+	if err := utils.CreateJWTCookie(w, user.ID.String()); err != nil {
+		templ.Handler(common.AlertWithLayout(r, common.AlertData{
+			AlertType: "error",
+			Message:   "Error creating JWT cookie: " + err.Error(),
+		})).ServeHTTP(w, r)
+		return
+	}
+
+	// The auth cookie is SameSite=Strict and not sent with a redirect from the cross-site post.
+	// The page redirects from our own site, so the cookie is sent with the next request
+	templ.Handler(common.AlertWithLayout(r, common.AlertData{
+		AlertType:    "success",
+		Message:      "Login successful",
+		RedirectUrl:  utils.SafeRedirectPath(r.PostForm.Get("RelayState"), "/"),
+		RedirectTime: 1,
+	})).ServeHTTP(w, r)
+}
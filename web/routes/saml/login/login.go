@@ -0,0 +1,51 @@
+package login
+
+import (
+	"atomic-go-template/internal/sso"
+	"atomic-go-template/internal/utils"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// RequestCookieName holds the ID of the pending authentication request, the ACS only accepts responses to it
+const RequestCookieName = "saml_request"
+
+// Sends the user to the identity provider
+type Handler struct {
+	sso *sso.Manager
+}
+
+func New(sso *sso.Manager) *Handler {
+	return &Handler{sso: sso}
+}
+
+func (h *Handler) GET(w http.ResponseWriter, r *http.Request) {
+	provider, err := h.sso.Provider(chi.URLParam(r, "idp"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The relay state comes back with the response and is where the user ends up after the login
+	next := utils.SafeRedirectPath(r.URL.Query().Get("next"), "/")
+	redirectUrl, requestID, err := provider.AuthenticationRequest(next)
+	if err != nil {
+		fmt.Println("Error creating SAML authentication request:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// The identity provider posts the response cross-site, so the cookie has to be SameSite=None
+	http.SetCookie(w, &http.Cookie{
+		Name:     RequestCookieName,
+		Value:    requestID,
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+		Path:     "/saml/" + provider.Name() + "/acs",
+	})
+	http.Redirect(w, r, redirectUrl, http.StatusFound)
+}
@@ -0,0 +1,34 @@
+package metadata
+
+import (
+	"atomic-go-template/internal/sso"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Serves the service provider metadata admins register at their identity provider
+type Handler struct {
+	sso *sso.Manager
+}
+
+func New(sso *sso.Manager) *Handler {
+	return &Handler{sso: sso}
+}
+
+func (h *Handler) GET(w http.ResponseWriter, r *http.Request) {
+	provider, err := h.sso.Provider(chi.URLParam(r, "idp"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	metadata, err := provider.Metadata()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	_, _ = w.Write(metadata)
+}
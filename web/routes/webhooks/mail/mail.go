@@ -0,0 +1,90 @@
+package mail_webhooks
+
+import (
+	"atomic-go-template/internal/mail"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Receives bounces and complaints from the mail providers and adds the addresses to the suppression list
+type Handler struct {
+	db *gorm.DB
+	// Signing secret of the webhook, see config.Suppression
+	secret string
+}
+
+func New(db *gorm.DB, secret string) *Handler {
+	return &Handler{db: db, secret: secret}
+}
+
+// Webhooks are small, larger bodies are rejected
+const maxBodySize = 1 << 20
+
+// Resend is the handler for the POST request of Resend webhooks
+func (h *Handler) Resend(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.read(w, r)
+	if !ok {
+		return
+	}
+	if err := mail.VerifyResendWebhook(h.secret, r.Header, body, time.Now()); err != nil {
+		h.error(w, http.StatusUnauthorized, err)
+		return
+	}
+	events, err := mail.ParseResendWebhook(body)
+	h.handle(w, events, err)
+}
+
+// Generic is the handler for the POST request of the JSON format, see mail.ParseGenericWebhook
+func (h *Handler) Generic(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.read(w, r)
+	if !ok {
+		return
+	}
+	if err := mail.VerifyGenericWebhook(h.secret, r.Header, body, time.Now()); err != nil {
+		h.error(w, http.StatusUnauthorized, err)
+		return
+	}
+	events, err := mail.ParseGenericWebhook(body)
+	h.handle(w, events, err)
+}
+
+func (h *Handler) read(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		h.error(w, http.StatusRequestEntityTooLarge, err)
+		return nil, false
+	}
+	return body, true
+}
+
+func (h *Handler) handle(w http.ResponseWriter, events []mail.DeliveryEvent, err error) {
+	if err != nil {
+		h.error(w, http.StatusBadRequest, err)
+		return
+	}
+	for _, event := range events {
+		if err := mail.HandleDeliveryEvent(h.db, event); err != nil {
+			// The provider retries the webhook
+			log.Printf("Error handling %s of %s: %v", event.Type, event.Email, err)
+			h.error(w, http.StatusInternalServerError, errors.New("error handling event"))
+			return
+		}
+		log.Printf("Mail %s for %s received from %s", event.Type, event.Email, event.Source)
+	}
+	jsonResp, _ := json.Marshal(map[string]int{"processed": len(events)})
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(jsonResp)
+}
+
+func (h *Handler) error(w http.ResponseWriter, status int, err error) {
+	jsonResp, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(jsonResp)
+}